@@ -6,10 +6,32 @@ import (
 	"strings"
 	"text/tabwriter"
 
+	"github.com/docker/go-units"
 	"github.com/urfave/cli/v2"
 	ctyp "github.com/xhebox/chrootd/cntr"
+	mtyp "github.com/xhebox/chrootd/meta"
 )
 
+func formatPorts(ports []mtyp.PortMapping) string {
+	res := []string{}
+	for _, p := range ports {
+		proto := p.Proto
+		if proto == "" {
+			proto = mtyp.ProtoTCP
+		}
+		res = append(res, fmt.Sprintf("%d->%d/%s", p.HostPort, p.ContainerPort, proto))
+	}
+	return strings.Join(res, ",")
+}
+
+// formatDisk shows the usage against the quota, if any.
+func formatDisk(usage, quota uint64) string {
+	if quota == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s / %s", units.BytesSize(float64(usage)), units.BytesSize(float64(quota)))
+}
+
 var CntrQuery = &cli.Command{
 	Name:      "list",
 	Usage:     "query all containers",
@@ -27,11 +49,11 @@ var CntrQuery = &cli.Command{
 
 		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
 
-		fmt.Fprintf(writer, "Name\tTags\tRootfs\tCntrId\tMetaID\tImage\n")
+		fmt.Fprintf(writer, "Name\tTags\tState\tIP\tPorts\tDisk\tRootfs\tCntrId\tMetaID\tImage\n")
 
 		err := user.Cntr.List(args, func(info *ctyp.Cntrinfo) error {
 			meta := info.Meta
-			fmt.Fprintf(writer, "%s\t%v\t%s\t%s\t%s\t%s:%s\n", meta.Name, info.Tags, info.Rootfs, info.Id, meta.Id, meta.Image, meta.ImageReference)
+			fmt.Fprintf(writer, "%s\t%v\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s:%s\n", meta.Name, info.Tags, info.State, info.IP, formatPorts(meta.Ports), formatDisk(info.DiskUsage, info.DiskQuota), info.Rootfs, info.Id, meta.Id, meta.Image, meta.ImageReference)
 			return nil
 		})
 		if err != nil {
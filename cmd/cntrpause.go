@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var CntrPause = &cli.Command{
+	Name:      "pause",
+	Usage:     "freeze all processes of a container",
+	ArgsUsage: "$cntrid",
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() < 1 {
+			return errors.New("must specify at least one argument")
+		}
+
+		cntr, err := user.Cntr.Get(c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		return cntr.Pause()
+	},
+}
+
+var CntrResume = &cli.Command{
+	Name:      "resume",
+	Usage:     "thaw a paused container",
+	ArgsUsage: "$cntrid",
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() < 1 {
+			return errors.New("must specify at least one argument")
+		}
+
+		cntr, err := user.Cntr.Get(c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		return cntr.Resume()
+	},
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var CntrUpdate = &cli.Command{
+	Name:      "update",
+	Usage:     "update resource limits of a running container",
+	Aliases:   []string{"u"},
+	ArgsUsage: "$cntrid",
+	Flags:     resourceFlags,
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() < 1 {
+			return errors.New("must specify at least one argument")
+		}
+
+		cntr, err := user.Cntr.Get(c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		res := &configs.Resources{}
+		resFromCli(res, c)
+
+		return cntr.UpdateResources(res)
+	},
+}
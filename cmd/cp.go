@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"github.com/xhebox/chrootd/utils"
+)
+
+// splitCntrPath splits '$cntrid:path', ok is false for local paths
+func splitCntrPath(arg string) (string, string, bool) {
+	idx := strings.Index(arg, ":")
+	if idx <= 0 || strings.Contains(arg[:idx], "/") {
+		return "", arg, false
+	}
+	return arg[:idx], arg[idx+1:], true
+}
+
+var Copy = &cli.Command{
+	Name:      "cp",
+	Usage:     "copy files between containers and the local filesystem, src is copied into the directory dst",
+	ArgsUsage: "src $cntrid:dst | $cntrid:src dst",
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() != 2 {
+			return errors.New("must specify two arguments")
+		}
+
+		srcID, src, srcOk := splitCntrPath(c.Args().Get(0))
+		dstID, dst, dstOk := splitCntrPath(c.Args().Get(1))
+
+		switch {
+		case dstOk && !srcOk:
+			cntr, err := user.Cntr.Get(dstID)
+			if err != nil {
+				return err
+			}
+
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(utils.Tar(pw, src, filepath.Base(filepath.Clean(src))))
+			}()
+			defer pr.Close()
+
+			return cntr.CopyTo(dst, pr)
+		case srcOk && !dstOk:
+			cntr, err := user.Cntr.Get(srcID)
+			if err != nil {
+				return err
+			}
+
+			rd, err := cntr.CopyFrom(src)
+			if err != nil {
+				return err
+			}
+			defer rd.Close()
+
+			return utils.Untar(rd, dst)
+		default:
+			return errors.New("one of the arguments should be of form '$cntrid:path'")
+		}
+	},
+}
@@ -36,7 +36,12 @@ var Exec = &cli.Command{
 		if err != nil {
 			return err
 		}
-		defer user.Meta.ImageDelete(id, rid)
+		// deferred deletes are for errors, ids are cleared once deleted
+		defer func() {
+			if rid != "" {
+				user.Meta.ImageDelete(id, rid)
+			}
+		}()
 
 		meta, err := user.Meta.Get(id)
 		if err != nil {
@@ -50,6 +55,11 @@ var Exec = &cli.Command{
 		if err != nil {
 			return err
 		}
+		defer func() {
+			if cid != "" {
+				user.Cntr.Delete(cid)
+			}
+		}()
 
 		cntr, err := user.Cntr.Get(cid)
 		if err != nil {
@@ -67,30 +77,31 @@ var Exec = &cli.Command{
 			return err
 		}
 
-		rw, err := cntr.Attach(tid)
+		code, err := attach(c.Context, cntr, tid, -1)
 		if err != nil {
 			return err
 		}
-		defer rw.Close()
 
-		err = attach(rw, c.Context)
+		err = cntr.StopAll(true)
 		if err != nil {
 			return err
 		}
 
-		err = cntr.Wait()
+		err = user.Cntr.Delete(cid)
+		cid = ""
 		if err != nil {
 			return err
 		}
 
-		err = cntr.StopAll(true)
+		// readonly rootfs may be shared with other containers
+		err = user.Meta.ImageDelete(id, rid)
 		if err != nil {
-			return err
+			user.Logger.Warn().Msgf("rootfs %s is left to be pruned: %s", rid, err)
 		}
+		rid = ""
 
-		err = user.Meta.ImageDelete(id, rid)
-		if err != nil {
-			return err
+		if code != 0 {
+			return cli.Exit("", code)
 		}
 
 		return nil
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var ImgCommit = &cli.Command{
+	Name:      "commit",
+	Aliases:   []string{"c"},
+	Usage:     "commit the changes of the rootfs into an image as a new layer, and tag it by the reference",
+	ArgsUsage: "$metaid $rootfsid $ref",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "image",
+			Usage: "`name` of the image, created if not exist, defaults to the image of the metadata",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() < 3 {
+			return errors.New("must specify at least three arguments")
+		}
+
+		sli := c.Args().Slice()
+
+		err := user.Meta.ImageCommit(c.Context, sli[0], sli[1], c.String("image"), sli[2])
+		if err != nil {
+			return err
+		}
+
+		user.Logger.Info().Msgf("committed rootfs %s as %s", sli[1], sli[2])
+
+		return nil
+	},
+}
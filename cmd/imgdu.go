@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+	"github.com/urfave/cli/v2"
+	mtyp "github.com/xhebox/chrootd/meta"
+)
+
+var ImgDiskUsage = &cli.Command{
+	Name:  "du",
+	Usage: "show bytes taken by rootfs, metadatas, images and unpacked layers on remote servers",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "kind",
+			Aliases: []string{"k"},
+			Usage:   "only show usages of kinds: rootfs, meta, image, layers",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		kinds := map[string]bool{}
+		for _, k := range c.StringSlice("kind") {
+			kinds[k] = true
+		}
+
+		var mu sync.Mutex
+		res := []mtyp.DiskUsage{}
+		err := user.Meta.DiskUsage(c.Context, func(u *mtyp.DiskUsage) error {
+			if len(kinds) == 0 || kinds[u.Kind] {
+				mu.Lock()
+				res = append(res, *u)
+				mu.Unlock()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(res, func(i, j int) bool {
+			if res[i].Node != res[j].Node {
+				return res[i].Node < res[j].Node
+			}
+			if res[i].Kind != res[j].Kind {
+				return res[i].Kind < res[j].Kind
+			}
+			return res[i].Name < res[j].Name
+		})
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
+
+		fmt.Fprintf(writer, "NodeID\tKind\tName\tSize\n")
+		for _, u := range res {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", u.Node, u.Kind, u.Name, units.BytesSize(float64(u.Size)))
+		}
+
+		writer.Flush()
+
+		return nil
+	},
+}
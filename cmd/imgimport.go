@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var ImgImport = &cli.Command{
+	Name:      "import",
+	Usage:     "import a docker-archive or an oci-archive as an image, read from stdin if file is '-'",
+	ArgsUsage: "$image $file",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "node",
+			Usage: "suggest which node the image will be imported on",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() != 2 {
+			return errors.New("must specify two arguments")
+		}
+
+		var rd io.Reader = os.Stdin
+		if file := c.Args().Get(1); file != "-" {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			rd = f
+		}
+
+		err := user.Meta.ImageImport(c.Context, c.String("node"), c.Args().Get(0), rd)
+		if err != nil {
+			return err
+		}
+
+		user.Logger.Info().Msgf("imported image %s", c.Args().Get(0))
+
+		return nil
+	},
+}
+
+var ImgExport = &cli.Command{
+	Name:      "export",
+	Usage:     "export a reference of an image as an oci-archive, written to stdout if file is '-'",
+	ArgsUsage: "$image $ref $file",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "node",
+			Usage: "`node` where the image is",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() != 3 {
+			return errors.New("must specify three arguments")
+		}
+
+		rd, err := user.Meta.ImageExport(c.String("node"), c.Args().Get(0), c.Args().Get(1))
+		if err != nil {
+			return err
+		}
+		defer rd.Close()
+
+		var w io.Writer = os.Stdout
+		if file := c.Args().Get(2); file != "-" {
+			f, err := os.Create(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+
+		_, err = io.Copy(w, rd)
+		return err
+	},
+}
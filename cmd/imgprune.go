@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+var ImgPrune = &cli.Command{
+	Name:  "prune",
+	Usage: "remove rootfs not used by any container, and leftovers of crashed clients",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "grace",
+			Usage: "only remove rootfs not used for this long",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		ids, err := user.Meta.ImagePrune(c.Duration("grace"))
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+		return err
+	},
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	mtyp "github.com/xhebox/chrootd/meta"
+)
+
+var ImgPull = &cli.Command{
+	Name:      "pull",
+	Usage:     "pull an image from a registry, tagged by the tag of the reference",
+	ArgsUsage: "registry/repo:tag",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "node",
+			Usage: "suggest which node the image will be pulled on",
+		},
+		&cli.StringFlag{
+			Name:  "image",
+			Usage: "`name` of the image, defaults to the last component of the repository",
+		},
+		&cli.StringFlag{
+			Name:  "platform",
+			Usage: "pull the image of the `os/arch[/variant]` from an index, defaults to the one of the node",
+		},
+		&cli.StringFlag{
+			Name:  "username",
+			Usage: "username of the registry",
+		},
+		&cli.StringFlag{
+			Name:    "password",
+			Usage:   "password of the registry",
+			EnvVars: []string{"CHROOTD_REGISTRY_PASSWORD"},
+		},
+		&cli.BoolFlag{
+			Name:  "plain-http",
+			Usage: "access the registry by http instead of https",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() != 1 {
+			return errors.New("must specify one argument")
+		}
+
+		err := user.Meta.ImagePull(c.Context, &mtyp.PullOptions{
+			Node:      c.String("node"),
+			Image:     c.String("image"),
+			Reference: c.Args().First(),
+			Platform:  c.String("platform"),
+			Username:  c.String("username"),
+			Password:  c.String("password"),
+			PlainHTTP: c.Bool("plain-http"),
+		})
+		if err != nil {
+			return err
+		}
+
+		user.Logger.Info().Msgf("pulled image %s", c.Args().First())
+
+		return nil
+	},
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	ctyp "github.com/xhebox/chrootd/cntr"
+)
+
+// parseSince accepts a timestamp, or a duration relative to now
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, errors.Errorf("invalid since value: %s", s)
+	}
+	return t, nil
+}
+
+var Logs = &cli.Command{
+	Name:      "logs",
+	Usage:     "show the logs of a task",
+	ArgsUsage: "$cntrid $taskid",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "follow",
+			Aliases: []string{"f"},
+			Usage:   "keep streaming until the task exits",
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "only show logs since a RFC3339 `timestamp` or a relative duration like 10m",
+		},
+		&cli.IntFlag{
+			Name:  "tail",
+			Value: -1,
+			Usage: "only show the last `N` lines, negative for all",
+		},
+		&cli.BoolFlag{
+			Name:    "timestamps",
+			Aliases: []string{"t"},
+			Usage:   "prefix every line with its timestamp",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() < 2 {
+			return errors.New("must specify at least two arguments")
+		}
+
+		args := c.Args().Slice()
+
+		opts := &ctyp.LogOptions{
+			Follow: c.Bool("follow"),
+			Tail:   c.Int("tail"),
+		}
+
+		if c.IsSet("since") {
+			since, err := parseSince(c.String("since"))
+			if err != nil {
+				return err
+			}
+			opts.Since = since
+		}
+
+		cntr, err := user.Cntr.Get(args[0])
+		if err != nil {
+			return err
+		}
+
+		return cntr.Logs(c.Context, args[1], opts, func(e *ctyp.LogEntry) error {
+			out := os.Stdout
+			if e.Stream == "stderr" {
+				out = os.Stderr
+			}
+
+			if c.Bool("timestamps") {
+				fmt.Fprintf(out, "%s ", e.Time.Local().Format(time.RFC3339Nano))
+			}
+
+			_, err := fmt.Fprint(out, e.Log)
+			return err
+		})
+	},
+}
@@ -16,6 +16,8 @@ import (
 	cpro "github.com/xhebox/chrootd/cntr/proxy"
 	mtyp "github.com/xhebox/chrootd/meta"
 	mpro "github.com/xhebox/chrootd/meta/proxy"
+	vtyp "github.com/xhebox/chrootd/volume"
+	vpro "github.com/xhebox/chrootd/volume/proxy"
 )
 
 type User struct {
@@ -28,8 +30,9 @@ type User struct {
 	Consul *api.Client
 	Client client.Client
 
-	Meta mtyp.Manager
-	Cntr ctyp.Manager
+	Meta   mtyp.Manager
+	Cntr   ctyp.Manager
+	Volume vtyp.Manager
 }
 
 func main() {
@@ -62,7 +65,7 @@ func main() {
 				},
 				&cli.StringFlag{
 					Name:        "attach_addr",
-					Usage:       "chrootd task attach addr",
+					Usage:       "chrootd task attach, image import and export addr",
 					Value:       ":9092",
 					Destination: &u.AttachAddr,
 				},
@@ -92,6 +95,9 @@ func main() {
 					CntrDelete,
 					CntrGet,
 					CntrQuery,
+					CntrPause,
+					CntrResume,
+					CntrUpdate,
 				},
 			},
 			&cli.Command{
@@ -112,11 +118,31 @@ func main() {
 					ImgUnpack,
 					ImgList,
 					ImgRemove,
+					ImgPrune,
+					ImgDiskUsage,
+					ImgCommit,
+					ImgImport,
+					ImgExport,
+					ImgPull,
+				},
+			},
+			&cli.Command{
+				Name:  "volume",
+				Usage: "manage volumes",
+				Subcommands: cli.Commands{
+					VolumeCreate,
+					VolumeList,
+					VolumeInspect,
+					VolumeDelete,
 				},
 			},
 			Start,
 			Stop,
 			Exec,
+			Logs,
+			Stats,
+			PortForward,
+			Copy,
 		},
 		Before: func(c *cli.Context) error {
 			user := c.Context.Value("_data").(*User)
@@ -133,7 +159,7 @@ func main() {
 					return err
 				}
 
-				user.Meta, err = mpro.NewMetaProxy("meta", user.Consul)
+				user.Meta, err = mpro.NewMetaProxy("meta", user.Consul, nil)
 				if err != nil {
 					return err
 				}
@@ -142,23 +168,33 @@ func main() {
 				if err != nil {
 					return err
 				}
+
+				user.Volume, err = vpro.NewVolumeProxy("volume", user.Consul)
+				if err != nil {
+					return err
+				}
 			} else {
 				user.Client, err = client.NewClient("tcp", user.ServerAddr)
 				if err != nil {
 					return err
 				}
 
-				user.Meta, err = mpro.NewMetaProxy("meta", user.Client)
+				attach := utils.NewAddrString("tcp", user.AttachAddr)
+
+				user.Meta, err = mpro.NewMetaProxy("meta", user.Client, attach)
 				if err != nil {
 					return err
 				}
 
-				attach := utils.NewAddrString("tcp", user.AttachAddr)
-
 				user.Cntr, err = cpro.NewCntrProxy("cntr", user.Client, attach)
 				if err != nil {
 					return err
 				}
+
+				user.Volume, err = vpro.NewVolumeProxy("volume", user.Client)
+				if err != nil {
+					return err
+				}
 			}
 
 			if c.IsSet("oauth_token") {
@@ -192,6 +228,10 @@ func main() {
 				user.Cntr.Close()
 			}
 
+			if user.Volume != nil {
+				user.Volume.Close()
+			}
+
 			if user.Client != nil {
 				user.Client.Close()
 			}
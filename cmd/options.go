@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
@@ -13,6 +14,7 @@ import (
 	ctyp "github.com/xhebox/chrootd/cntr"
 	mtyp "github.com/xhebox/chrootd/meta"
 	"github.com/xhebox/chrootd/utils"
+	vtyp "github.com/xhebox/chrootd/volume"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -95,13 +97,20 @@ func mountFromCli(res *[]rspec.Mount, c *cli.Context) error {
 	if c.IsSet("mount") {
 		mounts := c.StringSlice("mount")
 		for _, mnt := range mounts {
+			// sources of volumes are kept with the prefix
+			prefix := ""
+			if strings.HasPrefix(mnt, vtyp.MountPrefix) {
+				prefix = vtyp.MountPrefix
+				mnt = strings.TrimPrefix(mnt, prefix)
+			}
+
 			args := strings.SplitN(mnt, ":", 2)
 			if len(args) != 2 {
 				return errors.New("invalid mount flag")
 			}
 			*res = append(*res, rspec.Mount{
 				Type:        "bind",
-				Source:      args[0],
+				Source:      prefix + args[0],
 				Destination: args[1],
 			})
 		}
@@ -109,6 +118,93 @@ func mountFromCli(res *[]rspec.Mount, c *cli.Context) error {
 	return nil
 }
 
+func netFromCli(res *mtyp.Network, c *cli.Context) {
+	if c.IsSet("network") {
+		res.Mode = c.String("network")
+	}
+
+	if c.IsSet("ip") {
+		res.Addresses = c.StringSlice("ip")
+	}
+
+	if c.IsSet("host-loopback") {
+		res.HostLoopback = c.Bool("host-loopback")
+	}
+
+	if c.IsSet("route") {
+		res.Routes = nil
+		for _, r := range c.StringSlice("route") {
+			args := strings.SplitN(r, "@", 2)
+			route := mtyp.Route{Destination: args[0]}
+			if len(args) == 2 {
+				route.Gateway = args[1]
+			}
+			res.Routes = append(res.Routes, route)
+		}
+	}
+}
+
+func portsFromCli(res *[]mtyp.PortMapping, c *cli.Context) error {
+	if !c.IsSet("publish") {
+		return nil
+	}
+
+	*res = nil
+	for _, p := range c.StringSlice("publish") {
+		port := mtyp.PortMapping{}
+		args := strings.SplitN(p, "/", 2)
+		if len(args) == 2 {
+			port.Proto = args[1]
+		}
+
+		ports := strings.SplitN(args[0], ":", 2)
+		if len(ports) != 2 {
+			return errors.Errorf("invalid publish flag %s", p)
+		}
+
+		host, err := strconv.ParseUint(ports[0], 10, 16)
+		if err != nil {
+			return errors.Errorf("invalid host port %s", ports[0])
+		}
+
+		cntr, err := strconv.ParseUint(ports[1], 10, 16)
+		if err != nil {
+			return errors.Errorf("invalid container port %s", ports[1])
+		}
+
+		port.HostPort = uint16(host)
+		port.ContainerPort = uint16(cntr)
+		*res = append(*res, port)
+	}
+	return nil
+}
+
+func seccompFromCli(res **rspec.LinuxSeccomp, c *cli.Context) error {
+	if !c.IsSet("seccomp") {
+		return nil
+	}
+
+	switch v := c.String("seccomp"); v {
+	case "default":
+		*res = nil
+	case "unconfined":
+		*res = &rspec.LinuxSeccomp{}
+	default:
+		b, err := ioutil.ReadFile(v)
+		if err != nil {
+			return err
+		}
+
+		profile := &rspec.LinuxSeccomp{}
+		err = json.Unmarshal(b, profile)
+		if err != nil {
+			return errors.Wrapf(err, "invalid seccomp profile %s", v)
+		}
+		*res = profile
+	}
+	return nil
+}
+
 func MetaFromCli(c *cli.Context) (*mtyp.Metainfo, error) {
 	res := &mtyp.Metainfo{}
 
@@ -157,6 +253,22 @@ func MetaFromCli(c *cli.Context) (*mtyp.Metainfo, error) {
 
 	mountFromCli(&res.Mount, c)
 
+	netFromCli(&res.Network, c)
+
+	if c.IsSet("disk-quota") {
+		res.DiskQuota = uint64(c.Int64("disk-quota"))
+	}
+
+	err := portsFromCli(&res.Ports, c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = seccompFromCli(&res.Seccomp, c)
+	if err != nil {
+		return nil, err
+	}
+
 	return res, nil
 }
 
@@ -179,14 +291,26 @@ func TaskFromCli(c *cli.Context) (*ctyp.Taskinfo, error) {
 		res.Args = c.Args().Slice()
 	}
 
-	if len(res.Args) == 0 {
-		return nil, errors.New("lack program executable path")
-	}
-
 	if c.IsSet("env") {
 		res.Env = c.StringSlice("env")
 	}
 
+	if c.IsSet("cwd") {
+		res.Cwd = c.String("cwd")
+	}
+
+	if c.IsSet("user") {
+		res.User = c.String("user")
+	}
+
+	if c.IsSet("group-add") {
+		res.AdditionalGroups = c.StringSlice("group-add")
+	}
+
+	if c.IsSet("tty") {
+		res.Tty = c.Bool("tty")
+	}
+
 	width, height, err := terminal.GetSize(int(os.Stdout.Fd()))
 	if err == nil {
 		res.TermHeight = uint16(height)
@@ -208,6 +332,23 @@ var (
 			Name:  "file",
 			Usage: "read config from file",
 		},
+		&cli.StringFlag{
+			Name:  "cwd",
+			Usage: "working directory, default to the one of the image",
+		},
+		&cli.StringFlag{
+			Name:    "user",
+			Aliases: []string{"u"},
+			Usage:   "run as 'user[:group]', by names or ids",
+		},
+		&cli.StringSliceFlag{
+			Name:  "group-add",
+			Usage: "additional groups, by names or ids",
+		},
+		&cli.BoolFlag{
+			Name:  "tty",
+			Usage: "allocate a pseudo-terminal",
+		},
 	}
 
 	capFlags = []cli.Flag{
@@ -317,7 +458,36 @@ var (
 		},
 		&cli.StringSliceFlag{
 			Name:  "mount",
-			Usage: "mount directories or files, arguments should be of form 'src:dst'",
+			Usage: "mount directories or files, arguments should be of form 'src:dst', or 'volume:name:dst' for volumes",
+		},
+		&cli.StringFlag{
+			Name:  "network",
+			Usage: "network `mode`: none, host, bridge or slirp",
+		},
+		&cli.StringSliceFlag{
+			Name:  "ip",
+			Usage: "static addresses in bridge mode, allocated from the bridge subnet if not set",
+		},
+		&cli.BoolFlag{
+			Name:  "host-loopback",
+			Usage: "let the gateway of slirp mode reach services listening on the loopback of the host",
+		},
+		&cli.StringSliceFlag{
+			Name:  "route",
+			Usage: "additional routes in bridge mode, arguments should be of form 'dst/prefix[@gateway]'",
+		},
+		&cli.StringSliceFlag{
+			Name:    "publish",
+			Aliases: []string{"p"},
+			Usage:   "publish ports of the container, arguments should be of form 'host:container[/tcp|udp]'",
+		},
+		&cli.StringFlag{
+			Name:  "seccomp",
+			Usage: "seccomp `profile`: default, unconfined or a json file of the OCI profile",
+		},
+		&utils.SizeFlag{
+			Name:  "disk-quota",
+			Usage: "limit bytes written to the rootfs",
 		},
 		&cli.StringFlag{
 			Name:  "file",
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"github.com/xhebox/chrootd/utils"
+)
+
+var PortForward = &cli.Command{
+	Name:      "port-forward",
+	Usage:     "forward a local port to a tcp port of the container",
+	ArgsUsage: "$cntrid [addr:]local:remote",
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() < 2 {
+			return errors.New("must specify at least two arguments")
+		}
+
+		args := c.Args().Slice()
+
+		idx := strings.LastIndex(args[1], ":")
+		if idx < 0 {
+			return errors.New("ports should be of form 'local:remote'")
+		}
+
+		local := args[1][:idx]
+		if !strings.Contains(local, ":") {
+			local = net.JoinHostPort("127.0.0.1", local)
+		}
+
+		remote, err := strconv.ParseUint(args[1][idx+1:], 10, 16)
+		if err != nil {
+			return errors.Errorf("invalid remote port %s", args[1][idx+1:])
+		}
+
+		cntr, err := user.Cntr.Get(args[0])
+		if err != nil {
+			return err
+		}
+
+		l, err := net.Listen("tcp", local)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+
+		go func() {
+			<-c.Context.Done()
+			l.Close()
+		}()
+
+		user.Logger.Info().Msgf("forwarding %s to port %d", l.Addr(), remote)
+
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if c.Context.Err() != nil {
+					return nil
+				}
+				return err
+			}
+
+			go func() {
+				target, err := cntr.PortForward(uint16(remote))
+				if err != nil {
+					user.Logger.Error().Msg(err.Error())
+					conn.Close()
+					return
+				}
+
+				utils.Splice(conn, target)
+			}()
+		}
+	},
+}
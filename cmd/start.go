@@ -48,12 +48,11 @@ var Start = &cli.Command{
 			return err
 		}
 
-		var task *ctyp.Taskinfo
-		if c.Args().Len() > 0 {
-			task, err = TaskFromCli(c)
-			if err != nil {
-				return err
-			}
+		// without args, the task starts by the image config, containers
+		// without both are deleted
+		task, err := TaskFromCli(c)
+		if err != nil {
+			return err
 		}
 
 		var rid string
@@ -77,19 +76,20 @@ var Start = &cli.Command{
 
 			user.Logger.Info().Msgf("started container %s", cid)
 
-			if task != nil {
-				cntr, err := user.Cntr.Get(cid)
-				if err != nil {
-					return err
-				}
+			cntr, err := user.Cntr.Get(cid)
+			if err != nil {
+				return err
+			}
 
-				tid, err := cntr.Start(task)
-				if err != nil {
-					return err
+			tid, err := cntr.Start(task)
+			if err != nil {
+				if derr := user.Cntr.Delete(cid); derr != nil {
+					user.Logger.Warn().Msgf("container %s is left: %s", cid, derr)
 				}
-
-				user.Logger.Info().Msgf("started task %s", tid)
+				return err
 			}
+
+			user.Logger.Info().Msgf("started task %s", tid)
 		}
 
 		return nil
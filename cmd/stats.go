@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/urfave/cli/v2"
+	ctyp "github.com/xhebox/chrootd/cntr"
+)
+
+type statsRow struct {
+	cur  *ctyp.Stats
+	prev *ctyp.Stats
+	err  error
+}
+
+func (r *statsRow) cpuPercent() string {
+	if r.cur == nil || r.prev == nil {
+		return "-"
+	}
+
+	dt := r.cur.Time.Sub(r.prev.Time)
+	if dt <= 0 || r.cur.CpuTotal < r.prev.CpuTotal {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.2f%%", float64(r.cur.CpuTotal-r.prev.CpuTotal)/float64(dt)*100)
+}
+
+func renderStats(w io.Writer, ids []string, rows map[string]*statsRow) {
+	writer := tabwriter.NewWriter(w, 0, 0, 4, ' ', 0)
+
+	fmt.Fprintf(writer, "CntrId\tCPU%%\tCPUTime\tMemUsage/Limit\tMemMax\tPids\tBlockIO\tDisk\n")
+
+	for _, id := range ids {
+		r, ok := rows[id]
+		if !ok || r.cur == nil {
+			msg := "-"
+			if ok && r.err != nil {
+				msg = r.err.Error()
+			}
+			fmt.Fprintf(writer, "%s\t%s\n", id, msg)
+			continue
+		}
+
+		st := r.cur
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s / %s\t%s\t%d\t%s / %s\t%s\n",
+			id,
+			r.cpuPercent(),
+			time.Duration(st.CpuTotal).Round(time.Millisecond),
+			units.BytesSize(float64(st.MemoryUsage)),
+			units.BytesSize(float64(st.MemoryLimit)),
+			units.BytesSize(float64(st.MemoryMax)),
+			st.Pids,
+			units.BytesSize(float64(st.BlkioRead)),
+			units.BytesSize(float64(st.BlkioWrite)),
+			formatDisk(st.DiskUsage, st.DiskQuota),
+		)
+	}
+
+	writer.Flush()
+}
+
+var Stats = &cli.Command{
+	Name:      "stats",
+	Usage:     "show resource usage of containers, all containers by default",
+	ArgsUsage: "[$cntrid...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "watch",
+			Aliases: []string{"w"},
+			Usage:   "keep refreshing the table",
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Value: time.Second,
+			Usage: "refresh `interval` when watching",
+		},
+		&cli.StringFlag{
+			Name:    "tag",
+			Aliases: []string{"t"},
+			Usage:   "only show containers with the tag",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		ids := c.Args().Slice()
+		if len(ids) == 0 {
+			query := "[]"
+			if c.IsSet("tag") {
+				query = c.String("tag")
+			}
+
+			err := user.Cntr.List(query, func(info *ctyp.Cntrinfo) error {
+				ids = append(ids, info.Id)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		sort.Strings(ids)
+
+		rows := make(map[string]*statsRow)
+
+		if !c.Bool("watch") {
+			for _, id := range ids {
+				r := &statsRow{}
+				rows[id] = r
+
+				cntr, err := user.Cntr.Get(id)
+				if err != nil {
+					r.err = err
+					continue
+				}
+
+				r.cur, r.err = cntr.Stats()
+				if r.err != nil {
+					r.cur = nil
+				}
+			}
+
+			renderStats(os.Stdout, ids, rows)
+			return nil
+		}
+
+		var mu sync.Mutex
+		for _, id := range ids {
+			r := &statsRow{}
+			rows[id] = r
+
+			go func(id string, r *statsRow) {
+				cntr, err := user.Cntr.Get(id)
+				if err == nil {
+					err = cntr.WatchStats(c.Context, c.Duration("interval"), func(st *ctyp.Stats) error {
+						mu.Lock()
+						r.prev, r.cur = r.cur, st
+						mu.Unlock()
+						return nil
+					})
+				}
+
+				mu.Lock()
+				r.err = err
+				r.cur = nil
+				mu.Unlock()
+			}(id, r)
+		}
+
+		ticker := time.NewTicker(c.Duration("interval"))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-c.Context.Done():
+				return nil
+			}
+
+			mu.Lock()
+			// clear the screen before redrawing
+			fmt.Print("\033[H\033[2J")
+			renderStats(os.Stdout, ids, rows)
+			mu.Unlock()
+		}
+	},
+}
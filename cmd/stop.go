@@ -5,34 +5,35 @@ import (
 	ctyp "github.com/xhebox/chrootd/cntr"
 )
 
-func stopCntr(user *User, c *cli.Context, v string) (string, error) {
+func stopCntr(user *User, c *cli.Context, v string) (*ctyp.Cntrinfo, error) {
 	cntr, err := user.Cntr.Get(v)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	meta, err := cntr.Meta()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	err = cntr.StopAll(c.Bool("kill"))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	user.Logger.Info().Msgf("stopped %s", v)
 
-	if c.Bool("delete") {
+	// rootfs can not be removed while containers use it
+	if c.Bool("delete") || c.Bool("rmimg") {
 		err = user.Cntr.Delete(v)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		user.Logger.Info().Msgf("deleted %s", v)
 	}
 
-	return meta.Rootfs, nil
+	return meta, nil
 }
 
 var Stop = &cli.Command{
@@ -60,7 +61,7 @@ var Stop = &cli.Command{
 			Name:    "rmimg",
 			Aliases: []string{"r"},
 			Value:   false,
-			Usage:   "also remove rootfs",
+			Usage:   "also remove rootfs, implies delete",
 		},
 	},
 	ArgsUsage: "[$cntr1id ... $cntrXid]",
@@ -71,13 +72,13 @@ var Stop = &cli.Command{
 
 		if c.Args().Len() > 0 {
 			for _, v := range c.Args().Slice() {
-				rid, err := stopCntr(user, c, v)
+				info, err := stopCntr(user, c, v)
 				if err != nil {
 					return err
 				}
 
 				if rmimg {
-					err = user.Meta.ImageDelete(v, rid)
+					err = user.Meta.ImageDelete(info.Meta.Id, info.Rootfs)
 					if err != nil {
 						return err
 					}
@@ -96,19 +97,19 @@ var Stop = &cli.Command{
 				return err
 			}
 
-			rids := []string{}
+			infos := []*ctyp.Cntrinfo{}
 
 			for _, v := range res {
-				rid, err := stopCntr(user, c, v)
+				info, err := stopCntr(user, c, v)
 				if err != nil {
 					return err
 				}
-				rids = append(rids, rid)
+				infos = append(infos, info)
 			}
 
 			if rmimg {
-				for k, v := range res {
-					err = user.Meta.ImageDelete(v, rids[k])
+				for _, info := range infos {
+					err = user.Meta.ImageDelete(info.Meta.Id, info.Rootfs)
 					if err != nil {
 						return err
 					}
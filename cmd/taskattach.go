@@ -1,68 +1,90 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"io"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
-	"time"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	ctyp "github.com/xhebox/chrootd/cntr"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-func attach(rw ctyp.Attacher, ctx context.Context) error {
-	ch := make(chan bool, 1)
-	data := make(chan []byte, 1)
+func attach(ctx context.Context, cntr ctyp.Cntr, tid string, scrollback int) (int, error) {
+	info, err := cntr.TaskInfo(tid)
+	if err != nil {
+		return -1, err
+	}
+
+	rw, err := cntr.Attach(tid, scrollback)
+	if err != nil {
+		return -1, err
+	}
+	defer rw.Close()
+
 	h := make(chan os.Signal, 1)
 
-	signal.Notify(h, syscall.SIGINT, syscall.SIGTERM)
+	fd := int(os.Stdin.Fd())
+	if info.Tty && terminal.IsTerminal(fd) {
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return -1, err
+		}
+		defer terminal.Restore(fd, state)
+
+		signal.Notify(h, syscall.SIGWINCH)
+		h <- syscall.SIGWINCH
+	} else {
+		signal.Notify(h, syscall.SIGINT, syscall.SIGTERM)
+	}
+	defer signal.Stop(h)
 
 	go func() {
-		io.Copy(os.Stdout, rw)
-		ch <- true
+		io.Copy(rw, os.Stdin)
+		rw.CloseWrite()
 	}()
-	go func() {
-		buf := make([]byte, 1024)
-
-		for {
-			n, err := os.Stdin.Read(buf)
-			if err != nil {
-				if err == io.EOF {
-					return
-				}
-			}
 
-			data <- buf[:n]
-		}
+	ch := make(chan bool, 1)
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			io.Copy(os.Stdout, rw)
+			wg.Done()
+		}()
+		go func() {
+			io.Copy(os.Stderr, rw.Stderr())
+			wg.Done()
+		}()
+		wg.Wait()
+		ch <- true
 	}()
 
-outer:
 	for {
 		select {
-		case <-h:
-			_, err := io.Copy(rw, bytes.NewReader([]byte{0x03}))
+		case sig := <-h:
+			if sig == syscall.SIGWINCH {
+				width, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+				if err == nil {
+					rw.Resize(uint16(width), uint16(height))
+				}
+				continue
+			}
+
+			err := rw.Signal(sig.(syscall.Signal))
 			if err != nil {
-				return err
+				return -1, err
 			}
 		case <-ch:
-			break outer
+			return rw.Wait()
 		case <-ctx.Done():
-			break outer
-		case d := <-data:
-			_, err := io.Copy(rw, bytes.NewReader(d))
-			if err != nil {
-				return err
-			}
-		default:
-			time.Sleep(25 * time.Millisecond)
+			return -1, ctx.Err()
 		}
 	}
-
-	return nil
 }
 
 var TaskAttach = &cli.Command{
@@ -70,6 +92,13 @@ var TaskAttach = &cli.Command{
 	Usage:     "attach a task",
 	Aliases:   []string{"a"},
 	ArgsUsage: "$cntrid $taskid",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "scrollback",
+			Value: -1,
+			Usage: "replay the last `N` bytes of output, negative to replay everything buffered",
+		},
+	},
 	Action: func(c *cli.Context) error {
 		user := c.Context.Value("_data").(*User)
 
@@ -84,15 +113,13 @@ var TaskAttach = &cli.Command{
 			return err
 		}
 
-		rw, err := cntr.Attach(args[1])
+		code, err := attach(c.Context, cntr, args[1], c.Int("scrollback"))
 		if err != nil {
 			return err
 		}
-		defer rw.Close()
 
-		err = attach(rw, c.Context)
-		if err != nil {
-			return err
+		if code != 0 {
+			return cli.Exit("", code)
 		}
 
 		return nil
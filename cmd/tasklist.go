@@ -2,11 +2,24 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
+	ctyp "github.com/xhebox/chrootd/cntr"
 )
 
+func fmtTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
 var TaskList = &cli.Command{
 	Name:      "list",
 	Usage:     "list all tasks of a container",
@@ -24,14 +37,43 @@ var TaskList = &cli.Command{
 			return err
 		}
 
+		tids := []string{}
 		err = cntr.List(func(tid string) error {
-			fmt.Println(tid)
+			tids = append(tids, tid)
 			return nil
 		})
 		if err != nil {
 			return err
 		}
 
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
+
+		fmt.Fprintf(writer, "TaskID\tState\tExitCode\tSignal\tStarted\tFinished\tArgs\n")
+
+		for _, tid := range tids {
+			st, err := cntr.TaskInfo(tid)
+			if err != nil {
+				// tasks may expire after being listed
+				if err.Error() == ctyp.ErrTaskNotFound.Error() {
+					continue
+				}
+				return err
+			}
+
+			exit, sig := "-", "-"
+			switch st.State {
+			case ctyp.TaskKilled:
+				sig = syscall.Signal(st.Signal).String()
+				fallthrough
+			case ctyp.TaskExited:
+				exit = fmt.Sprint(st.ExitCode)
+			}
+
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", tid, st.State, exit, sig, fmtTime(st.StartedAt), fmtTime(st.FinishedAt), strings.Join(st.Args, " "))
+		}
+
+		writer.Flush()
+
 		return nil
 	},
 }
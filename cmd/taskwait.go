@@ -7,9 +7,9 @@ import (
 
 var TaskWait = &cli.Command{
 	Name:      "wait",
-	Usage:     "wait all tasks of containers done",
+	Usage:     "wait a task done and exit with its code, no taskid meaning wait all tasks of the container",
 	Aliases:   []string{"w"},
-	ArgsUsage: "$cntrid",
+	ArgsUsage: "$cntrid [$taskid]",
 	Action: func(c *cli.Context) error {
 		user := c.Context.Value("_data").(*User)
 
@@ -24,6 +24,19 @@ var TaskWait = &cli.Command{
 			return err
 		}
 
+		if len(args) > 1 {
+			code, err := cntr.WaitTask(c.Context, args[1])
+			if err != nil {
+				return err
+			}
+
+			if code != 0 {
+				return cli.Exit("", code)
+			}
+
+			return nil
+		}
+
 		err = cntr.Wait()
 		if err != nil {
 			return err
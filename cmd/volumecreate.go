@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"github.com/xhebox/chrootd/utils"
+	vtyp "github.com/xhebox/chrootd/volume"
+)
+
+var VolumeCreate = &cli.Command{
+	Name:      "create",
+	Usage:     "create a volume, mounted by metadatas as 'volume:name:dst'",
+	Aliases:   []string{"c"},
+	ArgsUsage: "$name",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "node",
+			Usage: "suggest which node this volume will be created on",
+		},
+		&utils.SizeFlag{
+			Name:  "size",
+			Usage: "limit the volume to `size` bytes, unlimited if not set",
+		},
+		&cli.StringSliceFlag{
+			Name:    "label",
+			Aliases: []string{"l"},
+			Usage:   "labels of form 'key=value'",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		if c.Args().Len() != 1 {
+			return errors.New("expect a volume name")
+		}
+
+		vol := &vtyp.Volume{
+			Name:   c.Args().First(),
+			Node:   c.String("node"),
+			Size:   uint64(c.Int64("size")),
+			Labels: map[string]string{},
+		}
+
+		for _, l := range c.StringSlice("label") {
+			kv := strings.SplitN(l, "=", 2)
+			if len(kv) != 2 {
+				return errors.Errorf("invalid label %s", l)
+			}
+			vol.Labels[kv[0]] = kv[1]
+		}
+
+		id, err := user.Volume.Create(vol)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("VolumeID is %s\n", id)
+
+		return nil
+	},
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/urfave/cli/v2"
+)
+
+var VolumeDelete = &cli.Command{
+	Name:      "delete",
+	Usage:     "delete volumes with their data",
+	Aliases:   []string{"d", "rm"},
+	ArgsUsage: "[$volumeid1, ..., $volumeidX]",
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		for _, v := range c.Args().Slice() {
+			err := user.Volume.Delete(v)
+			if err != nil {
+				return err
+			}
+			user.Logger.Info().Msgf("deleted %s", v)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+var VolumeInspect = &cli.Command{
+	Name:      "inspect",
+	Usage:     "output volumes to console",
+	Aliases:   []string{"i", "get", "g"},
+	ArgsUsage: "[$volumeid1, ..., $volumeidX]",
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
+
+		for _, v := range c.Args().Slice() {
+			vol, err := user.Volume.Get(v)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(writer, "VolumeID\t%s\n", vol.Id)
+			fmt.Fprintf(writer, "Name\t%s\n", vol.Name)
+			fmt.Fprintf(writer, "Created\t%s\n", fmtTime(vol.CreatedAt))
+			fmt.Fprintf(writer, "Size\t%s\n", formatVolumeSize(vol))
+			fmt.Fprintf(writer, "Labels\t%s\n", formatLabels(vol.Labels))
+			fmt.Fprintf(writer, "Containers\t%s\n", strings.Join(vol.Users, ","))
+			fmt.Fprintf(writer, "\n")
+		}
+
+		writer.Flush()
+
+		return nil
+	},
+}
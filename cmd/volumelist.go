@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+	"github.com/urfave/cli/v2"
+	vtyp "github.com/xhebox/chrootd/volume"
+)
+
+func formatVolumeSize(vol *vtyp.Volume) string {
+	if vol.Size == 0 {
+		return units.BytesSize(float64(vol.Usage))
+	}
+	return formatDisk(vol.Usage, vol.Size)
+}
+
+func formatLabels(labels map[string]string) string {
+	res := []string{}
+	for k, v := range labels {
+		res = append(res, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+var VolumeList = &cli.Command{
+	Name:      "list",
+	Usage:     "query volumes",
+	ArgsUsage: "[a tidwall/gjson valid json query string]",
+	Aliases:   []string{"ls", "l"},
+	Action: func(c *cli.Context) error {
+		user := c.Context.Value("_data").(*User)
+
+		query := ""
+		if c.Args().Len() > 0 {
+			query = c.Args().First()
+		}
+
+		var mu sync.Mutex
+		res := []*vtyp.Volume{}
+		err := user.Volume.Query(query, func(vol *vtyp.Volume) error {
+			mu.Lock()
+			res = append(res, vol)
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(res, func(i, j int) bool {
+			return res[i].Id < res[j].Id
+		})
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 4, ' ', 0)
+
+		fmt.Fprintf(writer, "Name\tVolumeID\tSize\tContainers\tLabels\n")
+		for _, vol := range res {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%s\n", vol.Name, vol.Id, formatVolumeSize(vol), len(vol.Users), formatLabels(vol.Labels))
+		}
+
+		writer.Flush()
+
+		return nil
+	},
+}
@@ -0,0 +1,311 @@
+package cntr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/chrootd/utils"
+)
+
+// every frame starts with a 6 bytes header: version(1), type(1) and
+// the big endian payload length(4)
+const AttachVersion = 1
+
+const maxFramePayload = 1 << 20
+
+type FrameType uint8
+
+const (
+	// data frames, stream ids follow the file descriptor numbers
+	FrameStdin FrameType = iota
+	FrameStdout
+	FrameStderr
+	// control frames
+	FrameSignal     // uint32 signal number
+	FrameResize     // uint16 width, uint16 height
+	FrameCloseStdin // empty
+	FrameExit       // int32 exit code, the last frame sent by the server
+	FrameError      // error message, the last frame sent by the server
+	// log streams are made of log frames, and end with an exit frame of 0
+	FrameLog // json encoded LogEntry
+	// stats streams are made of stats frames, until the client has gone
+	FrameStats // json encoded Stats
+)
+
+type Frame struct {
+	Type    FrameType
+	Payload []byte
+}
+
+func WriteFrame(w io.Writer, f *Frame) error {
+	if len(f.Payload) > maxFramePayload {
+		return errors.New("frame payload is too large")
+	}
+
+	buf := make([]byte, 6, 6+len(f.Payload))
+	buf[0] = AttachVersion
+	buf[1] = byte(f.Type)
+	binary.BigEndian.PutUint32(buf[2:], uint32(len(f.Payload)))
+	buf = append(buf, f.Payload...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func ReadFrame(r io.Reader) (*Frame, error) {
+	var hdr [6]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if hdr[0] != AttachVersion {
+		return nil, errors.Errorf("unsupported attach protocol version %d", hdr[0])
+	}
+
+	n := binary.BigEndian.Uint32(hdr[2:])
+	if n > maxFramePayload {
+		return nil, errors.New("frame payload is too large")
+	}
+
+	f := &Frame{Type: FrameType(hdr[1]), Payload: make([]byte, n)}
+	_, err = io.ReadFull(r, f.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// ServeAttach multiplexes the attacher over conn until the task exits or
+// the client detaches.
+func ServeAttach(conn io.ReadWriter, rw Attacher) error {
+	var mu sync.Mutex
+	send := func(typ FrameType, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return WriteFrame(conn, &Frame{Type: typ, Payload: payload})
+	}
+
+	detached := make(chan struct{})
+	go func() {
+		for {
+			f, err := ReadFrame(conn)
+			if err != nil {
+				// the client is gone, stop following the output
+				close(detached)
+				rw.Close()
+				return
+			}
+
+			// errors of control frames are not fatal to the session
+			switch f.Type {
+			case FrameStdin:
+				rw.Write(f.Payload)
+			case FrameCloseStdin:
+				rw.CloseWrite()
+			case FrameSignal:
+				if len(f.Payload) == 4 {
+					rw.Signal(syscall.Signal(binary.BigEndian.Uint32(f.Payload)))
+				}
+			case FrameResize:
+				if len(f.Payload) == 4 {
+					rw.Resize(binary.BigEndian.Uint16(f.Payload), binary.BigEndian.Uint16(f.Payload[2:]))
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	pump := func(typ FrameType, r io.Reader) {
+		defer wg.Done()
+
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if send(typ, buf[:n]) != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	wg.Add(2)
+	go pump(FrameStdout, rw)
+	go pump(FrameStderr, rw.Stderr())
+	wg.Wait()
+
+	select {
+	case <-detached:
+		return nil
+	default:
+	}
+
+	code, err := rw.Wait()
+	if err != nil {
+		return send(FrameError, []byte(err.Error()))
+	}
+
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(int32(code)))
+	return send(FrameExit, payload)
+}
+
+// forwarder serves a connection as a task, stdin and stdout are the two
+// directions, and it exits with 0 once the peer has closed.
+type forwarder struct {
+	io.ReadWriteCloser
+}
+
+func (f *forwarder) Stderr() io.Reader {
+	return bytes.NewReader(nil)
+}
+
+func (f *forwarder) CloseWrite() error {
+	if cw, ok := f.ReadWriteCloser.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (f *forwarder) Signal(syscall.Signal) error {
+	return errors.New("can not signal a forwarded port")
+}
+
+func (f *forwarder) Resize(uint16, uint16) error {
+	return errors.New("can not resize a forwarded port")
+}
+
+func (f *forwarder) Wait() (int, error) {
+	return 0, nil
+}
+
+// ServeForward relays conn to target by the attach protocol, it is read
+// by NewAttachClient.
+func ServeForward(conn io.ReadWriter, target io.ReadWriteCloser) error {
+	f := &forwarder{target}
+	defer f.Close()
+	return ServeAttach(conn, f)
+}
+
+type attachClient struct {
+	conn   io.ReadWriteCloser
+	mu     sync.Mutex
+	stdout *utils.PipeBuffer
+	stderr *utils.PipeBuffer
+	done   chan struct{}
+	code   int
+	err    error
+}
+
+// NewAttachClient speaks the attach protocol with a connection served by ServeAttach.
+func NewAttachClient(conn io.ReadWriteCloser) Attacher {
+	a := &attachClient{
+		conn:   conn,
+		stdout: utils.NewPipeBuffer(),
+		stderr: utils.NewPipeBuffer(),
+		done:   make(chan struct{}),
+		code:   -1,
+	}
+	go a.recv()
+	return a
+}
+
+func (a *attachClient) recv() {
+	defer close(a.done)
+	defer a.stderr.Close()
+	defer a.stdout.Close()
+
+	for {
+		f, err := ReadFrame(a.conn)
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("attach connection closed before the task exited")
+			}
+			a.err = err
+			return
+		}
+
+		switch f.Type {
+		case FrameStdout:
+			a.stdout.Write(f.Payload)
+		case FrameStderr:
+			a.stderr.Write(f.Payload)
+		case FrameExit:
+			if len(f.Payload) != 4 {
+				a.err = errors.New("malformed exit frame")
+				return
+			}
+			a.code = int(int32(binary.BigEndian.Uint32(f.Payload)))
+			return
+		case FrameError:
+			a.err = errors.New(string(f.Payload))
+			return
+		}
+	}
+}
+
+func (a *attachClient) send(typ FrameType, payload []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return WriteFrame(a.conn, &Frame{Type: typ, Payload: payload})
+}
+
+func (a *attachClient) Read(buf []byte) (int, error) {
+	return a.stdout.Read(buf)
+}
+
+func (a *attachClient) Stderr() io.Reader {
+	return a.stderr
+}
+
+func (a *attachClient) Write(buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		end := len(buf)
+		if end-n > maxFramePayload {
+			end = n + maxFramePayload
+		}
+
+		err := a.send(FrameStdin, buf[n:end])
+		if err != nil {
+			return n, err
+		}
+		n = end
+	}
+	return n, nil
+}
+
+func (a *attachClient) CloseWrite() error {
+	return a.send(FrameCloseStdin, nil)
+}
+
+func (a *attachClient) Signal(sig syscall.Signal) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(sig))
+	return a.send(FrameSignal, payload)
+}
+
+func (a *attachClient) Resize(width, height uint16) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload, width)
+	binary.BigEndian.PutUint16(payload[2:], height)
+	return a.send(FrameResize, payload)
+}
+
+func (a *attachClient) Wait() (int, error) {
+	<-a.done
+	return a.code, a.err
+}
+
+func (a *attachClient) Close() error {
+	return a.conn.Close()
+}
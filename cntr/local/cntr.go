@@ -1,88 +1,227 @@
 package local
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/containerd/console"
+	"github.com/imdario/mergo"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	rutils "github.com/opencontainers/runc/libcontainer/utils"
 	"github.com/pkg/errors"
 	. "github.com/xhebox/chrootd/cntr"
 	mtyp "github.com/xhebox/chrootd/meta"
+	"github.com/xhebox/chrootd/store"
 )
 
 type task struct {
 	*libcontainer.Process
 	mu       sync.Mutex
 	Inr, Inw *os.File
-	Out      *bytes.Buffer
-	closed   bool
-}
-
-func (t *task) Read(buf []byte) (int, error) {
-	if !t.closed && t.Out.Len() == 0 {
-		time.Sleep(100 * time.Millisecond)
-		return 0, nil
-	}
-	return t.Out.Read(buf)
-}
-
-func (t *task) Write(buf []byte) (n int, err error) {
-	tbuf := bytes.NewBuffer(nil)
-	tbuf.Grow(len(buf))
-	for _, b := range buf {
-		switch b {
-		case 0x03:
-			t.Signal(syscall.SIGTERM)
-			return t.Inw.Write(tbuf.Bytes())
-		default:
-			err = tbuf.WriteByte(b)
-			if err != nil {
-				return
-			}
-		}
-	}
-	return t.Inw.Write(tbuf.Bytes())
+	stdout   *ring
+	stderr   *ring
+	log      *logFile
+	console  console.Console
+	copied   chan struct{}
+	status   TaskStatus
+	done     chan struct{}
 }
 
-func (t *task) CloseWrite() error {
-	t.Inw.Close()
-	t.Inr.Close()
-	return nil
+func (t *task) Status() *TaskStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := t.status
+	return &res
+}
+
+func (t *task) running() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status.State == TaskRunning
+}
+
+func (t *task) exit(ps *os.ProcessState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer close(t.done)
+
+	t.status.FinishedAt = time.Now()
+	t.status.State = TaskExited
+	t.status.ExitCode = -1
+	if ps == nil {
+		return
+	}
+
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	switch {
+	case ok && ws.Signaled():
+		t.status.State = TaskKilled
+		t.status.Signal = int(ws.Signal())
+		t.status.ExitCode = 128 + int(ws.Signal())
+	default:
+		t.status.ExitCode = ps.ExitCode()
+	}
 }
 
 func (t *task) Close() error {
-	t.closed = true
+	if t.console != nil {
+		return nil
+	}
 	t.Inr.Close()
 	t.Inw.Close()
 	return nil
 }
 
+type attacher struct {
+	t      *task
+	stdout *ringReader
+	stderr *ringReader
+}
+
+func (a *attacher) Read(buf []byte) (int, error) {
+	return a.stdout.Read(buf)
+}
+
+func (a *attacher) Stderr() io.Reader {
+	return a.stderr
+}
+
+func (a *attacher) Write(buf []byte) (int, error) {
+	if a.t.console != nil {
+		return a.t.console.Write(buf)
+	}
+	return a.t.Inw.Write(buf)
+}
+
+func (a *attacher) CloseWrite() error {
+	if a.t.console != nil {
+		// a terminal can not be half closed, send EOF instead
+		_, err := a.t.console.Write([]byte{0x04})
+		return err
+	}
+
+	a.t.Inw.Close()
+	a.t.Inr.Close()
+	return nil
+}
+
+func (a *attacher) Signal(sig syscall.Signal) error {
+	if !a.t.running() {
+		return errors.New("task is not running")
+	}
+	return a.t.Signal(sig)
+}
+
+func (a *attacher) Resize(width, height uint16) error {
+	if a.t.console == nil {
+		return errors.New("task is not attached to a terminal")
+	}
+	return a.t.console.Resize(console.WinSize{Width: width, Height: height})
+}
+
+func (a *attacher) Wait() (int, error) {
+	<-a.t.done
+	return a.t.Status().ExitCode, nil
+}
+
+func (a *attacher) Close() error {
+	a.stdout.Close()
+	a.stderr.Close()
+	return nil
+}
+
+type cntrState struct {
+	Info  *Cntrinfo
+	State *libcontainer.State
+}
+
 type cntr struct {
-	meta   *mtyp.Metainfo
-	cntr   libcontainer.Container
-	tasks  map[string]*task
-	rwmux  sync.RWMutex
-	id     string
-	rootfs string
-	tags   []string
-	wg     sync.WaitGroup
+	meta      *mtyp.Metainfo
+	cntr      libcontainer.Container
+	states    store.Store
+	tasks     map[string]*task
+	rwmux     sync.RWMutex
+	id        string
+	rootfs    string
+	rootfsDir string
+	config    *ispec.ImageConfig
+	tags      []string
+	retention time.Duration
+	bufsize   int
+	logs      *logDriver
+	ip        string
+	ipam      *ipam
+	bridge    string
+	rootless  bool
+	uidmap    string
+	gidmap    string
+	netns     string
+	slirp     *slirpNet
+	listeners []io.Closer
+	conns     map[io.Closer]struct{}
+	wg        sync.WaitGroup
 }
 
-func newCntr(c libcontainer.Container, meta *mtyp.Metainfo, id string, rootfs string, tags []string) *cntr {
+func newCntr(m *CntrManager, c libcontainer.Container, meta *mtyp.Metainfo, id string, rootfs string, tags []string) *cntr {
 	sort.Strings(tags)
+	// without the image config, tasks have no defaults
+	dir := filepath.Join(m.rootfsPath, rootfs)
+	config, _ := mtyp.LoadImageConfig(dir)
 	return &cntr{
-		id:     id,
-		rootfs: rootfs,
-		meta:   meta,
-		cntr:   c,
-		tags:   tags,
-		tasks:  make(map[string]*task),
+		id:        id,
+		rootfs:    rootfs,
+		rootfsDir: dir,
+		config:    config,
+		meta:      meta,
+		cntr:      c,
+		states:    m.states,
+		logs:      m.logs,
+		tags:      tags,
+		retention: m.TaskRetention,
+		bufsize:   m.OutputBuffer,
+		ipam:      m.ipam,
+		bridge:    m.Bridge,
+		rootless:  m.Rootless,
+		uidmap:    m.uidmapPath,
+		gidmap:    m.gidmapPath,
+		netns:     m.netnsDir(id),
+		tasks:     make(map[string]*task),
+	}
+}
+
+func (c *cntr) persist() error {
+	info, err := c.Meta()
+	if err != nil {
+		return err
+	}
+
+	state, err := c.cntr.State()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(&cntrState{Info: info, State: state})
+	if err != nil {
+		return err
+	}
+
+	idx, _, err := c.states.Get(c.id)
+	if err != nil {
+		idx = 0
 	}
+
+	return c.states.Put(c.id, idx, b)
 }
 
 func (c *cntr) getTask(id string) (*task, bool) {
@@ -92,51 +231,164 @@ func (c *cntr) getTask(id string) (*task, bool) {
 	return t, ok
 }
 
+func (c *cntr) state() CntrState {
+	status, err := c.cntr.Status()
+	if err != nil {
+		return CntrStopped
+	}
+
+	switch status {
+	case libcontainer.Created, libcontainer.Running:
+		return CntrRunning
+	case libcontainer.Pausing, libcontainer.Paused:
+		return CntrPaused
+	default:
+		return CntrStopped
+	}
+}
+
 func (c *cntr) Meta() (*Cntrinfo, error) {
-	return &Cntrinfo{
+	c.rwmux.RLock()
+	defer c.rwmux.RUnlock()
+
+	res := &Cntrinfo{
 		Id:     c.id,
 		Rootfs: c.rootfs,
 		Tags:   c.tags,
 		Meta:   c.meta,
-	}, nil
+		State:  c.state(),
+		IP:     c.ip,
+	}
+
+	// listing should not fail for the usage
+	res.DiskUsage, res.DiskQuota, _ = c.diskUsage()
+	return res, nil
+}
+
+// diskUsage returns bytes used against the quota of the rootfs.
+func (c *cntr) diskUsage() (uint64, uint64, error) {
+	q, err := mtyp.LoadQuota(c.rootfsDir)
+	if err != nil || q == nil {
+		return 0, 0, err
+	}
+
+	usage, err := q.Usage()
+	return usage, q.Size, err
 }
 
 func (c *cntr) Start(rt *Taskinfo) (string, error) {
+	rt = c.taskDefaults(rt)
 	if len(rt.Args) == 0 {
 		return "", errors.New("empty args, should have at least one argument")
 	}
 
+	user, groups, err := c.resolveUser(rt)
+	if err != nil {
+		return "", err
+	}
+
+	status, err := c.cntr.Status()
+	if err != nil {
+		return "", err
+	}
+
 	t := &task{
 		Process: &libcontainer.Process{
-			Cwd:           "/",
-			Args:          rt.Args,
-			Env:           rt.Env,
-			Capabilities:  &rt.Capabilities,
-			Rlimits:       spec2runcRlimits(rt.Rlimits),
-			Init:          len(c.tasks) == 0,
-			ConsoleHeight: rt.TermHeight,
-			ConsoleWidth:  rt.TermWidth,
+			Cwd:              rt.Cwd,
+			User:             user,
+			AdditionalGroups: groups,
+			Args:             rt.Args,
+			Env:              rt.Env,
+			Capabilities:     &rt.Capabilities,
+			Rlimits:          spec2runcRlimits(rt.Rlimits),
+			Init:             status == libcontainer.Stopped,
+			ConsoleHeight:    rt.TermHeight,
+			ConsoleWidth:     rt.TermWidth,
+		},
+		stdout: newRing(c.bufsize),
+		stderr: newRing(c.bufsize),
+		log:    c.logs.newLog(),
+		status: TaskStatus{
+			State: TaskCreated,
+			Args:  rt.Args,
+			Tty:   rt.Tty,
 		},
-		Out: bytes.NewBufferString(""),
+		done: make(chan struct{}),
 	}
 
-	var err error
-	t.Inr, t.Inw, err = os.Pipe()
-	if err != nil {
-		return "", err
+	var parent, child *os.File
+	if rt.Tty {
+		parent, child, err = rutils.NewSockPair("console")
+		if err != nil {
+			return "", err
+		}
+		defer parent.Close()
+		defer child.Close()
+
+		t.ConsoleSocket = child
+	} else {
+		t.Inr, t.Inw, err = os.Pipe()
+		if err != nil {
+			return "", err
+		}
+		t.Process.Stdin = t.Inr
+		t.Process.Stdout = io.MultiWriter(t.stdout, t.log.Stream("stdout"))
+		t.Process.Stderr = io.MultiWriter(t.stderr, t.log.Stream("stderr"))
 	}
-	t.Process.Stdin = t.Inr
-	t.Process.Stdout = t.Out
-	t.Process.Stderr = t.Out
 
-	err = c.cntr.Run(t.Process)
+	err = c.run(t.Process)
 	if err != nil {
 		return "", err
 	}
 
+	if rt.Tty {
+		master, err := rutils.RecvFd(parent)
+		if err != nil {
+			t.Signal(syscall.SIGKILL)
+			return "", err
+		}
+
+		t.console, err = console.ConsoleFromFile(master)
+		if err != nil {
+			master.Close()
+			t.Signal(syscall.SIGKILL)
+			return "", err
+		}
+
+		if rt.TermWidth > 0 && rt.TermHeight > 0 {
+			t.console.Resize(console.WinSize{Width: rt.TermWidth, Height: rt.TermHeight})
+		}
+
+		t.copied = make(chan struct{})
+		go func() {
+			io.Copy(io.MultiWriter(t.stdout, t.log.Stream("stdout")), t.console)
+			close(t.copied)
+		}()
+	}
+
+	if t.Init {
+		err = c.persist()
+		if err != nil {
+			t.Signal(syscall.SIGKILL)
+			return "", err
+		}
+	}
+
 	pid, _ := t.Pid()
 	id := fmt.Sprint(pid)
 
+	err = t.log.Open(c.logs.file(c.id, id))
+	if err != nil {
+		t.Signal(syscall.SIGKILL)
+		return "", err
+	}
+
+	t.mu.Lock()
+	t.status.Id = id
+	t.status.State = TaskRunning
+	t.status.StartedAt = time.Now()
+	t.mu.Unlock()
+
 	c.rwmux.Lock()
 	oldt, ok := c.tasks[id]
 	c.tasks[id] = t
@@ -146,15 +398,27 @@ func (c *cntr) Start(rt *Taskinfo) (string, error) {
 	go func() {
 		defer c.wg.Done()
 
-		t.Wait()
-
-		c.rwmux.Lock()
-		t, ok := c.tasks[id]
-		if ok {
-			t.Close()
+		ps, _ := t.Wait()
+		if t.console != nil {
+			<-t.copied
+			t.console.Close()
 		}
-		delete(c.tasks, id)
-		c.rwmux.Unlock()
+		t.stdout.Close()
+		t.stderr.Close()
+		t.log.Close()
+		t.exit(ps)
+		t.Close()
+		if t.Init {
+			c.stopSlirp()
+		}
+
+		time.AfterFunc(c.retention, func() {
+			c.rwmux.Lock()
+			if c.tasks[id] == t {
+				delete(c.tasks, id)
+			}
+			c.rwmux.Unlock()
+		})
 	}()
 
 	if ok {
@@ -164,6 +428,68 @@ func (c *cntr) Start(rt *Taskinfo) (string, error) {
 	return id, nil
 }
 
+// run holds the init process until the network is attached.
+func (c *cntr) run(p *libcontainer.Process) error {
+	if p.Init && c.meta.Network.Mode == mtyp.NetworkSlirp {
+		n, err := c.startSlirp()
+		if err != nil {
+			return err
+		}
+
+		err = c.cntr.Run(p)
+		if err != nil {
+			n.Close()
+			return err
+		}
+
+		c.rwmux.Lock()
+		c.slirp = n
+		c.rwmux.Unlock()
+		return nil
+	}
+
+	v, err := c.veth()
+	if err != nil {
+		return err
+	}
+
+	if !p.Init || v == nil {
+		return c.cntr.Run(p)
+	}
+
+	err = c.cntr.Start(p)
+	if err != nil {
+		return err
+	}
+
+	pid, err := p.Pid()
+	if err == nil {
+		err = v.attach(pid)
+	}
+	if err == nil {
+		err = c.cntr.Exec()
+	}
+	if err != nil {
+		p.Signal(syscall.SIGKILL)
+		p.Wait()
+		return err
+	}
+
+	return nil
+}
+
+// stopSlirp releases the namespaces held for the init process.
+func (c *cntr) stopSlirp() {
+	c.rwmux.Lock()
+	n := c.slirp
+	c.slirp = nil
+	c.rwmux.Unlock()
+
+	if n != nil {
+		n.Close()
+	}
+}
+
 func (c *cntr) Stop(id string, kill bool) error {
 	sig := syscall.SIGTERM
 	if kill {
@@ -171,7 +497,7 @@ func (c *cntr) Stop(id string, kill bool) error {
 	}
 
 	t, ok := c.getTask(id)
-	if ok {
+	if ok && t.running() {
 		t.Close()
 		return t.Signal(sig)
 	}
@@ -197,13 +523,119 @@ func (c *cntr) StopAll(kill bool) error {
 	return err
 }
 
-func (c *cntr) Attach(id string) (Attacher, error) {
+func (c *cntr) Pause() error {
+	return c.cntr.Pause()
+}
+
+func (c *cntr) Resume() error {
+	return c.cntr.Resume()
+}
+
+func (c *cntr) UpdateResources(res *configs.Resources) error {
+	cfg := c.cntr.Config()
+
+	merged := configs.Resources{}
+	if cfg.Cgroups.Resources != nil {
+		merged = *cfg.Cgroups.Resources
+	}
+
+	err := mergo.Merge(&merged, res, mergo.WithOverride)
+	if err != nil {
+		return err
+	}
+	cfg.Cgroups.Resources = &merged
+
+	err = c.cntr.Set(cfg)
+	if err != nil {
+		return err
+	}
+
+	c.rwmux.Lock()
+	meta := *c.meta
+	meta.Resources = merged
+	c.meta = &meta
+	c.rwmux.Unlock()
+
+	return c.persist()
+}
+
+func (c *cntr) Stats() (*Stats, error) {
+	st, err := c.cntr.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Stats{
+		Id:   c.id,
+		Time: time.Now(),
+	}
+
+	if cg := st.CgroupStats; cg != nil {
+		res.CpuTotal = cg.CpuStats.CpuUsage.TotalUsage
+		res.CpuUser = cg.CpuStats.CpuUsage.UsageInUsermode
+		res.CpuKernel = cg.CpuStats.CpuUsage.UsageInKernelmode
+		res.MemoryUsage = cg.MemoryStats.Usage.Usage
+		res.MemoryMax = cg.MemoryStats.Usage.MaxUsage
+		res.MemoryLimit = cg.MemoryStats.Usage.Limit
+		res.Pids = cg.PidsStats.Current
+		res.PidsLimit = cg.PidsStats.Limit
+
+		for _, e := range cg.BlkioStats.IoServiceBytesRecursive {
+			switch strings.ToLower(e.Op) {
+			case "read":
+				res.BlkioRead += e.Value
+			case "write":
+				res.BlkioWrite += e.Value
+			}
+		}
+	}
+
+	res.DiskUsage, res.DiskQuota, err = c.diskUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *cntr) WatchStats(ctx context.Context, interval time.Duration, f func(*Stats) error) error {
+	if interval <= 0 {
+		return errors.New("interval should be positive")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		st, err := c.Stats()
+		if err != nil {
+			return err
+		}
+
+		err = f(st)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *cntr) Attach(id string, scrollback int) (Attacher, error) {
 	t, ok := c.getTask(id)
 	if !ok {
-		return nil, errors.New("can not find task")
+		return nil, ErrTaskNotFound
 	}
 
-	return t, nil
+	return &attacher{
+		t:      t,
+		stdout: t.stdout.NewReader(scrollback),
+		stderr: t.stderr.NewReader(scrollback),
+	}, nil
 }
 
 func (c *cntr) List(f func(string) error) error {
@@ -217,15 +649,62 @@ func (c *cntr) List(f func(string) error) error {
 	return nil
 }
 
+func (c *cntr) TaskInfo(id string) (*TaskStatus, error) {
+	t, ok := c.getTask(id)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+
+	return t.Status(), nil
+}
+
+func (c *cntr) Logs(ctx context.Context, id string, opts *LogOptions, f func(*LogEntry) error) error {
+	var l *logFile
+	if t, ok := c.getTask(id); ok {
+		l = t.log
+	}
+
+	return c.logs.read(ctx, c.logs.file(c.id, id), l, opts, f)
+}
+
 func (c *cntr) Wait() error {
 	c.wg.Wait()
 	return nil
 }
 
+func (c *cntr) WaitTask(ctx context.Context, id string) (int, error) {
+	t, ok := c.getTask(id)
+	if !ok {
+		return -1, ErrTaskNotFound
+	}
+
+	select {
+	case <-t.done:
+		return t.Status().ExitCode, nil
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+}
+
+func (c *cntr) ResizeTask(id string, width, height uint16) error {
+	t, ok := c.getTask(id)
+	if !ok {
+		return ErrTaskNotFound
+	}
+
+	if t.console == nil {
+		return errors.New("task is not attached to a terminal")
+	}
+
+	return t.console.Resize(console.WinSize{Width: width, Height: height})
+}
+
 func (c *cntr) Destroy() error {
 	c.StopAll(true)
 
 	c.wg.Wait()
+	c.unpublish()
+	c.stopSlirp()
 
 	return c.cntr.Destroy()
 }
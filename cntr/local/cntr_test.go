@@ -75,3 +75,143 @@ func TestCntrInstanceList(t *testing.T) {
 
 	ctest.TestCntrInstanceList(mgr.Meta, mgr.Cntr, t)
 }
+
+func TestCntrInstanceTaskInfo(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceTaskInfo(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceWaitTask(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceWaitTask(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceTty(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceTty(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceAttachStreams(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceAttachStreams(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceAttachScrollback(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceAttachScrollback(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceLogs(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceLogs(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstancePause(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstancePause(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceUpdateResources(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceUpdateResources(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceStats(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceStats(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstancePorts(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstancePorts(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceImageConfig(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceImageConfig(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceUser(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceUser(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceCopy(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceCopy(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrInstanceDiskQuota(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrInstanceDiskQuota(mgr.Meta, mgr.Cntr, t)
+}
@@ -0,0 +1,211 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/opencontainers/runc/libcontainer"
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"github.com/xhebox/chrootd/utils"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	CopyFlag = &cli.Command{
+		Name:   "___cp",
+		Hidden: true,
+		Action: func(c *cli.Context) error {
+			InitCopy()
+			return nil
+		},
+	}
+)
+
+// InitCopy chroots into the root of the container opened by the daemon,
+// and extracts stdin into a directory, or archives a path to stdout.
+func InitCopy() {
+	if len(os.Args) != 4 {
+		fmt.Fprintln(os.Stderr, "usage: ___cp to|from path")
+		os.Exit(1)
+	}
+
+	err := unix.Fchdir(3)
+	if err == nil {
+		err = unix.Chroot(".")
+	}
+	unix.Close(3)
+
+	if err == nil {
+		switch os.Args[2] {
+		case "to":
+			err = utils.Untar(os.Stdin, os.Args[3])
+		case "from":
+			err = utils.Tar(os.Stdout, os.Args[3], copyName(os.Args[3]))
+		default:
+			err = errors.Errorf("unknown direction %s", os.Args[2])
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+// copyName is the name of path in archives.
+func copyName(path string) string {
+	path = strings.TrimRight(path, "/")
+	if path == "" {
+		return "."
+	}
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// copyCmd prepares the helper, root should be closed after it is started.
+// the root of a running container is the one of its init process, so
+// mounts of the container are seen, and symlinks are resolved inside. the
+// rootfs is used if the container is not running.
+func (c *cntr) copyCmd(dir, path string) (cmd *exec.Cmd, root *os.File, err error) {
+	rootPath := c.rootfsDir
+
+	status, err := c.cntr.Status()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if status != libcontainer.Stopped {
+		state, err := c.cntr.State()
+		if err != nil {
+			return nil, nil, err
+		}
+		rootPath = fmt.Sprintf("/proc/%d/root", state.InitProcessPid)
+	}
+
+	// the helper may not be allowed to open it in its user namespace
+	root, err = os.Open(rootPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmd = exec.Command(os.Args[0], "___cp", dir, path)
+	cmd.ExtraFiles = []*os.File{root}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: unix.SIGKILL}
+
+	// files are accessed as the root of the container
+	if c.rootless {
+		cfg := c.cntr.Config()
+		cmd.SysProcAttr.Cloneflags = unix.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+
+		// the mappings are written directly, ids of others are not seen
+		// without mapping tools
+		if os.Geteuid() == 0 || !requiresMappingTool(&cfg) {
+			cmd.SysProcAttr.UidMappings = spec2sysIDMaps(cfg.UidMappings)
+			cmd.SysProcAttr.GidMappings = spec2sysIDMaps(cfg.GidMappings)
+		}
+	}
+
+	return cmd, root, nil
+}
+
+func (c *cntr) CopyTo(dst string, rd io.Reader) error {
+	cmd, root, err := c.copyCmd("to", dst)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	var stderr bytes.Buffer
+	cmd.Stdin = rd
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return copyError(err, &stderr)
+	}
+
+	return nil
+}
+
+type copyReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	once   sync.Once
+	err    error
+}
+
+func (r *copyReader) wait() error {
+	r.once.Do(func() {
+		r.err = r.cmd.Wait()
+		if r.err != nil {
+			r.err = copyError(r.err, &r.stderr)
+		}
+	})
+	return r.err
+}
+
+func (r *copyReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if err == io.EOF {
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *copyReader) Close() error {
+	r.cmd.Process.Kill()
+	r.wait()
+	return nil
+}
+
+func (c *cntr) CopyFrom(src string) (io.ReadCloser, error) {
+	cmd, root, err := c.copyCmd("from", src)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	r := &copyReader{cmd: cmd}
+	cmd.Stderr = &r.stderr
+
+	r.ReadCloser, err = cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func copyError(err error, stderr *bytes.Buffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return errors.New(msg)
+	}
+	return err
+}
+
+func spec2sysIDMaps(maps []configs.IDMap) []syscall.SysProcIDMap {
+	res := []syscall.SysProcIDMap{}
+	for _, m := range maps {
+		res = append(res, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return res
+}
@@ -0,0 +1,52 @@
+package local
+
+import (
+	"strings"
+
+	. "github.com/xhebox/chrootd/cntr"
+)
+
+// taskDefaults fills the missing fields of the task by the image config.
+func (c *cntr) taskDefaults(rt *Taskinfo) *Taskinfo {
+	res := *rt
+
+	if c.config != nil {
+		if len(res.Args) == 0 {
+			res.Args = append(append([]string{}, c.config.Entrypoint...), c.config.Cmd...)
+		}
+
+		res.Env = mergeEnv(c.config.Env, res.Env)
+
+		if res.Cwd == "" {
+			res.Cwd = c.config.WorkingDir
+		}
+
+		if res.User == "" {
+			res.User = c.config.User
+		}
+	}
+
+	if res.Cwd == "" {
+		res.Cwd = "/"
+	}
+
+	return &res
+}
+
+// mergeEnv overrides variables of base by env.
+func mergeEnv(base, env []string) []string {
+	res := []string{}
+	idx := map[string]int{}
+	for _, envs := range [][]string{base, env} {
+		for _, e := range envs {
+			k := strings.SplitN(e, "=", 2)[0]
+			if i, ok := idx[k]; ok {
+				res[i] = e
+				continue
+			}
+			idx[k] = len(res)
+			res = append(res, e)
+		}
+	}
+	return res
+}
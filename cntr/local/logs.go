@@ -0,0 +1,366 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/xhebox/chrootd/cntr"
+)
+
+// logDriver stores the output of tasks as json lines, every log file is
+// rotated after maxSize bytes, and only maxFiles rotated files are kept.
+type logDriver struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+}
+
+func (d *logDriver) file(cid, tid string) string {
+	return filepath.Join(d.path, cid, fmt.Sprintf("%s.log", tid))
+}
+
+func (d *logDriver) rotated(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+func (d *logDriver) remove(cid string) error {
+	return os.RemoveAll(filepath.Join(d.path, cid))
+}
+
+func (d *logDriver) newLog() *logFile {
+	return &logFile{d: d, notify: make(chan struct{})}
+}
+
+type logFile struct {
+	d       *logDriver
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	size    int64
+	gen     int
+	pending bytes.Buffer
+	notify  chan struct{}
+	closed  bool
+}
+
+// Open starts writing to path, output written before is flushed into it.
+func (l *logFile) Open(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= l.d.maxFiles; i++ {
+		os.Remove(l.d.rotated(path, i))
+	}
+
+	l.f, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.path = path
+
+	n, err := l.pending.WriteTo(l.f)
+	l.size += n
+	return err
+}
+
+func (l *logFile) Stream(name string) io.Writer {
+	return &logStream{l: l, name: name}
+}
+
+func (l *logFile) write(stream string, b []byte) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	for len(b) > 0 {
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i != -1 {
+			line = b[:i+1]
+		}
+		b = b[len(line):]
+
+		buf, err := json.Marshal(&LogEntry{Time: now, Stream: stream, Log: string(line)})
+		if err != nil {
+			continue
+		}
+		buf = append(buf, '\n')
+
+		if l.f == nil {
+			l.pending.Write(buf)
+			continue
+		}
+
+		n, _ := l.f.Write(buf)
+		l.size += int64(n)
+	}
+
+	if l.f != nil && l.size > l.d.maxSize {
+		l.rotate()
+	}
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+func (l *logFile) rotate() error {
+	l.f.Close()
+	l.f = nil
+
+	for i := l.d.maxFiles; i > 1; i-- {
+		os.Rename(l.d.rotated(l.path, i-1), l.d.rotated(l.path, i))
+	}
+
+	if l.d.maxFiles > 0 {
+		os.Rename(l.path, l.d.rotated(l.path, 1))
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.f = f
+	l.size = 0
+	l.gen++
+	return nil
+}
+
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.notify)
+
+	if l.f != nil {
+		return l.f.Close()
+	}
+	return nil
+}
+
+type logStream struct {
+	l    *logFile
+	name string
+}
+
+// Write never fails, logging should not break the output of tasks.
+func (s *logStream) Write(b []byte) (int, error) {
+	s.l.write(s.name, b)
+	return len(b), nil
+}
+
+type logReader struct {
+	f       *os.File
+	rd      *bufio.Reader
+	partial []byte
+}
+
+func openLogReader(path string) (*logReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logReader{f: f, rd: bufio.NewReader(f)}, nil
+}
+
+// next returns io.EOF if there is no complete entry for now.
+func (r *logReader) next() (*LogEntry, error) {
+	line, err := r.rd.ReadBytes('\n')
+	if err != nil {
+		r.partial = append(r.partial, line...)
+		return nil, err
+	}
+
+	if len(r.partial) > 0 {
+		line = append(r.partial, line...)
+		r.partial = nil
+	}
+
+	e := &LogEntry{}
+	err = json.Unmarshal(line, e)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (r *logReader) Close() error {
+	return r.f.Close()
+}
+
+// read emits the entries of path, and keeps following it until l is
+// closed, if l is not nil.
+func (d *logDriver) read(ctx context.Context, path string, l *logFile, opts *LogOptions, f func(*LogEntry) error) error {
+	// files are opened under the lock, so that they match the generation
+	var gen int
+	if l != nil {
+		l.mu.Lock()
+		gen = l.gen
+	}
+
+	rotated := []*logReader{}
+	for i := d.maxFiles; i > 0; i-- {
+		if r, err := openLogReader(d.rotated(path, i)); err == nil {
+			rotated = append(rotated, r)
+		}
+	}
+
+	cur, err := openLogReader(path)
+	if l != nil {
+		l.mu.Unlock()
+	}
+	defer func() {
+		for _, r := range rotated {
+			r.Close()
+		}
+	}()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("can not find logs of the task")
+		}
+		return err
+	}
+	defer func() {
+		cur.Close()
+	}()
+
+	history := []*LogEntry{}
+	emit := func(e *LogEntry) error {
+		if e.Time.Before(opts.Since) {
+			return nil
+		}
+
+		if opts.Tail < 0 {
+			return f(e)
+		}
+
+		history = append(history, e)
+		if len(history) > opts.Tail {
+			history = history[1:]
+		}
+		return nil
+	}
+
+	drain := func(r *logReader) error {
+		for {
+			e, err := r.next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			err = emit(e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, r := range rotated {
+		err := drain(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = drain(cur)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range history {
+		err := f(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !opts.Follow || l == nil {
+		return nil
+	}
+
+	opts = &LogOptions{Since: opts.Since, Tail: -1}
+	for {
+		l.mu.Lock()
+		notify, closed, ngen := l.notify, l.closed, l.gen
+		l.mu.Unlock()
+
+		err := drain(cur)
+		if err != nil {
+			return err
+		}
+
+		if ngen != gen {
+			// the file has been rotated, and finished. files of skipped
+			// generations are opened under the lock, before being rotated
+			// again
+			l.mu.Lock()
+			ngen = l.gen
+			skipped := []*logReader{}
+			for g := gen + 1; g < ngen; g++ {
+				if r, err := openLogReader(d.rotated(path, ngen-g)); err == nil {
+					skipped = append(skipped, r)
+				}
+			}
+			next, err := openLogReader(path)
+			l.mu.Unlock()
+
+			if err == nil && len(skipped) < ngen-gen-1 {
+				err = errors.Errorf("%d log files were removed by rotation before being read", ngen-gen-1-len(skipped))
+			}
+			for _, r := range skipped {
+				if err == nil {
+					err = drain(r)
+				}
+				r.Close()
+			}
+			if err != nil {
+				if next != nil {
+					next.Close()
+				}
+				return err
+			}
+
+			cur.Close()
+			cur = next
+
+			gen = ngen
+			continue
+		}
+
+		if closed {
+			return nil
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/xhebox/chrootd/cntr"
+)
+
+func TestLogRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chrootd-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &logDriver{path: dir, maxSize: 256, maxFiles: 2}
+
+	l := d.newLog()
+	stdout := l.Stream("stdout")
+
+	// written before the file is opened
+	fmt.Fprintf(stdout, "line %d\n", 0)
+
+	err = l.Open(d.file("cntr", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < 100; i++ {
+		fmt.Fprintf(stdout, "line %d\n", i)
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "cntr", "1.log*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 3 {
+		t.Fatalf("expect one log file and two rotated ones, got %v", files)
+	}
+
+	res := []*LogEntry{}
+	err = d.read(context.Background(), d.file("cntr", "1"), nil, &LogOptions{Tail: -1}, func(e *LogEntry) error {
+		res = append(res, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) == 0 || len(res) >= 100 {
+		t.Fatalf("expect old entries to be dropped, got %d", len(res))
+	}
+
+	if res[len(res)-1].Log != "line 99\n" {
+		t.Fatalf("unexpected last entry %+v", res[len(res)-1])
+	}
+
+	for i := 1; i < len(res); i++ {
+		if res[i].Time.Before(res[i-1].Time) {
+			t.Fatal("entries are out of order")
+		}
+	}
+}
+
+func TestLogFollowRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chrootd-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// every two lines are rotated
+	d := &logDriver{path: dir, maxSize: 100, maxFiles: 3}
+
+	l := d.newLog()
+	stdout := l.Stream("stdout")
+
+	err = l.Open(d.file("cntr", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Fprintf(stdout, "line %d\n", 0)
+
+	first := make(chan struct{})
+	resume := make(chan struct{})
+	errch := make(chan error, 1)
+	res := []string{}
+	go func() {
+		errch <- d.read(context.Background(), d.file("cntr", "1"), l, &LogOptions{Tail: -1, Follow: true}, func(e *LogEntry) error {
+			if len(res) == 0 {
+				close(first)
+				<-resume
+			}
+			res = append(res, e.Log)
+			return nil
+		})
+	}()
+
+	// rotated twice before the reader wakes up
+	<-first
+	for i := 1; i < 5; i++ {
+		fmt.Fprintf(stdout, "line %d\n", i)
+	}
+
+	l.mu.Lock()
+	gen := l.gen
+	l.mu.Unlock()
+	if gen != 2 {
+		t.Fatalf("expect two rotations, got %d", gen)
+	}
+
+	close(resume)
+	l.Close()
+
+	err = <-errch
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 5 {
+		t.Fatalf("expect all entries, got %q", res)
+	}
+
+	for i := range res {
+		if res[i] != fmt.Sprintf("line %d\n", i) {
+			t.Fatalf("unexpected entries %q", res)
+		}
+	}
+}
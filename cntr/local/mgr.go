@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/imdario/mergo"
 	"github.com/opencontainers/runc/libcontainer"
@@ -25,6 +26,7 @@ import (
 	mtyp "github.com/xhebox/chrootd/meta"
 	"github.com/xhebox/chrootd/store"
 	"github.com/xhebox/chrootd/utils"
+	vtyp "github.com/xhebox/chrootd/volume"
 	"golang.org/x/sys/unix"
 )
 
@@ -54,26 +56,48 @@ type CntrManager struct {
 	imagePath   string
 	rootfsPath  string
 	factoryPath string
+	netnsPath   string
+	volumePath  string
+	uidmapPath  string
+	gidmapPath  string
 	factory     libcontainer.Factory
 
 	states store.Store
+	refs   *mtyp.RootfsRefs
+	vrefs  *vtyp.VolumeRefs
+	logs   *logDriver
+	ipam   *ipam
 	cntrs  map[string]*cntr
 	rwmux  sync.RWMutex
 
-	Rootless  bool
-	BinResolv bool
-	Prehook   []string
-	Posthook  []string
+	Rootless      bool
+	BinResolv     bool
+	Prehook       []string
+	Posthook      []string
+	TaskRetention time.Duration
+	OutputBuffer  int
+	LogMaxSize    int64
+	LogMaxFiles   int
+	Bridge        string
+	BridgeSubnet  string
 }
 
 func NewCntrManager(path, image string, s store.Store, opts ...func(*CntrManager) error) (*CntrManager, error) {
 	mgr := &CntrManager{
-		imagePath:   image,
-		factoryPath: filepath.Join(path, "factory"),
-		rootfsPath:  filepath.Join(path, "rootfs"),
-		cntrs:       make(map[string]*cntr),
-		Rootless:    true,
-		BinResolv:   true,
+		imagePath:     image,
+		factoryPath:   filepath.Join(path, "factory"),
+		rootfsPath:    filepath.Join(path, "rootfs"),
+		netnsPath:     filepath.Join(path, "netns"),
+		volumePath:    filepath.Join(path, "volumes"),
+		cntrs:         make(map[string]*cntr),
+		Rootless:      true,
+		BinResolv:     true,
+		TaskRetention: 5 * time.Minute,
+		OutputBuffer:  1 << 20,
+		LogMaxSize:    10 << 20,
+		LogMaxFiles:   3,
+		Bridge:        "chrootd0",
+		BridgeSubnet:  "10.88.0.0/16",
 	}
 	for _, f := range opts {
 		err := f(mgr)
@@ -82,6 +106,16 @@ func NewCntrManager(path, image string, s store.Store, opts ...func(*CntrManager
 		}
 	}
 
+	if mgr.OutputBuffer <= 0 {
+		return nil, errors.New("output buffer size should be positive")
+	}
+
+	mgr.logs = &logDriver{
+		path:     filepath.Join(path, "logs"),
+		maxSize:  mgr.LogMaxSize,
+		maxFiles: mgr.LogMaxFiles,
+	}
+
 	if !utils.PathExist(mgr.imagePath) {
 		return nil, errors.New("image path does not exist or no permission")
 	}
@@ -103,6 +137,21 @@ func NewCntrManager(path, image string, s store.Store, opts ...func(*CntrManager
 	}
 	mgr.states = mgrstates
 
+	mgr.refs, err = mtyp.NewRootfsRefs(s)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr.vrefs, err = vtyp.NewVolumeRefs(s)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr.ipam, err = newIPAM(s, mgr.BridgeSubnet)
+	if err != nil {
+		return nil, err
+	}
+
 	cgroupMgr := libcontainer.Cgroupfs
 	if systemd.IsRunningSystemd() {
 		cgroupMgr = libcontainer.SystemdCgroups
@@ -113,35 +162,87 @@ func NewCntrManager(path, image string, s store.Store, opts ...func(*CntrManager
 		cgroupMgr = libcontainer.RootlessCgroupfs
 	}
 
-	uidPath, err := exec.LookPath("newuidmap")
+	mgr.uidmapPath, err = exec.LookPath("newuidmap")
 	if err != nil {
-		uidPath = "/bin/newuidmap"
+		mgr.uidmapPath = "/bin/newuidmap"
 	}
 
-	gidPath, err := exec.LookPath("newgidmap")
+	mgr.gidmapPath, err = exec.LookPath("newgidmap")
 	if err != nil {
-		gidPath = "/bin/newgidmap"
-	}
-
-	err = os.RemoveAll(mgr.factoryPath)
-	if err != nil {
-		return nil, err
+		mgr.gidmapPath = "/bin/newgidmap"
 	}
 
 	mgr.factory, err = libcontainer.New(mgr.factoryPath,
 		cgroupMgr,
 		libcontainer.InitArgs(os.Args[0], "___init"),
 		// without suid/guid or corressponding caps, extern mapping tools are needed to run rootless(with correct configuration)
-		libcontainer.NewuidmapPath(uidPath),
-		libcontainer.NewgidmapPath(gidPath),
+		libcontainer.NewuidmapPath(mgr.uidmapPath),
+		libcontainer.NewgidmapPath(mgr.gidmapPath),
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	err = mgr.reload()
+	if err != nil {
+		return nil, err
+	}
+
 	return mgr, nil
 }
 
+func (m *CntrManager) reload() error {
+	recs := map[string]*cntrState{}
+
+	err := m.states.List("", func(k string, idx uint64, v []byte) error {
+		rec := &cntrState{}
+		if err := json.Unmarshal(v, rec); err != nil {
+			return err
+		}
+		recs[k] = rec
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id, rec := range recs {
+		c, err := m.factory.Load(id)
+		if err != nil && rec.State != nil {
+			// never started or runc state lost, recreate it as a stopped one
+			os.RemoveAll(filepath.Join(m.factoryPath, id))
+			c, err = m.factory.Create(id, &rec.State.Config)
+		}
+		if err != nil {
+			idx, _, err := m.states.Get(id)
+			if err != nil {
+				return err
+			}
+
+			err = m.states.Delete(id, idx)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		info := rec.Info
+
+		// containers created by older daemons are not referenced
+		err = m.refs.Add(info.Rootfs, id)
+		if err != nil {
+			return err
+		}
+
+		m.cntrs[id] = newCntr(m, c, info.Meta, id, info.Rootfs, info.Tags)
+		m.cntrs[id].ip = info.IP
+		// ports taken by others are left unpublished
+		m.cntrs[id].publish()
+	}
+
+	return nil
+}
+
 func (m *CntrManager) getCntr(id string) (*cntr, error) {
 	m.rwmux.Lock()
 	defer m.rwmux.Unlock()
@@ -167,7 +268,7 @@ func (m *CntrManager) Create(info *Cntrinfo) (string, error) {
 	cfg := &configs.Config{
 		Rootfs: filepath.Join(m.rootfsPath, info.Rootfs),
 		Cgroups: &configs.Cgroup{
-			Name:      "container",
+			Parent:    "chrootd",
 			Resources: &meta.Resources,
 		},
 		Namespaces: configs.Namespaces{
@@ -211,6 +312,13 @@ func (m *CntrManager) Create(info *Cntrinfo) (string, error) {
 				Flags:       unix.MS_NOSUID | unix.MS_STRICTATIME,
 				Data:        "mode=755",
 			},
+			{
+				Source:      "devpts",
+				Destination: "/dev/pts",
+				Device:      "devpts",
+				Flags:       unix.MS_NOSUID | unix.MS_NOEXEC,
+				Data:        "newinstance,ptmxmode=0666,mode=0620",
+			},
 		},
 		UidMappings: []configs.IDMap{
 			configs.IDMap{
@@ -243,20 +351,21 @@ func (m *CntrManager) Create(info *Cntrinfo) (string, error) {
 		}))
 	}
 
-	if !m.Rootless {
-		cfg.Namespaces = append(cfg.Namespaces, configs.Namespace{Type: configs.NEWNET})
-		cfg.Mounts = append(cfg.Mounts, &configs.Mount{
-			Source:      "sysfs",
-			Destination: "/sys",
-			Device:      "sysfs",
-			Flags:       unix.MS_NOEXEC | unix.MS_NOSUID | unix.MS_NODEV | unix.MS_RDONLY,
-		})
-	} else {
-		cfg.Mounts = append(cfg.Mounts, &configs.Mount{
-			Source:      "/sys",
-			Destination: "/sys",
-			Flags:       unix.MS_BIND | unix.MS_REC | unix.MS_RDONLY,
-		})
+	newid, err := m.states.NextSequence()
+	if err != nil {
+		return "", err
+	}
+
+	id := utils.ComposeID(m.id, fmt.Sprint(newid))
+
+	err = validPorts(meta.Ports)
+	if err != nil {
+		return "", err
+	}
+
+	ip, err := m.setupNetwork(cfg, id, &meta.Network)
+	if err != nil {
+		return "", err
 	}
 
 	for _, v := range meta.MaskPaths {
@@ -274,27 +383,72 @@ func (m *CntrManager) Create(info *Cntrinfo) (string, error) {
 
 	cfg.Rlimits = append(cfg.Rlimits, spec2runcRlimits(meta.Rlimits)...)
 
-	m.spec2runcMounts(cfg, meta.Mount)
+	cfg.Seccomp, err = spec2runcSeccomp(meta.Seccomp)
+	if err != nil {
+		m.releaseNetwork(id)
+		return "", err
+	}
 
-	err := mergo.Merge(cfg.Cgroups.Resources, meta.Resources)
+	err = m.spec2runcMounts(cfg, meta.Mount)
 	if err != nil {
+		m.releaseNetwork(id)
 		return "", err
 	}
 
-	newid, err := m.states.NextSequence()
+	err = mergo.Merge(cfg.Cgroups.Resources, meta.Resources)
 	if err != nil {
+		m.releaseNetwork(id)
 		return "", err
 	}
 
-	id := utils.ComposeID(m.id, fmt.Sprint(newid))
+	// one cgroup per container, or the stats and limits are shared
+	cfg.Cgroups.Name = id
+
+	err = m.refs.Add(info.Rootfs, id)
+	if err != nil {
+		m.releaseNetwork(id)
+		return "", err
+	}
+
+	err = m.useVolumes(id, meta.Mount)
+	if err != nil {
+		m.refs.Remove(info.Rootfs, id)
+		m.releaseNetwork(id)
+		return "", err
+	}
 
 	c, err := m.factory.Create(id, cfg)
 	if err != nil {
+		m.releaseVolumes(id, meta.Mount)
+		m.refs.Remove(info.Rootfs, id)
+		m.releaseNetwork(id)
+		return "", err
+	}
+
+	cn := newCntr(m, c, meta, id, info.Rootfs, info.Tags)
+	cn.ip = ip
+
+	err = cn.publish()
+	if err != nil {
+		c.Destroy()
+		m.releaseVolumes(id, meta.Mount)
+		m.refs.Remove(info.Rootfs, id)
+		m.releaseNetwork(id)
+		return "", err
+	}
+
+	err = cn.persist()
+	if err != nil {
+		cn.unpublish()
+		c.Destroy()
+		m.releaseVolumes(id, meta.Mount)
+		m.refs.Remove(info.Rootfs, id)
+		m.releaseNetwork(id)
 		return "", err
 	}
 
 	m.rwmux.Lock()
-	m.cntrs[id] = newCntr(c, meta, id, info.Rootfs, info.Tags)
+	m.cntrs[id] = cn
 	m.rwmux.Unlock()
 
 	return id, nil
@@ -322,7 +476,26 @@ func (m *CntrManager) Delete(id string) error {
 	}
 	m.rwmux.Unlock()
 
-	return nil
+	m.logs.remove(id)
+
+	m.releaseNetwork(id)
+
+	err = m.refs.Remove(cntr.rootfs, id)
+	if err != nil {
+		return err
+	}
+
+	err = m.releaseVolumes(id, cntr.meta.Mount)
+	if err != nil {
+		return err
+	}
+
+	idx, _, err := m.states.Get(id)
+	if err != nil {
+		return nil
+	}
+
+	return m.states.Delete(id, idx)
 }
 
 func (m *CntrManager) List(id string, f func(*Cntrinfo) error) error {
@@ -353,13 +526,17 @@ func (m *CntrManager) List(id string, f func(*Cntrinfo) error) error {
 	return nil
 }
 
+// containers are left running, and re-adopted by the next manager
 func (m *CntrManager) Close() error {
 	m.rwmux.RLock()
 	defer m.rwmux.RUnlock()
 
+	var err error
 	for _, cntr := range m.cntrs {
-		cntr.Destroy()
+		if e := cntr.persist(); e != nil {
+			err = e
+		}
 	}
 
-	return nil
+	return err
 }
@@ -1,6 +1,7 @@
 package local
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -10,24 +11,37 @@ import (
 	mtyp "github.com/xhebox/chrootd/meta"
 	mloc "github.com/xhebox/chrootd/meta/local"
 	ctest "github.com/xhebox/chrootd/cntr/test"
+	mtest "github.com/xhebox/chrootd/meta/test"
 	"github.com/xhebox/chrootd/store"
+	vtyp "github.com/xhebox/chrootd/volume"
+	vloc "github.com/xhebox/chrootd/volume/local"
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
 )
 
 func init() {
-	if len(os.Args) < 2 || os.Args[1] != "___init" {
+	if len(os.Args) < 2 {
 		return
 	}
 
-	InitLibcontainer()
+	switch os.Args[1] {
+	case "___init":
+		InitLibcontainer()
+	case "___netns":
+		InitNetns()
+	case "___cp":
+		InitCopy()
+	}
 }
 
 type TestCntrManager struct {
-	dir  string
-	Meta mtyp.Manager
-	Cntr ctyp.Manager
+	dir   string
+	image string
+	s     store.Store
+	Meta  mtyp.Manager
+	Cntr  ctyp.Manager
 }
 
-func NewTestCntrManager() (*TestCntrManager, error) {
+func NewTestCntrManager(opts ...func(*CntrManager) error) (*TestCntrManager, error) {
 	dir, err := ioutil.TempDir(os.TempDir(), "temp")
 	if err != nil {
 		return nil, err
@@ -53,17 +67,28 @@ func NewTestCntrManager() (*TestCntrManager, error) {
 		return nil, err
 	}
 
-	mgr2, err := NewCntrManager(dir, image, s)
+	mgr2, err := NewCntrManager(dir, image, s, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	return &TestCntrManager{dir: dir, Meta: mgr1, Cntr: mgr2}, nil
+	return &TestCntrManager{dir: dir, image: image, s: s, Meta: mgr1, Cntr: mgr2}, nil
 }
 
 func (t *TestCntrManager) Close() error {
+	ids := []string{}
+	t.Cntr.List("", func(info *ctyp.Cntrinfo) error {
+		ids = append(ids, info.Id)
+		return nil
+	})
+	for _, id := range ids {
+		t.Cntr.Delete(id)
+	}
+
 	t.Meta.Close()
 	t.Cntr.Close()
+	t.s.Close()
+	mtest.Unmount(t.dir)
 	return os.RemoveAll(t.dir)
 }
 
@@ -116,3 +141,173 @@ func TestCntrManagerList(t *testing.T) {
 
 	ctest.TestCntrManagerList(mgr.Meta, mgr.Cntr, t)
 }
+
+func TestCntrManagerReload(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mid, err := mgr.Meta.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mgr.Meta.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mgr.Meta.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := mgr.Cntr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+		Tags:   []string{"reload"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mgr.Cntr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mgr.Cntr, err = NewCntrManager(mgr.dir, mgr.image, mgr.s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := mgr.Cntr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := cntr.Meta()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Rootfs != rid || info.Meta.Name != meta.Name || len(info.Tags) != 1 {
+		t.Fatal("container record differs after reload")
+	}
+}
+
+func TestCntrManagerRootfsRef(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrManagerRootfsRef(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrManagerVolume(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vmgr, err := vloc.NewVolumeManager(mgr.dir, mgr.s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vmgr.Close()
+
+	_, err = vmgr.Create(&vtyp.Volume{Name: "data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mid, err := mgr.Meta.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+		Mount: []rspec.Mount{
+			{Type: "bind", Source: "volume:data", Destination: "/data"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mgr.Meta.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mgr.Meta.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := *meta
+	missing.Mount = []rspec.Mount{
+		{Type: "bind", Source: "volume:missing", Destination: "/data"},
+	}
+
+	_, err = mgr.Cntr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   &missing,
+	})
+	if err == nil {
+		t.Fatal("expect volumes to exist")
+	}
+
+	cid, err := mgr.Cntr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := mgr.Cntr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "echo hello > /data/hello"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := cntr.WaitTask(context.Background(), tid)
+	if err != nil || code != 0 {
+		t.Fatalf("task exited with %d: %v", code, err)
+	}
+
+	err = vmgr.Delete("data")
+	if err == nil {
+		t.Fatal("expect volumes in use to be kept")
+	}
+
+	err = mgr.Cntr.Delete(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// data outlives containers
+	b, err := ioutil.ReadFile(filepath.Join(vtyp.DataPath(filepath.Join(mgr.dir, "volumes"), "data"), "hello"))
+	if err != nil || string(b) != "hello\n" {
+		t.Fatalf("unexpected content %q: %v", b, err)
+	}
+
+	err = vmgr.Delete("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,7 +6,9 @@ import (
 
 	"github.com/opencontainers/runc/libcontainer/configs"
 	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
 	"github.com/xhebox/chrootd/utils"
+	vtyp "github.com/xhebox/chrootd/volume"
 	"golang.org/x/sys/unix"
 )
 
@@ -60,7 +62,8 @@ var mountPropagationMap = map[string]int{
 	"runbindable": unix.MS_UNBINDABLE | unix.MS_REC,
 }
 
-func (m *CntrManager) spec2runcMounts(cfg *configs.Config, mounts []rspec.Mount) {
+// spec2runcMounts converts mounts, volumes are bound from the node.
+func (m *CntrManager) spec2runcMounts(cfg *configs.Config, mounts []rspec.Mount) error {
 	for _, v := range mounts {
 		pa := filepath.Clean(v.Destination)
 		switch {
@@ -91,13 +94,53 @@ func (m *CntrManager) spec2runcMounts(cfg *configs.Config, mounts []rspec.Mount)
 				}
 			}
 
-			cfg.Mounts = append(cfg.Mounts, &configs.Mount{
+			mnt := &configs.Mount{
 				Source:           v.Source,
 				Destination:      v.Destination,
 				Device:           v.Type,
 				Flags:            flag,
 				PropagationFlags: propagation,
-			})
+			}
+
+			if name := vtyp.MountName(v.Source); name != "" {
+				mnt.Source = vtyp.DataPath(m.volumePath, name)
+				if !vtyp.ValidName(name) || !utils.PathExist(mnt.Source) {
+					return errors.Errorf("volume %s does not exist", name)
+				}
+				mnt.Device = "bind"
+				mnt.Flags |= unix.MS_BIND | unix.MS_REC
+			}
+
+			cfg.Mounts = append(cfg.Mounts, mnt)
+		}
+	}
+
+	return nil
+}
+
+func (m *CntrManager) useVolumes(id string, mounts []rspec.Mount) error {
+	for _, v := range mounts {
+		if name := vtyp.MountName(v.Source); name != "" {
+			err := m.vrefs.Add(name, id)
+			if err != nil {
+				m.releaseVolumes(id, mounts)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *CntrManager) releaseVolumes(id string, mounts []rspec.Mount) error {
+	var err error
+	for _, v := range mounts {
+		if name := vtyp.MountName(v.Source); name != "" {
+			if e := m.vrefs.Remove(name, id); e != nil {
+				err = e
+			}
 		}
 	}
+
+	return err
 }
@@ -0,0 +1,432 @@
+package local
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	mtyp "github.com/xhebox/chrootd/meta"
+	"github.com/xhebox/chrootd/slirp"
+	"github.com/xhebox/chrootd/store"
+	"golang.org/x/sys/unix"
+)
+
+// ipam hands out addresses of subnet, keys are addresses and values are
+// the owner containers. the first address is reserved for the gateway.
+type ipam struct {
+	store   store.Store
+	subnet  *net.IPNet
+	gateway net.IP
+}
+
+func newIPAM(s store.Store, subnet string) (*ipam, error) {
+	_, n, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.IP.To4() == nil {
+		return nil, errors.New("only ipv4 subnets are supported")
+	}
+
+	ones, bits := n.Mask.Size()
+	if bits-ones < 2 {
+		return nil, errors.New("subnet is too small")
+	}
+
+	ips, err := store.NewWrapStore("ipam", s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ipam{store: ips, subnet: n, gateway: ipAdd(n.IP, 1)}, nil
+}
+
+func ipAdd(ip net.IP, n uint32) net.IP {
+	res := make(net.IP, 4)
+	binary.BigEndian.PutUint32(res, binary.BigEndian.Uint32(ip.To4())+n)
+	return res
+}
+
+func (p *ipam) cidr(ip net.IP) string {
+	return (&net.IPNet{IP: ip, Mask: p.subnet.Mask}).String()
+}
+
+// Allocate takes the first free address.
+func (p *ipam) Allocate(id string) (string, error) {
+	used := map[string]bool{}
+	err := p.store.List("", func(k string, idx uint64, v []byte) error {
+		used[k] = true
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ones, bits := p.subnet.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	// skip the network, the gateway and the broadcast address
+	for i := uint32(2); i < size-1; i++ {
+		ip := ipAdd(p.subnet.IP, i)
+		if used[ip.String()] {
+			continue
+		}
+
+		// put on index 0 fails if someone else has taken it
+		if p.store.Put(ip.String(), 0, []byte(id)) == nil {
+			return ip.String(), nil
+		}
+	}
+
+	return "", errors.New("no free address in the bridge subnet")
+}
+
+// Reserve takes a specific address, the prefix length of addr is ignored.
+func (p *ipam) Reserve(id string, addr string) (string, error) {
+	ip, _, err := net.ParseCIDR(addr)
+	if err != nil {
+		ip = net.ParseIP(addr)
+	}
+	if ip == nil || ip.To4() == nil {
+		return "", errors.Errorf("invalid address %s", addr)
+	}
+	ip = ip.To4()
+
+	ones, bits := p.subnet.Mask.Size()
+	broadcast := ipAdd(p.subnet.IP, uint32(1)<<uint(bits-ones)-1)
+	if !p.subnet.Contains(ip) || ip.Equal(p.subnet.IP) || ip.Equal(p.gateway) || ip.Equal(broadcast) {
+		return "", errors.Errorf("address %s is not available in %s", addr, p.subnet)
+	}
+
+	err = p.store.Put(ip.String(), 0, []byte(id))
+	if err != nil {
+		return "", errors.Errorf("address %s is already in use", addr)
+	}
+
+	return ip.String(), nil
+}
+
+// Owned returns addresses of id in CIDR form.
+func (p *ipam) Owned(id string) ([]string, error) {
+	res := []string{}
+	err := p.store.List("", func(k string, idx uint64, v []byte) error {
+		if string(v) == id {
+			res = append(res, p.cidr(net.ParseIP(k)))
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (p *ipam) Release(id string) error {
+	owned := map[string]uint64{}
+	err := p.store.List("", func(k string, idx uint64, v []byte) error {
+		if string(v) == id {
+			owned[k] = idx
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, idx := range owned {
+		if e := p.store.Delete(k, idx); e != nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+func ensureBridge(name string, p *ipam) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		attrs := netlink.NewLinkAttrs()
+		attrs.Name = name
+		link = &netlink.Bridge{LinkAttrs: attrs}
+		err = netlink.LinkAdd(link)
+		if err != nil {
+			return errors.Wrapf(err, "can not create bridge %s", name)
+		}
+	} else if _, ok := link.(*netlink.Bridge); !ok {
+		return errors.Errorf("%s exists and is not a bridge", name)
+	}
+
+	addr := &netlink.Addr{IPNet: &net.IPNet{IP: p.gateway, Mask: p.subnet.Mask}}
+	err = netlink.AddrReplace(link, addr)
+	if err != nil {
+		return errors.Wrapf(err, "can not assign %s to bridge %s", addr, name)
+	}
+
+	return netlink.LinkSetUp(link)
+}
+
+// veth connects the network namespace of a container to the bridge.
+type veth struct {
+	bridge  string
+	host    string
+	addrs   []string
+	gateway net.IP
+	routes  []mtyp.Route
+}
+
+func vethName(id string) string {
+	return fmt.Sprintf("veth%08x", crc32.ChecksumIEEE([]byte(id)))
+}
+
+// attach creates the veth pair, and configures the peer inside the netns of pid.
+func (v *veth) attach(pid int) error {
+	br, err := netlink.LinkByName(v.bridge)
+	if err != nil {
+		return errors.Wrapf(err, "can not find bridge %s", v.bridge)
+	}
+
+	// left by a container that has not been cleaned up
+	if old, err := netlink.LinkByName(v.host); err == nil {
+		netlink.LinkDel(old)
+	}
+
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = v.host
+	attrs.MasterIndex = br.Attrs().Index
+	peerName := v.host + "c"
+	host := &netlink.Veth{LinkAttrs: attrs, PeerName: peerName}
+	err = netlink.LinkAdd(host)
+	if err != nil {
+		return errors.Wrap(err, "can not create veth pair")
+	}
+
+	err = v.setup(pid, host, peerName)
+	if err != nil {
+		netlink.LinkDel(host)
+		return err
+	}
+
+	return nil
+}
+
+func (v *veth) setup(pid int, host *netlink.Veth, peerName string) error {
+	peer, err := netlink.LinkByName(peerName)
+	if err != nil {
+		return err
+	}
+
+	err = netlink.LinkSetNsPid(peer, pid)
+	if err != nil {
+		return err
+	}
+
+	err = netlink.LinkSetUp(host)
+	if err != nil {
+		return err
+	}
+
+	ns, err := netns.GetFromPid(pid)
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	h, err := netlink.NewHandleAt(ns, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer h.Delete()
+
+	peer, err = h.LinkByName(peerName)
+	if err != nil {
+		return err
+	}
+
+	err = h.LinkSetName(peer, "eth0")
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range v.addrs {
+		a, err := netlink.ParseAddr(addr)
+		if err != nil {
+			return err
+		}
+
+		err = h.AddrAdd(peer, a)
+		if err != nil {
+			return errors.Wrapf(err, "can not assign %s", addr)
+		}
+	}
+
+	err = h.LinkSetUp(peer)
+	if err != nil {
+		return err
+	}
+
+	err = h.RouteAdd(&netlink.Route{LinkIndex: peer.Attrs().Index, Gw: v.gateway})
+	if err != nil {
+		return errors.Wrap(err, "can not add the default route")
+	}
+
+	for _, r := range v.routes {
+		_, dst, err := net.ParseCIDR(r.Destination)
+		if err != nil {
+			return err
+		}
+
+		err = h.RouteAdd(&netlink.Route{LinkIndex: peer.Attrs().Index, Dst: dst, Gw: net.ParseIP(r.Gateway)})
+		if err != nil {
+			return errors.Wrapf(err, "can not add route to %s", r.Destination)
+		}
+	}
+
+	return nil
+}
+
+// setupNetwork fills the network part of cfg, and allocates addresses of
+// bridge mode. the primary address is returned.
+func (m *CntrManager) setupNetwork(cfg *configs.Config, id string, nw *mtyp.Network) (string, error) {
+	mode := nw.Mode
+	if mode == "" {
+		mode = mtyp.NetworkNone
+		if m.Rootless {
+			mode = mtyp.NetworkHost
+		}
+	}
+
+	if mode != mtyp.NetworkBridge && (len(nw.Addresses) > 0 || len(nw.Routes) > 0) {
+		return "", errors.New("addresses and routes are only supported in bridge mode")
+	}
+
+	if mode != mtyp.NetworkSlirp && nw.HostLoopback {
+		return "", errors.New("host loopback is only supported in slirp mode")
+	}
+
+	switch mode {
+	case mtyp.NetworkHost:
+		cfg.Mounts = append(cfg.Mounts, &configs.Mount{
+			Source:      "/sys",
+			Destination: "/sys",
+			Flags:       unix.MS_BIND | unix.MS_REC | unix.MS_RDONLY,
+		})
+		return "", nil
+	case mtyp.NetworkSlirp:
+		// namespaces are created by the helper of slirp on start, and
+		// joined by the links
+		dir := m.netnsDir(id)
+		err := os.MkdirAll(dir, 0700)
+		if err != nil {
+			return "", err
+		}
+
+		for i := range cfg.Namespaces {
+			if cfg.Namespaces[i].Type == configs.NEWUSER {
+				cfg.Namespaces[i].Path = filepath.Join(dir, "user")
+			}
+		}
+		cfg.Namespaces = append(cfg.Namespaces, configs.Namespace{Type: configs.NEWNET, Path: filepath.Join(dir, "net")})
+		cfg.Mounts = append(cfg.Mounts, sysfsMount())
+		return slirp.DefaultConfig().Guest.String(), nil
+	case mtyp.NetworkNone, mtyp.NetworkBridge:
+	default:
+		return "", errors.Errorf("unknown network mode %s", mode)
+	}
+
+	cfg.Namespaces = append(cfg.Namespaces, configs.Namespace{Type: configs.NEWNET})
+	cfg.Networks = append(cfg.Networks, &configs.Network{Type: "loopback"})
+	cfg.Mounts = append(cfg.Mounts, sysfsMount())
+
+	if mode == mtyp.NetworkNone {
+		return "", nil
+	}
+
+	if m.Rootless {
+		return "", errors.New("bridge network is not supported by rootless daemons")
+	}
+
+	for _, r := range nw.Routes {
+		if _, _, err := net.ParseCIDR(r.Destination); err != nil {
+			return "", errors.Errorf("invalid route destination %s", r.Destination)
+		}
+		if r.Gateway != "" && net.ParseIP(r.Gateway) == nil {
+			return "", errors.Errorf("invalid route gateway %s", r.Gateway)
+		}
+	}
+
+	err := ensureBridge(m.Bridge, m.ipam)
+	if err != nil {
+		return "", err
+	}
+
+	if len(nw.Addresses) == 0 {
+		return m.ipam.Allocate(id)
+	}
+
+	var first string
+	for _, addr := range nw.Addresses {
+		a, err := m.ipam.Reserve(id, addr)
+		if err != nil {
+			m.ipam.Release(id)
+			return "", err
+		}
+
+		if first == "" {
+			first = a
+		}
+	}
+
+	return first, nil
+}
+
+func sysfsMount() *configs.Mount {
+	return &configs.Mount{
+		Source:      "sysfs",
+		Destination: "/sys",
+		Device:      "sysfs",
+		Flags:       unix.MS_NOEXEC | unix.MS_NOSUID | unix.MS_NODEV | unix.MS_RDONLY,
+	}
+}
+
+// runc rejects namespace paths with commas, which are in ids.
+func (m *CntrManager) netnsDir(id string) string {
+	return filepath.Join(m.netnsPath, strings.Replace(id, ",", "_", -1))
+}
+
+func (m *CntrManager) releaseNetwork(id string) error {
+	os.RemoveAll(m.netnsDir(id))
+	return m.ipam.Release(id)
+}
+
+// veth returns nil if the container is not on the bridge.
+func (c *cntr) veth() (*veth, error) {
+	if c.ip == "" || c.meta.Network.Mode != mtyp.NetworkBridge {
+		return nil, nil
+	}
+
+	owned, err := c.ipam.Owned(c.id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the primary address goes first
+	addrs := []string{c.ipam.cidr(net.ParseIP(c.ip))}
+	for _, addr := range owned {
+		if addr != addrs[0] {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return &veth{
+		bridge:  c.bridge,
+		host:    vethName(c.id),
+		addrs:   addrs,
+		gateway: c.ipam.gateway,
+		routes:  c.meta.Network.Routes,
+	}, nil
+}
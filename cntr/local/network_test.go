@@ -0,0 +1,283 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	ctyp "github.com/xhebox/chrootd/cntr"
+	mtyp "github.com/xhebox/chrootd/meta"
+	"github.com/xhebox/chrootd/store"
+)
+
+func TestIPAM(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := store.NewBolt(filepath.Join(dir, "s"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// case 1: too small to have a host address
+	_, err = newIPAM(s, "10.0.0.0/31")
+	if err == nil {
+		t.Fatal("expect error for a /31 subnet")
+	}
+
+	p, err := newIPAM(s, "10.0.0.0/29")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// case 2: addresses are allocated in order, skipping the gateway
+	ip, err := p.Allocate("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ip != "10.0.0.2" {
+		t.Fatalf("unexpected address %s", ip)
+	}
+
+	// case 3: reserved addresses can not be taken twice
+	_, err = p.Reserve("b", "10.0.0.3/29")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range []string{"10.0.0.3", "10.0.0.1", "10.0.0.7", "10.0.1.2"} {
+		if _, err := p.Reserve("c", addr); err == nil {
+			t.Fatalf("expect error for reserving %s", addr)
+		}
+	}
+
+	// case 4: the pool is exhausted
+	for i := 0; i < 3; i++ {
+		if _, err := p.Allocate("c"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = p.Allocate("d")
+	if err == nil {
+		t.Fatal("expect error for an exhausted subnet")
+	}
+
+	// case 5: released addresses are reused
+	owned, err := p.Owned("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(owned) != 3 || !strings.HasSuffix(owned[0], "/29") {
+		t.Fatalf("unexpected addresses %v", owned)
+	}
+
+	err = p.Release("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err = p.Allocate("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ip != "10.0.0.4" {
+		t.Fatalf("unexpected address %s", ip)
+	}
+}
+
+func TestCntrNetworkBridge(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bridge network needs root")
+	}
+
+	mgr, err := NewTestCntrManager(func(m *CntrManager) error {
+		m.Rootless = false
+		m.Bridge = "chrootdtest0"
+		m.BridgeSubnet = "10.89.0.0/24"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+	defer func() {
+		if link, err := netlink.LinkByName("chrootdtest0"); err == nil {
+			netlink.LinkDel(link)
+		}
+	}()
+
+	mid, err := mgr.Meta.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+		Network:        mtyp.Network{Mode: mtyp.NetworkBridge},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mgr.Meta.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mgr.Meta.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := mgr.Cntr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := mgr.Cntr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := cntr.Meta()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.IP != "10.89.0.2" {
+		t.Fatalf("unexpected address %s", info.IP)
+	}
+
+	// case 1: the address is configured, and eth0 is up
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "cat /sys/class/net/eth0/operstate /proc/net/fib_trie"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw, err := cntr.Attach(tid, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	b, err := ioutil.ReadAll(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(b), "up") || !strings.Contains(string(b), "10.89.0.2") {
+		t.Fatalf("unexpected output %q", b)
+	}
+
+	// case 2: the address is released with the container
+	err = mgr.Cntr.Delete(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owned, err := mgr.Cntr.(*CntrManager).ipam.Owned(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(owned) != 0 {
+		t.Fatalf("addresses are not released: %v", owned)
+	}
+}
+
+func TestCntrNetworkSlirp(t *testing.T) {
+	mgr, err := NewTestCntrManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mid, err := mgr.Meta.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+		Network:        mtyp.Network{Mode: mtyp.NetworkSlirp},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mgr.Meta.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mgr.Meta.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := mgr.Cntr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := mgr.Cntr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// case 1: the tap device is configured in a private namespace, twice
+	// for the namespaces are recreated by restarts
+	for i := 0; i < 2; i++ {
+		tid, err := cntr.Start(&ctyp.Taskinfo{
+			Args: []string{"/bin/sh", "-c", "cat /sys/class/net/tap0/operstate /proc/net/fib_trie"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rw, err := cntr.Attach(tid, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := ioutil.ReadAll(rw)
+		rw.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(b), "10.0.2.100") {
+			t.Fatalf("unexpected output %q", b)
+		}
+
+		_, err = cntr.WaitTask(context.Background(), tid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cntr.Wait()
+	}
+
+	// case 2: links of namespaces are removed with the container
+	err = mgr.Cntr.Delete(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(mgr.Cntr.(*CntrManager).netnsDir(cid)); !os.IsNotExist(err) {
+		t.Fatalf("namespaces are not released: %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package local
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	mtyp "github.com/xhebox/chrootd/meta"
+	"github.com/xhebox/chrootd/utils"
+)
+
+const udpProxyTimeout = 60 * time.Second
+
+func validPorts(ports []mtyp.PortMapping) error {
+	for _, p := range ports {
+		if p.HostPort == 0 || p.ContainerPort == 0 {
+			return errors.New("ports of mappings should not be zero")
+		}
+
+		switch p.Proto {
+		case "", mtyp.ProtoTCP, mtyp.ProtoUDP:
+		default:
+			return errors.Errorf("unknown protocol %s", p.Proto)
+		}
+	}
+	return nil
+}
+
+// networkMode resolves the default mode.
+func (c *cntr) networkMode() string {
+	if c.meta.Network.Mode != "" {
+		return c.meta.Network.Mode
+	}
+	if c.rootless {
+		return mtyp.NetworkHost
+	}
+	return mtyp.NetworkNone
+}
+
+// dialPort connects to port inside the network namespace of the container.
+func (c *cntr) dialPort(proto string, port uint16) (net.Conn, error) {
+	if proto == "" {
+		proto = mtyp.ProtoTCP
+	}
+
+	switch c.networkMode() {
+	case mtyp.NetworkHost:
+		return net.Dial(proto, net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	case mtyp.NetworkBridge:
+		return net.Dial(proto, net.JoinHostPort(c.ip, strconv.Itoa(int(port))))
+	case mtyp.NetworkSlirp:
+		c.rwmux.RLock()
+		n := c.slirp
+		c.rwmux.RUnlock()
+
+		if n == nil {
+			return nil, errors.New("container is not running")
+		}
+
+		if proto == mtyp.ProtoUDP {
+			return n.stack.DialUDP(port)
+		}
+		return n.stack.DialTCP(port)
+	default:
+		return nil, errors.New("container has no network")
+	}
+}
+
+func (c *cntr) PortForward(port uint16) (io.ReadWriteCloser, error) {
+	return c.dialPort(mtyp.ProtoTCP, port)
+}
+
+// publish listens on the host ports, until unpublish.
+func (c *cntr) publish() error {
+	if len(c.meta.Ports) > 0 && c.networkMode() == mtyp.NetworkNone {
+		return errors.New("ports can not be published without network")
+	}
+
+	c.rwmux.Lock()
+	c.conns = map[io.Closer]struct{}{}
+	c.rwmux.Unlock()
+
+	for _, p := range c.meta.Ports {
+		var err error
+		if p.Proto == mtyp.ProtoUDP {
+			err = c.publishUDP(p)
+		} else {
+			err = c.publishTCP(p)
+		}
+		if err != nil {
+			c.unpublish()
+			return errors.Wrapf(err, "can not publish port %d", p.HostPort)
+		}
+	}
+
+	return nil
+}
+
+func (c *cntr) publishTCP(p mtyp.PortMapping) error {
+	l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(int(p.HostPort))))
+	if err != nil {
+		return err
+	}
+
+	c.addListener(l)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Temporary() {
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+				return
+			}
+
+			go func() {
+				target, err := c.dialPort(mtyp.ProtoTCP, p.ContainerPort)
+				if err != nil {
+					conn.Close()
+					return
+				}
+
+				if !c.addConn(conn, target) {
+					conn.Close()
+					target.Close()
+					return
+				}
+				defer c.removeConn(conn, target)
+
+				utils.Splice(conn, target)
+			}()
+		}
+	}()
+
+	return nil
+}
+
+func (c *cntr) publishUDP(p mtyp.PortMapping) error {
+	pc, err := net.ListenPacket("udp", net.JoinHostPort("", strconv.Itoa(int(p.HostPort))))
+	if err != nil {
+		return err
+	}
+
+	c.addListener(pc)
+	go func() {
+		var mu sync.Mutex
+		flows := map[string]net.Conn{}
+		defer func() {
+			mu.Lock()
+			for _, conn := range flows {
+				conn.Close()
+			}
+			mu.Unlock()
+		}()
+
+		buf := make([]byte, 64<<10)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			conn, ok := flows[addr.String()]
+			mu.Unlock()
+
+			if !ok {
+				conn, err = c.dialPort(mtyp.ProtoUDP, p.ContainerPort)
+				if err != nil {
+					continue
+				}
+
+				if !c.addConn(conn) {
+					conn.Close()
+					return
+				}
+
+				mu.Lock()
+				flows[addr.String()] = conn
+				mu.Unlock()
+
+				// replies go back to the client, until the flow is idle
+				go func(conn net.Conn, addr net.Addr) {
+					defer func() {
+						mu.Lock()
+						if flows[addr.String()] == conn {
+							delete(flows, addr.String())
+						}
+						mu.Unlock()
+						c.removeConn(conn)
+						conn.Close()
+					}()
+
+					buf := make([]byte, 64<<10)
+					for {
+						n, err := conn.Read(buf)
+						if err != nil {
+							return
+						}
+
+						pc.WriteTo(buf[:n], addr)
+					}
+				}(conn, addr)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(udpProxyTimeout))
+			conn.Write(buf[:n])
+		}
+	}()
+
+	return nil
+}
+
+func (c *cntr) addListener(l io.Closer) {
+	c.rwmux.Lock()
+	c.listeners = append(c.listeners, l)
+	c.rwmux.Unlock()
+}
+
+// addConn tracks connections of published ports, which are closed by
+// unpublish. it fails once unpublished.
+func (c *cntr) addConn(conns ...io.Closer) bool {
+	c.rwmux.Lock()
+	defer c.rwmux.Unlock()
+
+	if c.conns == nil {
+		return false
+	}
+
+	for _, conn := range conns {
+		c.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (c *cntr) removeConn(conns ...io.Closer) {
+	c.rwmux.Lock()
+	defer c.rwmux.Unlock()
+
+	for _, conn := range conns {
+		delete(c.conns, conn)
+	}
+}
+
+func (c *cntr) unpublish() {
+	c.rwmux.Lock()
+	ls, conns := c.listeners, c.conns
+	c.listeners, c.conns = nil, nil
+	c.rwmux.Unlock()
+
+	for _, l := range ls {
+		l.Close()
+	}
+
+	// forwarding stops with the container
+	for conn := range conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package local
+
+import (
+	"io"
+	"sync"
+)
+
+// ring keeps the last len(buf) bytes of a stream, readers follow the
+// stream with their own offsets, lagging readers lose overwritten data.
+type ring struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	off    int64
+	closed bool
+}
+
+func newRing(size int) *ring {
+	r := &ring{buf: make([]byte, size)}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+func (r *ring) oldest() int64 {
+	if r.off < int64(len(r.buf)) {
+		return 0
+	}
+	return r.off - int64(len(r.buf))
+}
+
+func (r *ring) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	n := len(b)
+	if len(b) > len(r.buf) {
+		r.off += int64(len(b) - len(r.buf))
+		b = b[len(b)-len(r.buf):]
+	}
+
+	for len(b) > 0 {
+		c := copy(r.buf[r.off%int64(len(r.buf)):], b)
+		b = b[c:]
+		r.off += int64(c)
+	}
+
+	r.cond.Broadcast()
+	return n, nil
+}
+
+func (r *ring) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	r.cond.Broadcast()
+	return nil
+}
+
+// NewReader replays the last scrollback bytes, or everything retained if
+// scrollback is negative.
+func (r *ring) NewReader(scrollback int) *ringReader {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pos := r.oldest()
+	if scrollback >= 0 && int64(scrollback) < r.off-pos {
+		pos = r.off - int64(scrollback)
+	}
+
+	return &ringReader{r: r, pos: pos}
+}
+
+type ringReader struct {
+	r      *ring
+	pos    int64
+	closed bool
+}
+
+func (rd *ringReader) Read(b []byte) (int, error) {
+	r := rd.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for rd.pos == r.off && !r.closed && !rd.closed {
+		r.cond.Wait()
+	}
+
+	if rd.closed || rd.pos == r.off {
+		return 0, io.EOF
+	}
+
+	if oldest := r.oldest(); rd.pos < oldest {
+		rd.pos = oldest
+	}
+
+	i := rd.pos % int64(len(r.buf))
+	end := i + r.off - rd.pos
+	if end > int64(len(r.buf)) {
+		end = int64(len(r.buf))
+	}
+
+	n := copy(b, r.buf[i:end])
+	rd.pos += int64(n)
+	return n, nil
+}
+
+func (rd *ringReader) Close() error {
+	rd.r.mu.Lock()
+	defer rd.r.mu.Unlock()
+
+	rd.closed = true
+	rd.r.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,78 @@
+package local
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing(8)
+
+	rd := r.NewReader(-1)
+
+	_, err := r.Write([]byte("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// case 1: only the last 8 bytes are retained
+	b := make([]byte, 16)
+	n, err := io.ReadFull(r.NewReader(-1), b[:8])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b[:n]) != "23456789" {
+		t.Fatalf("unexpected content %q", b[:n])
+	}
+
+	// case 2: scrollback is limited by the size of buffer
+	n, err = io.ReadFull(r.NewReader(3), b[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b[:n]) != "789" {
+		t.Fatalf("unexpected content %q", b[:n])
+	}
+
+	// case 3: a lagging reader skips the overwritten data
+	_, err = r.Write([]byte("ab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Close()
+
+	all, err := ioutil.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(all) != "456789ab" {
+		t.Fatalf("unexpected content %q", all)
+	}
+
+	_, err = r.Write([]byte("c"))
+	if err == nil {
+		t.Fatal("expect an error when writing to a closed ring")
+	}
+}
+
+func TestRingReaderClose(t *testing.T) {
+	r := newRing(8)
+	rd := r.NewReader(0)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := rd.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	rd.Close()
+
+	if err := <-done; err != io.EOF {
+		t.Fatalf("expect EOF after close, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package local
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/opencontainers/runc/libcontainer/seccomp"
+	"github.com/opencontainers/runc/libcontainer/specconv"
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccomp(t *testing.T) {
+	// case 1: the default profile is valid
+	cfg, err := specconv.SetupSeccomp(defaultSeccomp())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.DefaultAction != configs.Errno {
+		t.Fatalf("unexpected default action %v", cfg.DefaultAction)
+	}
+
+	actions := map[string]configs.Action{}
+	for _, call := range cfg.Syscalls {
+		actions[call.Name] = call.Action
+	}
+
+	for _, name := range []string{"read", "execve", "clone", "personality"} {
+		if actions[name] != configs.Allow {
+			t.Errorf("expect %s to be allowed", name)
+		}
+	}
+
+	// clone3 fails with ENOSYS instead, and can not be traced
+	if actions["clone3"] != configs.Trace || actions["ptrace"] != 0 {
+		t.Errorf("unexpected actions of clone3 and ptrace %v %v", actions["clone3"], actions["ptrace"])
+	}
+
+	for _, name := range []string{"mount", "umount2", "fsopen", "fsconfig", "fsmount", "fspick", "move_mount", "open_tree", "unshare", "setns", "pivot_root", "bpf", "keyctl", "init_module"} {
+		if _, ok := actions[name]; ok {
+			t.Errorf("expect %s to be denied", name)
+		}
+	}
+
+	for _, call := range cfg.Syscalls {
+		if call.Name == "clone" && (len(call.Args) != 1 || call.Args[0].Value&unix.CLONE_NEWUSER == 0 || call.Args[0].ValueTwo != 0) {
+			t.Errorf("expect clone to be allowed without new namespaces, got %+v", call.Args)
+		}
+	}
+
+	// case 2: an empty profile is unconfined
+	cfg, err = spec2runcSeccomp(&rspec.LinuxSeccomp{})
+	if err != nil || cfg != nil {
+		t.Fatalf("expect no seccomp, got %v %v", cfg, err)
+	}
+
+	// case 3: invalid profiles are rejected
+	_, err = spec2runcSeccomp(&rspec.LinuxSeccomp{DefaultAction: "SCMP_ACT_NONE"})
+	if err == nil {
+		t.Fatal("expect error for an invalid action")
+	}
+
+	// case 4: only the default profile is skipped without seccomp
+	cfg, err = spec2runcSeccomp(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	custom := &rspec.LinuxSeccomp{
+		DefaultAction: rspec.ActAllow,
+		Syscalls:      []rspec.LinuxSyscall{{Names: []string{"mount"}, Action: rspec.ActErrno}},
+	}
+	_, cerr := spec2runcSeccomp(custom)
+
+	if seccomp.IsEnabled() {
+		if cfg == nil || cerr != nil {
+			t.Fatalf("expect seccomp, got %v %v", cfg, cerr)
+		}
+	} else {
+		if cfg != nil || cerr == nil {
+			t.Fatalf("expect no seccomp and error for custom profiles, got %v %v", cfg, cerr)
+		}
+	}
+}
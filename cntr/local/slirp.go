@@ -0,0 +1,250 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/opencontainers/runc/libcontainer/configs"
+	rutils "github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"github.com/vishvananda/netlink"
+	"github.com/xhebox/chrootd/slirp"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	NetnsFlag = &cli.Command{
+		Name:   "___netns",
+		Hidden: true,
+		Action: func(c *cli.Context) error {
+			InitNetns()
+			return nil
+		},
+	}
+)
+
+// InitNetns runs in a new user and network namespace, it sends a tap
+// device to the daemon, and keeps the namespaces until the daemon closes
+// the socket.
+func InitNetns() {
+	sock := os.NewFile(3, "netns")
+	b := make([]byte, 1)
+
+	// capabilities are dropped by the exec of an unmapped user, exec
+	// again after the id mappings are written
+	if len(os.Args) < 3 {
+		if _, err := sock.Read(b); err != nil {
+			os.Exit(1)
+		}
+
+		err := unix.Exec("/proc/self/exe", []string{os.Args[0], "___netns", "mapped"}, os.Environ())
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := setupNetns(sock); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	sock.Read(b)
+	os.Exit(0)
+}
+
+func setupNetns(sock *os.File) error {
+	cfg := slirp.DefaultConfig()
+
+	tap, err := slirp.OpenTap("tap0")
+	if err != nil {
+		return errors.Wrap(err, "can not create tap device")
+	}
+	defer tap.Close()
+
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		return err
+	}
+
+	err = netlink.LinkSetUp(lo)
+	if err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName("tap0")
+	if err != nil {
+		return err
+	}
+
+	err = netlink.AddrAdd(link, &netlink.Addr{IPNet: &net.IPNet{IP: cfg.Guest, Mask: cfg.Subnet.Mask}})
+	if err != nil {
+		return err
+	}
+
+	err = netlink.LinkSetUp(link)
+	if err != nil {
+		return err
+	}
+
+	err = netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: cfg.Gateway})
+	if err != nil {
+		return errors.Wrap(err, "can not add the default route")
+	}
+
+	// Fd makes it blocking, which is shared with the daemon
+	fd := tap.Fd()
+	err = unix.SetNonblock(int(fd), true)
+	if err != nil {
+		return err
+	}
+
+	return rutils.SendFd(sock, tap.Name(), fd)
+}
+
+// slirpNet holds the namespaces of a slirp container by the helper
+// process, and forwards the traffic of its tap device.
+type slirpNet struct {
+	cmd    *exec.Cmd
+	sock   *os.File
+	stderr bytes.Buffer
+	stack  *slirp.Stack
+}
+
+func (n *slirpNet) Close() error {
+	if n.stack != nil {
+		n.stack.Close()
+	}
+	n.sock.Close()
+	n.cmd.Process.Kill()
+	return n.cmd.Wait()
+}
+
+// startSlirp spawns the helper, and links the namespaces to the paths
+// joined by the container.
+func (c *cntr) startSlirp() (*slirpNet, error) {
+	parent, child, err := rutils.NewSockPair("netns")
+	if err != nil {
+		return nil, err
+	}
+
+	n := &slirpNet{sock: parent}
+	n.cmd = exec.Command(os.Args[0], "___netns")
+	n.cmd.ExtraFiles = []*os.File{child}
+	n.cmd.Stderr = &n.stderr
+	n.cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: unix.CLONE_NEWUSER | unix.CLONE_NEWNET,
+		Pdeathsig:  unix.SIGKILL,
+	}
+
+	err = n.cmd.Start()
+	// or the failure of the helper would not be seen
+	child.Close()
+	if err != nil {
+		parent.Close()
+		return nil, err
+	}
+
+	pid := n.cmd.Process.Pid
+	cfg := c.cntr.Config()
+
+	err = c.writeIDMaps(pid, &cfg)
+	if err == nil {
+		_, err = parent.Write([]byte{0})
+	}
+
+	var tap *os.File
+	if err == nil {
+		tap, err = rutils.RecvFd(parent)
+		if err != nil {
+			n.Close()
+			return nil, errors.Errorf("can not setup the network namespace: %s", strings.TrimSpace(n.stderr.String()))
+		}
+	}
+
+	for _, ns := range []string{"user", "net"} {
+		if err != nil {
+			break
+		}
+
+		p := filepath.Join(c.netns, ns)
+		os.Remove(p)
+		err = os.Symlink(fmt.Sprintf("/proc/%d/ns/%s", pid, ns), p)
+	}
+
+	if err != nil {
+		if tap != nil {
+			tap.Close()
+		}
+		n.Close()
+		return nil, err
+	}
+
+	scfg := slirp.DefaultConfig()
+	scfg.HostLoopback = c.meta.Network.HostLoopback
+
+	n.stack = slirp.New(tap, scfg)
+	go n.stack.Serve()
+
+	return n, nil
+}
+
+// writeIDMaps follows runc, mapping tools are used if the mappings can
+// not be written directly.
+func (c *cntr) writeIDMaps(pid int, cfg *configs.Config) error {
+	if c.rootless && !requiresMappingTool(cfg) {
+		err := ioutil.WriteFile(fmt.Sprintf("/proc/%d/setgroups", pid), []byte("deny"), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := writeIDMap(pid, "uid_map", cfg.UidMappings, c.uidmap)
+	if err != nil {
+		return err
+	}
+
+	return writeIDMap(pid, "gid_map", cfg.GidMappings, c.gidmap)
+}
+
+func requiresMappingTool(cfg *configs.Config) bool {
+	for _, m := range cfg.UidMappings {
+		if m.Size != 1 || m.HostID != os.Geteuid() {
+			return true
+		}
+	}
+	for _, m := range cfg.GidMappings {
+		if m.Size != 1 || m.HostID != os.Getegid() {
+			return true
+		}
+	}
+	return len(cfg.UidMappings) > 1 || len(cfg.GidMappings) > 1
+}
+
+func writeIDMap(pid int, file string, maps []configs.IDMap, tool string) error {
+	var b strings.Builder
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range maps {
+		fmt.Fprintf(&b, "%d %d %d\n", m.ContainerID, m.HostID, m.Size)
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+
+	err := ioutil.WriteFile(fmt.Sprintf("/proc/%d/%s", pid, file), []byte(b.String()), 0)
+	if err == nil || !os.IsPermission(err) {
+		return err
+	}
+
+	out, err := exec.Command(tool, args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%s: %s", tool, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
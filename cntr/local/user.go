@@ -0,0 +1,58 @@
+package local
+
+import (
+	"strconv"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/opencontainers/runc/libcontainer/user"
+	"github.com/pkg/errors"
+	. "github.com/xhebox/chrootd/cntr"
+)
+
+// resolveUser resolves the names of the user and groups by the rootfs, the
+// ids are returned as runc would resolve them inside the container again.
+func (c *cntr) resolveUser(rt *Taskinfo) (string, []string, error) {
+	passwd, err := securejoin.SecureJoin(c.rootfsDir, "/etc/passwd")
+	if err != nil {
+		return "", nil, err
+	}
+
+	group, err := securejoin.SecureJoin(c.rootfsDir, "/etc/group")
+	if err != nil {
+		return "", nil, err
+	}
+
+	u, err := user.GetExecUserPath(rt.User, &user.ExecUser{Home: "/"}, passwd, group)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "can not resolve user %s", rt.User)
+	}
+
+	gids, err := user.GetAdditionalGroupsPath(rt.AdditionalGroups, group)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "can not resolve additional groups")
+	}
+
+	// ids out of the mappings are not usable inside the container
+	if u.Uid < 0 || uint32(u.Uid) >= c.meta.UidMapSize {
+		return "", nil, errors.Errorf("uid %d is not mapped, uid map size is %d", u.Uid, c.meta.UidMapSize)
+	}
+
+	// setgroups is denied in rootless containers, groups of the user are
+	// silently ignored like runc
+	if c.rootless {
+		if len(gids) > 0 {
+			return "", nil, errors.New("additional groups are not supported by rootless containers")
+		}
+		u.Sgids = nil
+	}
+
+	groups := []string{}
+	for _, gid := range append([]int{u.Gid}, append(u.Sgids, gids...)...) {
+		if gid < 0 || uint32(gid) >= c.meta.GidMapSize {
+			return "", nil, errors.Errorf("gid %d is not mapped, gid map size is %d", gid, c.meta.GidMapSize)
+		}
+		groups = append(groups, strconv.Itoa(gid))
+	}
+
+	return strconv.Itoa(u.Uid) + ":" + groups[0], groups[1:], nil
+}
@@ -0,0 +1,82 @@
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	. "github.com/xhebox/chrootd/cntr"
+	mtyp "github.com/xhebox/chrootd/meta"
+)
+
+func TestResolveUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.Mkdir(filepath.Join(dir, "etc"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "etc/passwd"), []byte("root:x:0:0::/root:/bin/sh\nalice:x:1000:1000::/home/alice:/bin/sh\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "etc/group"), []byte("root:x:0:\nwheel:x:10:alice\nalice:x:1000:\nbig:x:70000:\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cntr{
+		rootfsDir: dir,
+		meta:      &mtyp.Metainfo{UidMapSize: 65536, GidMapSize: 65536},
+	}
+
+	// case 1: groups of the user, and additional groups
+	user, groups, err := c.resolveUser(&Taskinfo{User: "alice", AdditionalGroups: []string{"root"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != "1000:1000" || !reflect.DeepEqual(groups, []string{"10", "0"}) {
+		t.Fatalf("unexpected user %s, groups %v", user, groups)
+	}
+
+	// case 2: the default user
+	user, groups, err = c.resolveUser(&Taskinfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != "0:0" || len(groups) != 0 {
+		t.Fatalf("unexpected user %s, groups %v", user, groups)
+	}
+
+	// case 3: unmapped groups
+	_, _, err = c.resolveUser(&Taskinfo{AdditionalGroups: []string{"big"}})
+	if err == nil {
+		t.Fatal("expect an error for an unmapped group")
+	}
+
+	// case 4: no additional groups for rootless containers
+	c.rootless = true
+	user, groups, err = c.resolveUser(&Taskinfo{User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != "1000:1000" || len(groups) != 0 {
+		t.Fatalf("unexpected user %s, groups %v", user, groups)
+	}
+
+	_, _, err = c.resolveUser(&Taskinfo{AdditionalGroups: []string{"wheel"}})
+	if err == nil {
+		t.Fatal("expect an error for additional groups of a rootless container")
+	}
+}
@@ -2,9 +2,14 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"io"
 	"net"
+	"time"
 
+	"github.com/opencontainers/runc/libcontainer/configs"
+	"github.com/pkg/errors"
 	"github.com/xhebox/chrootd/client"
 	ctyp "github.com/xhebox/chrootd/cntr"
 	"github.com/xhebox/chrootd/utils"
@@ -58,6 +63,27 @@ func (m *cntr) Wait() error {
 	})
 }
 
+func (m *cntr) WaitTask(ctx context.Context, tid string) (int, error) {
+	res := -1
+	return res, m.Call(m.cid, func(cli client.Client, svc map[string]string) error {
+		return cli.Call(ctx, m.svc, "CntrWaitTask", &CntrWaitTaskReq{
+			Id:     m.cid,
+			TaskId: tid,
+		}, &res)
+	})
+}
+
+func (m *cntr) ResizeTask(tid string, width, height uint16) error {
+	return m.Call(m.cid, func(cli client.Client, svc map[string]string) error {
+		return cli.Call(m.Context, m.svc, "CntrResizeTask", &CntrResizeTaskReq{
+			Id:     m.cid,
+			TaskId: tid,
+			Width:  width,
+			Height: height,
+		}, nil)
+	})
+}
+
 func (m *cntr) List(f func(string) error) error {
 	return m.Call(m.cid, func(cli client.Client, svc map[string]string) error {
 		res := []string{}
@@ -78,15 +104,44 @@ func (m *cntr) List(f func(string) error) error {
 	})
 }
 
-func (m *cntr) Attach(tid string) (ctyp.Attacher, error) {
+func (m *cntr) TaskInfo(tid string) (*ctyp.TaskStatus, error) {
+	res := &ctyp.TaskStatus{}
+	return res, m.Call(m.cid, func(cli client.Client, svc map[string]string) error {
+		return cli.Call(m.Context, m.svc, "CntrTaskInfo", &CntrTaskInfoReq{
+			Id:     m.cid,
+			TaskId: tid,
+		}, res)
+	})
+}
+
+func (m *cntr) Pause() error {
+	return m.Call(m.cid, func(cli client.Client, svc map[string]string) error {
+		return cli.Call(m.Context, m.svc, "CntrPause", m.cid, nil)
+	})
+}
+
+func (m *cntr) Resume() error {
+	return m.Call(m.cid, func(cli client.Client, svc map[string]string) error {
+		return cli.Call(m.Context, m.svc, "CntrResume", m.cid, nil)
+	})
+}
+
+func (m *cntr) UpdateResources(res *configs.Resources) error {
+	return m.Call(m.cid, func(cli client.Client, svc map[string]string) error {
+		return cli.Call(m.Context, m.svc, "CntrUpdate", &CntrUpdateReq{
+			Id:        m.cid,
+			Resources: res,
+		}, nil)
+	})
+}
+
+// dial requests a token by method, and hands it to the attach server
+func (m *cntr) dial(ctx context.Context, method string, req interface{}) (net.Conn, error) {
 	var attachAddr *utils.Addr
 	tok := []byte{}
 	err := m.Call(m.cid, func(cli client.Client, svc map[string]string) error {
 		attachAddr = utils.NewAddrString(svc["attachNetwork"], svc["attach"])
-		return cli.Call(m.Context, m.svc, "CntrAttach", &CntrAttachReq{
-			Id:     m.cid,
-			TaskId: tid,
-		}, &tok)
+		return cli.Call(ctx, m.svc, method, req, &tok)
 	})
 	if err != nil {
 		return nil, err
@@ -104,8 +159,182 @@ func (m *cntr) Attach(tid string) (ctyp.Attacher, error) {
 
 	_, err = io.Copy(conn, bytes.NewReader(tok))
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
 	return conn, nil
 }
+
+func (m *cntr) Attach(tid string, scrollback int) (ctyp.Attacher, error) {
+	conn, err := m.dial(m.Context, "CntrAttach", &CntrAttachReq{
+		Id:         m.cid,
+		TaskId:     tid,
+		Scrollback: scrollback,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ctyp.NewAttachClient(conn), nil
+}
+
+func (m *cntr) PortForward(port uint16) (io.ReadWriteCloser, error) {
+	conn, err := m.dial(m.Context, "CntrPortForward", &CntrPortForwardReq{
+		Id:   m.cid,
+		Port: port,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ctyp.NewAttachClient(conn), nil
+}
+
+func (m *cntr) CopyTo(dst string, rd io.Reader) error {
+	conn, err := m.dial(m.Context, "CntrCopyTo", &CntrCopyToReq{
+		Id:   m.cid,
+		Path: dst,
+	})
+	if err != nil {
+		return err
+	}
+
+	a := ctyp.NewAttachClient(conn)
+	defer a.Close()
+
+	// the error of the server is preferred, writes fail once it has gone
+	_, err = io.Copy(a, rd)
+	if err == nil {
+		err = a.CloseWrite()
+	}
+
+	_, werr := a.Wait()
+	if werr != nil {
+		return werr
+	}
+
+	return err
+}
+
+type copyClient struct {
+	ctyp.Attacher
+}
+
+func (c *copyClient) Read(b []byte) (int, error) {
+	n, err := c.Attacher.Read(b)
+	if err == io.EOF {
+		if _, werr := c.Wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (m *cntr) CopyFrom(src string) (io.ReadCloser, error) {
+	conn, err := m.dial(m.Context, "CntrCopyFrom", &CntrCopyFromReq{
+		Id:   m.cid,
+		Path: src,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &copyClient{ctyp.NewAttachClient(conn)}, nil
+}
+
+// stream reads frames until the end of stream, or ctx is done
+func (m *cntr) stream(ctx context.Context, conn net.Conn, f func(*ctyp.Frame) error) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		fr, err := ctyp.ReadFrame(conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		switch fr.Type {
+		case ctyp.FrameExit:
+			return nil
+		case ctyp.FrameError:
+			return errors.New(string(fr.Payload))
+		default:
+			err = f(fr)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (m *cntr) Logs(ctx context.Context, tid string, opts *ctyp.LogOptions, f func(*ctyp.LogEntry) error) error {
+	conn, err := m.dial(ctx, "CntrLogs", &CntrLogsReq{
+		Id:     m.cid,
+		TaskId: tid,
+		Since:  opts.Since,
+		Follow: opts.Follow,
+		Tail:   opts.Tail,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return m.stream(ctx, conn, func(fr *ctyp.Frame) error {
+		if fr.Type != ctyp.FrameLog {
+			return nil
+		}
+
+		e := &ctyp.LogEntry{}
+		err := json.Unmarshal(fr.Payload, e)
+		if err != nil {
+			return err
+		}
+
+		return f(e)
+	})
+}
+
+func (m *cntr) Stats() (*ctyp.Stats, error) {
+	res := &ctyp.Stats{}
+	err := m.Call(m.cid, func(cli client.Client, svc map[string]string) error {
+		return cli.Call(m.Context, m.svc, "CntrStats", m.cid, res)
+	})
+	return res, err
+}
+
+func (m *cntr) WatchStats(ctx context.Context, interval time.Duration, f func(*ctyp.Stats) error) error {
+	conn, err := m.dial(ctx, "CntrWatchStats", &CntrWatchStatsReq{
+		Id:       m.cid,
+		Interval: interval,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return m.stream(ctx, conn, func(fr *ctyp.Frame) error {
+		if fr.Type != ctyp.FrameStats {
+			return nil
+		}
+
+		st := &ctyp.Stats{}
+		err := json.Unmarshal(fr.Payload, st)
+		if err != nil {
+			return err
+		}
+
+		return f(st)
+	})
+}
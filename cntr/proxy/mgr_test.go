@@ -16,6 +16,7 @@ import (
 	ctyp "github.com/xhebox/chrootd/cntr"
 	cloc "github.com/xhebox/chrootd/cntr/local"
 	ctest "github.com/xhebox/chrootd/cntr/test"
+	mtest "github.com/xhebox/chrootd/meta/test"
 	mtyp "github.com/xhebox/chrootd/meta"
 	mloc "github.com/xhebox/chrootd/meta/local"
 	mpro "github.com/xhebox/chrootd/meta/proxy"
@@ -24,11 +25,18 @@ import (
 )
 
 func init() {
-	if len(os.Args) < 2 || os.Args[1] != "___init" {
+	if len(os.Args) < 2 {
 		return
 	}
 
-	cloc.InitLibcontainer()
+	switch os.Args[1] {
+	case "___init":
+		cloc.InitLibcontainer()
+	case "___netns":
+		cloc.InitNetns()
+	case "___cp":
+		cloc.InitCopy()
+	}
 }
 
 func newTestCntrManager(consul bool, t *testing.T) (*TestCntrManager, error) {
@@ -133,7 +141,7 @@ func newTestCntrManager(consul bool, t *testing.T) (*TestCntrManager, error) {
 		},
 	)
 
-	msvc1, err := mpro.NewMetaService(mmgr1, con, "meta", addr1)
+	msvc1, err := mpro.NewMetaService(mmgr1, con, "meta", addr1, utils.NewAddrFree())
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +171,7 @@ func newTestCntrManager(consul bool, t *testing.T) (*TestCntrManager, error) {
 		},
 	)
 
-	msvc2, err := mpro.NewMetaService(mmgr2, con, "meta", addr2)
+	msvc2, err := mpro.NewMetaService(mmgr2, con, "meta", addr2, utils.NewAddrFree())
 	if err != nil {
 		return nil, err
 	}
@@ -189,7 +197,7 @@ func newTestCntrManager(consul bool, t *testing.T) (*TestCntrManager, error) {
 	var mpr1 mtyp.Manager
 	var mpr2 ctyp.Manager
 	if consul {
-		mpr1, err = mpro.NewMetaProxy("meta", con)
+		mpr1, err = mpro.NewMetaProxy("meta", con, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -204,7 +212,7 @@ func newTestCntrManager(consul bool, t *testing.T) (*TestCntrManager, error) {
 			return nil, err
 		}
 
-		mpr1, err = mpro.NewMetaProxy("meta", cli)
+		mpr1, err = mpro.NewMetaProxy("meta", cli, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -257,7 +265,21 @@ type TestCntrManager struct {
 	Cntr ctyp.Manager
 }
 
+func purgeCntrs(mgr ctyp.Manager) {
+	ids := []string{}
+	mgr.List("", func(info *ctyp.Cntrinfo) error {
+		ids = append(ids, info.Id)
+		return nil
+	})
+	for _, id := range ids {
+		mgr.Delete(id)
+	}
+}
+
 func (t *TestCntrManager) Close() error {
+	purgeCntrs(t.cntr1)
+	purgeCntrs(t.cntr2)
+
 	t.Meta.Close()
 	t.Cntr.Close()
 
@@ -273,6 +295,7 @@ func (t *TestCntrManager) Close() error {
 	if t.tsrv != nil {
 		t.tsrv.Stop()
 	}
+	mtest.Unmount(t.dir)
 	return os.RemoveAll(t.dir)
 }
 
@@ -377,3 +400,23 @@ func TestCntrManagerConsulList(t *testing.T) {
 
 	ctest.TestCntrManagerList(mgr.Meta, mgr.Cntr, t)
 }
+
+func TestCntrManagerRootfsRef(t *testing.T) {
+	mgr, err := NewTestCntrManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrManagerRootfsRef(mgr.Meta, mgr.Cntr, t)
+}
+
+func TestCntrManagerConsulRootfsRef(t *testing.T) {
+	mgr, err := NewTestCntrManagerConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	ctest.TestCntrManagerRootfsRef(mgr.Meta, mgr.Cntr, t)
+}
@@ -1,15 +1,17 @@
 package proxy
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
 	"github.com/segmentio/ksuid"
@@ -26,6 +28,8 @@ type CntrService struct {
 	activeConn  map[net.Conn]struct{}
 	mu          sync.Mutex
 	QueryLimits int
+	// Streams serves tokens of other services sharing the attach listener
+	Streams func(net.Conn, []byte) error
 }
 
 func NewCntrService(mgr ctyp.Manager, cli *api.Client, svcname string, rpcAddr, attachAddr *utils.Addr) (*CntrService, error) {
@@ -154,6 +158,38 @@ func (s *CntrService) CntrStopAll(ctx context.Context, req *CntrStopAllReq, res
 	return cntr.StopAll(req.Kill)
 }
 
+func (s *CntrService) CntrPause(ctx context.Context, req string, res *struct{}) error {
+	cntr, err := s.mgr.Get(req)
+	if err != nil {
+		return err
+	}
+
+	return cntr.Pause()
+}
+
+func (s *CntrService) CntrResume(ctx context.Context, req string, res *struct{}) error {
+	cntr, err := s.mgr.Get(req)
+	if err != nil {
+		return err
+	}
+
+	return cntr.Resume()
+}
+
+type CntrUpdateReq struct {
+	Id        string
+	Resources *configs.Resources
+}
+
+func (s *CntrService) CntrUpdate(ctx context.Context, req *CntrUpdateReq, res *struct{}) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	return cntr.UpdateResources(req.Resources)
+}
+
 func (s *CntrService) CntrWait(ctx context.Context, req string, res *struct{}) error {
 	cntr, err := s.mgr.Get(req)
 	if err != nil {
@@ -164,6 +200,37 @@ func (s *CntrService) CntrWait(ctx context.Context, req string, res *struct{}) e
 	return nil
 }
 
+type CntrWaitTaskReq struct {
+	Id     string
+	TaskId string
+}
+
+func (s *CntrService) CntrWaitTask(ctx context.Context, req *CntrWaitTaskReq, res *int) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	*res, err = cntr.WaitTask(ctx, req.TaskId)
+	return err
+}
+
+type CntrResizeTaskReq struct {
+	Id     string
+	TaskId string
+	Width  uint16
+	Height uint16
+}
+
+func (s *CntrService) CntrResizeTask(ctx context.Context, req *CntrResizeTaskReq, res *struct{}) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	return cntr.ResizeTask(req.TaskId, req.Width, req.Height)
+}
+
 func (s *CntrService) CntrList(ctx context.Context, req string, res *[]string) error {
 	cntr, err := s.mgr.Get(req)
 	if err != nil {
@@ -181,12 +248,131 @@ func (s *CntrService) CntrList(ctx context.Context, req string, res *[]string) e
 	})
 }
 
-type CntrAttachReq struct {
+type CntrTaskInfoReq struct {
 	Id     string
 	TaskId string
 }
 
+func (s *CntrService) CntrTaskInfo(ctx context.Context, req *CntrTaskInfoReq, res *ctyp.TaskStatus) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	status, err := cntr.TaskInfo(req.TaskId)
+	if err != nil {
+		return err
+	}
+
+	*res = *status
+	return nil
+}
+
+type CntrAttachReq struct {
+	Id         string
+	TaskId     string
+	Scrollback int
+}
+
 func (s *CntrService) CntrAttach(ctx context.Context, req *CntrAttachReq, res *[]byte) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	_, err = cntr.TaskInfo(req.TaskId)
+	if err != nil {
+		return err
+	}
+
+	*res = ksuid.New().Bytes()
+	return s.tok.Add(string(*res), req, cache.DefaultExpiration)
+}
+
+func (s *CntrService) CntrStats(ctx context.Context, req string, res *ctyp.Stats) error {
+	cntr, err := s.mgr.Get(req)
+	if err != nil {
+		return err
+	}
+
+	st, err := cntr.Stats()
+	if err != nil {
+		return err
+	}
+
+	*res = *st
+	return nil
+}
+
+type CntrWatchStatsReq struct {
+	Id       string
+	Interval time.Duration
+}
+
+func (s *CntrService) CntrWatchStats(ctx context.Context, req *CntrWatchStatsReq, res *[]byte) error {
+	_, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	*res = ksuid.New().Bytes()
+	return s.tok.Add(string(*res), req, cache.DefaultExpiration)
+}
+
+type CntrLogsReq struct {
+	Id     string
+	TaskId string
+	Since  time.Time
+	Follow bool
+	Tail   int
+}
+
+func (s *CntrService) CntrLogs(ctx context.Context, req *CntrLogsReq, res *[]byte) error {
+	_, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	*res = ksuid.New().Bytes()
+	return s.tok.Add(string(*res), req, cache.DefaultExpiration)
+}
+
+type CntrPortForwardReq struct {
+	Id   string
+	Port uint16
+}
+
+func (s *CntrService) CntrPortForward(ctx context.Context, req *CntrPortForwardReq, res *[]byte) error {
+	_, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	*res = ksuid.New().Bytes()
+	return s.tok.Add(string(*res), req, cache.DefaultExpiration)
+}
+
+type CntrCopyToReq struct {
+	Id   string
+	Path string
+}
+
+func (s *CntrService) CntrCopyTo(ctx context.Context, req *CntrCopyToReq, res *[]byte) error {
+	_, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	*res = ksuid.New().Bytes()
+	return s.tok.Add(string(*res), req, cache.DefaultExpiration)
+}
+
+type CntrCopyFromReq struct {
+	Id   string
+	Path string
+}
+
+func (s *CntrService) CntrCopyFrom(ctx context.Context, req *CntrCopyFromReq, res *[]byte) error {
 	_, err := s.mgr.Get(req.Id)
 	if err != nil {
 		return err
@@ -196,6 +382,163 @@ func (s *CntrService) CntrAttach(ctx context.Context, req *CntrAttachReq, res *[
 	return s.tok.Add(string(*res), req, cache.DefaultExpiration)
 }
 
+func (s *CntrService) serveAttach(conn net.Conn, req *CntrAttachReq) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	rw, err := cntr.Attach(req.TaskId, req.Scrollback)
+	if err != nil {
+		return err
+	}
+	defer rw.Close()
+
+	ctyp.ServeAttach(conn, rw)
+	return nil
+}
+
+// watchConn returns a context cancelled once the client has gone
+func watchConn(conn net.Conn) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+func writeJSONFrame(conn net.Conn, typ ctyp.FrameType, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return ctyp.WriteFrame(conn, &ctyp.Frame{Type: typ, Payload: b})
+}
+
+func (s *CntrService) serveLogs(conn net.Conn, req *CntrLogsReq) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := watchConn(conn)
+	defer cancel()
+
+	err = cntr.Logs(ctx, req.TaskId, &ctyp.LogOptions{
+		Since:  req.Since,
+		Follow: req.Follow,
+		Tail:   req.Tail,
+	}, func(e *ctyp.LogEntry) error {
+		return writeJSONFrame(conn, ctyp.FrameLog, e)
+	})
+	if err != nil {
+		return err
+	}
+
+	return ctyp.WriteFrame(conn, &ctyp.Frame{Type: ctyp.FrameExit, Payload: make([]byte, 4)})
+}
+
+func (s *CntrService) serveStats(conn net.Conn, req *CntrWatchStatsReq) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := watchConn(conn)
+	defer cancel()
+
+	return cntr.WatchStats(ctx, req.Interval, func(st *ctyp.Stats) error {
+		return writeJSONFrame(conn, ctyp.FrameStats, st)
+	})
+}
+
+func (s *CntrService) servePortForward(conn net.Conn, req *CntrPortForwardReq) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	target, err := cntr.PortForward(req.Port)
+	if err != nil {
+		return err
+	}
+
+	return ctyp.ServeForward(conn, target)
+}
+
+// serveCopyTo reads the tar stream from stdin frames, until stdin is closed
+func (s *CntrService) serveCopyTo(conn net.Conn, req *CntrCopyToReq) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for {
+			fr, err := ctyp.ReadFrame(conn)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			switch fr.Type {
+			case ctyp.FrameStdin:
+				_, err = pw.Write(fr.Payload)
+				if err != nil {
+					return
+				}
+			case ctyp.FrameCloseStdin:
+				pw.Close()
+				return
+			}
+		}
+	}()
+
+	err = cntr.CopyTo(req.Path, pr)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+
+	return ctyp.WriteFrame(conn, &ctyp.Frame{Type: ctyp.FrameExit, Payload: make([]byte, 4)})
+}
+
+// serveCopyFrom sends the tar stream as stdout frames
+func (s *CntrService) serveCopyFrom(conn net.Conn, req *CntrCopyFromReq) error {
+	cntr, err := s.mgr.Get(req.Id)
+	if err != nil {
+		return err
+	}
+
+	rd, err := cntr.CopyFrom(req.Path)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := rd.Read(buf)
+		if n > 0 {
+			werr := ctyp.WriteFrame(conn, &ctyp.Frame{Type: ctyp.FrameStdout, Payload: buf[:n]})
+			if werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return ctyp.WriteFrame(conn, &ctyp.Frame{Type: ctyp.FrameExit, Payload: make([]byte, 4)})
+}
+
 func (s *CntrService) ServeListener(ln net.Listener) error {
 	defer ln.Close()
 
@@ -216,9 +559,13 @@ func (s *CntrService) ServeListener(ln net.Listener) error {
 		go func(conn net.Conn) (err error) {
 			defer func() {
 				if err != nil {
-					conn.Write([]byte("internal error\n"))
+					ctyp.WriteFrame(conn, &ctyp.Frame{Type: ctyp.FrameError, Payload: []byte(err.Error())})
 				}
 				conn.Close()
+
+				s.mu.Lock()
+				delete(s.activeConn, conn)
+				s.mu.Unlock()
 			}()
 
 			var tok ksuid.KSUID
@@ -229,59 +576,25 @@ func (s *CntrService) ServeListener(ln net.Listener) error {
 			}
 
 			tmp, _ := s.tok.Get(string(tok.Bytes()))
-			reqt, ok := tmp.(*CntrAttachReq)
-			if !ok {
-				return errors.New("invalid token")
-			}
-
-			var cntr ctyp.Cntr
-			cntr, err = s.mgr.Get(reqt.Id)
-			if err != nil {
-				return err
-			}
-
-			var rw ctyp.Attacher
-			rw, err = cntr.Attach(reqt.TaskId)
-			if err != nil {
-				return err
-			}
-
-			ch := make(chan bool, 1)
-
-			go func() {
-				io.Copy(rw, conn)
-				rw.CloseWrite()
-				ch <- true
-			}()
-
-			buf := make([]byte, 128)
-			lcond := true
-			for lcond {
-				select {
-				case <-ch:
-					lcond = false
-				default:
-				}
-
-				n, err := rw.Read(buf)
-				if err != nil {
-					if err != io.EOF {
-						conn.Close()
-					}
-					lcond = false
-				}
-
-				_, err = io.Copy(conn, bytes.NewReader(buf[:n]))
-				if err != nil {
-					conn.Close()
+			switch req := tmp.(type) {
+			case *CntrAttachReq:
+				return s.serveAttach(conn, req)
+			case *CntrLogsReq:
+				return s.serveLogs(conn, req)
+			case *CntrWatchStatsReq:
+				return s.serveStats(conn, req)
+			case *CntrPortForwardReq:
+				return s.servePortForward(conn, req)
+			case *CntrCopyToReq:
+				return s.serveCopyTo(conn, req)
+			case *CntrCopyFromReq:
+				return s.serveCopyFrom(conn, req)
+			default:
+				if s.Streams != nil {
+					return s.Streams(conn, tok.Bytes())
 				}
+				return errors.New("invalid token")
 			}
-
-			s.mu.Lock()
-			delete(s.activeConn, c)
-			s.mu.Unlock()
-
-			return nil
 		}(c)
 	}
 }
@@ -1,12 +1,19 @@
 package test
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"io"
 	"io/ioutil"
+	"net"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/pkg/errors"
 	ctyp "github.com/xhebox/chrootd/cntr"
 	mtyp "github.com/xhebox/chrootd/meta"
@@ -284,7 +291,7 @@ func TestCntrInstanceAttach(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T)
 		t.Fatal(err)
 	}
 
-	rw, err := cntr.Attach(tid)
+	rw, err := cntr.Attach(tid, -1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -309,7 +316,7 @@ func TestCntrInstanceAttach(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T)
 		t.Fatal(err)
 	}
 
-	rw, err = cntr.Attach(tid)
+	rw, err = cntr.Attach(tid, -1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -334,15 +341,9 @@ func TestCntrInstanceAttach(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T)
 	t.Logf("content %s\n", b)
 
 	// case3: attach wrong task id
-	rw, err = cntr.Attach("34")
+	_, err = cntr.Attach("34", -1)
 	if err == nil {
-		// service case
-		defer rw.Close()
-
-		b, err = ioutil.ReadAll(rw)
-		if !strings.HasPrefix(string(b), "internal error") {
-			t.Fatal("except wrong res")
-		}
+		t.Fatal("expect an error when attaching a wrong task id")
 	}
 }
 
@@ -406,3 +407,1528 @@ func TestCntrInstanceList(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCntrInstanceTaskInfo(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta: meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/ls"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cntr.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := cntr.TaskInfo(tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.State != ctyp.TaskExited || status.ExitCode != 0 {
+		t.Fatalf("unexpected task status: %+v", status)
+	}
+
+	if len(status.Args) != 1 || status.Args[0] != "/bin/ls" {
+		t.Fatalf("unexpected task args: %v", status.Args)
+	}
+
+	_, err = cntr.TaskInfo("34")
+	if err == nil {
+		t.Fatal("expect an error for a non-existent task")
+	}
+}
+
+func TestCntrInstanceWaitTask(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta: meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "exit 3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := cntr.WaitTask(context.Background(), tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != 3 {
+		t.Fatalf("expect exit code 3, got %d", code)
+	}
+
+	// case 2: cancelled before the task exits
+	tid, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sleep", "10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, err = cntr.WaitTask(ctx, tid)
+	if err == nil {
+		t.Fatal("expect an error after the context is done")
+	}
+
+	err = cntr.Stop(tid, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err = cntr.WaitTask(context.Background(), tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != 128+9 {
+		t.Fatalf("expect killed exit code, got %d", code)
+	}
+}
+
+func TestCntrInstanceTty(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args:       []string{"/bin/sh"},
+		Tty:        true,
+		TermWidth:  80,
+		TermHeight: 24,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := cntr.TaskInfo(tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.Tty {
+		t.Fatal("expect task to be attached to a terminal")
+	}
+
+	err = cntr.ResizeTask(tid, 100, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw, err := cntr.Attach(tid, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	err = rw.Resize(120, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the arithmetic keeps the echoed input from matching
+	_, err = io.Copy(rw, strings.NewReader("[ -t 0 ] && echo is$((1+1))tty; exit\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadAll(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), "is2tty") {
+		t.Fatalf("expect output from a terminal, got: %q", b)
+	}
+
+	code, err := cntr.WaitTask(context.Background(), tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != 0 {
+		t.Fatalf("expect exit code 0, got %d", code)
+	}
+
+	// case 2: tasks without terminal can not be resized
+	tid, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/true"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cntr.ResizeTask(tid, 100, 30)
+	if err == nil {
+		t.Fatal("expect an error when resizing a task without terminal")
+	}
+}
+
+func TestCntrInstanceAttachStreams(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "echo out; echo err >&2; exit 5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw, err := cntr.Attach(tid, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	b, err := ioutil.ReadAll(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "out\n" {
+		t.Fatalf("unexpected stdout: %q", b)
+	}
+
+	b, err = ioutil.ReadAll(rw.Stderr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "err\n" {
+		t.Fatalf("unexpected stderr: %q", b)
+	}
+
+	code, err := rw.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != 5 {
+		t.Fatalf("expect exit code 5, got %d", code)
+	}
+
+	// case 2: binary stdin is passed through untouched
+	tid, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/cat"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw, err = cntr.Attach(tid, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	data := []byte{0x00, 0x03, 0x04, 0xff, 0x03, '\n'}
+	_, err = rw.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rw.CloseWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = ioutil.ReadAll(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, data) {
+		t.Fatalf("unexpected stdout: %q", b)
+	}
+
+	code, err = rw.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != 0 {
+		t.Fatalf("expect exit code 0, got %d", code)
+	}
+
+	// case 3: signal through the attacher
+	tid, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sleep", "10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rw, err = cntr.Attach(tid, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	err = rw.Signal(syscall.SIGKILL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err = rw.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != 128+9 {
+		t.Fatalf("expect killed exit code, got %d", code)
+	}
+}
+
+func TestCntrInstanceAttachScrollback(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "echo abcdef; exec /bin/cat"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(rw ctyp.Attacher, s string) {
+		b := make([]byte, len(s))
+		_, err := io.ReadFull(rw, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != s {
+			t.Fatalf("expect %q, got %q", s, b)
+		}
+	}
+
+	rw1, err := cntr.Attach(tid, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw1.Close()
+
+	expect(rw1, "abcdef\n")
+
+	rw2, err := cntr.Attach(tid, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw2.Close()
+
+	expect(rw2, "ef\n")
+
+	rw3, err := cntr.Attach(tid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every attacher sees the new output
+	_, err = rw1.Write([]byte("xyz\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect(rw1, "xyz\n")
+	expect(rw2, "xyz\n")
+	expect(rw3, "xyz\n")
+
+	// detaching does not affect the others
+	err = rw3.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rw2.CloseWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rw := range []ctyp.Attacher{rw1, rw2} {
+		code, err := rw.Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code != 0 {
+			t.Fatalf("expect exit code 0, got %d", code)
+		}
+	}
+}
+
+func TestCntrInstanceLogs(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collect := func(tid string, opts *ctyp.LogOptions) []ctyp.LogEntry {
+		res := []ctyp.LogEntry{}
+		err := cntr.Logs(context.Background(), tid, opts, func(e *ctyp.LogEntry) error {
+			res = append(res, *e)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "echo a; echo b >&2; echo c"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cntr.WaitTask(context.Background(), tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := collect(tid, &ctyp.LogOptions{Tail: -1})
+	if len(logs) != 3 {
+		t.Fatalf("expect 3 entries, got %+v", logs)
+	}
+
+	// streams are separated, only the order inside one stream is kept
+	streams := map[string]string{}
+	for _, e := range logs {
+		streams[e.Stream] += e.Log
+	}
+
+	if streams["stdout"] != "a\nc\n" || streams["stderr"] != "b\n" {
+		t.Fatalf("unexpected entries %+v", logs)
+	}
+
+	// case 2: tail
+	last := logs[2]
+	logs = collect(tid, &ctyp.LogOptions{Tail: 1})
+	if len(logs) != 1 || logs[0] != last {
+		t.Fatalf("unexpected entries %+v", logs)
+	}
+
+	// case 3: since
+	logs = collect(tid, &ctyp.LogOptions{Tail: -1, Since: time.Now().Add(time.Hour)})
+	if len(logs) != 0 {
+		t.Fatalf("unexpected entries %+v", logs)
+	}
+
+	// case 4: follow until the task exits
+	tid, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "echo x; sleep 1; echo y"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs = collect(tid, &ctyp.LogOptions{Tail: -1, Follow: true})
+	if len(logs) != 2 || logs[0].Log != "x\n" || logs[1].Log != "y\n" {
+		t.Fatalf("unexpected entries %+v", logs)
+	}
+
+	// case 5: following is cancelled by the context
+	tid, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sleep", "10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err = cntr.Logs(ctx, tid, &ctyp.LogOptions{Tail: -1, Follow: true}, func(e *ctyp.LogEntry) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expect an error after the context is done")
+	}
+
+	err = cntr.Stop(tid, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// case 6: unknown task
+	err = cntr.Logs(context.Background(), "34", &ctyp.LogOptions{Tail: -1}, func(e *ctyp.LogEntry) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expect an error for an unknown task")
+	}
+}
+
+func TestCntrInstancePause(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(state ctyp.CntrState) {
+		info, err := cntr.Meta()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.State != state {
+			t.Fatalf("expect state %s, got %s", state, info.State)
+		}
+	}
+
+	expect(ctyp.CntrStopped)
+
+	err = cntr.Pause()
+	if err == nil {
+		t.Fatal("expect an error when pausing a stopped container")
+	}
+
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "read line; echo $line"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect(ctyp.CntrRunning)
+
+	err = cntr.Pause()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect(ctyp.CntrPaused)
+
+	// the listing reports the state too
+	found := false
+	err = cmgr.List("[]", func(info *ctyp.Cntrinfo) error {
+		if info.Id == cid {
+			found = true
+			if info.State != ctyp.CntrPaused {
+				return errors.Errorf("expect state paused, got %s", info.State)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found {
+		t.Fatal("can not find the container")
+	}
+
+	rw, err := cntr.Attach(tid, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	_, err = rw.Write([]byte("thawed\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing runs before resuming
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, err = cntr.WaitTask(ctx, tid)
+	if err == nil {
+		t.Fatal("expect the task to be frozen")
+	}
+
+	err = cntr.Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadAll(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "thawed\n" {
+		t.Fatalf("unexpected output %q", b)
+	}
+
+	// the init task has exited
+	_, err = cntr.WaitTask(context.Background(), tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect(ctyp.CntrStopped)
+}
+
+func TestCntrInstanceUpdateResources(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.Resources.CpuShares = 512
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cntr.UpdateResources(&configs.Resources{PidsLimit: 64})
+	if err == nil {
+		t.Fatal("expect an error when updating a stopped container")
+	}
+
+	_, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sleep", "10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cntr.StopAll(true)
+
+	err = cntr.UpdateResources(&configs.Resources{PidsLimit: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := cntr.Meta()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// updated fields are merged into the old ones
+	res := info.Meta.Resources
+	if res.PidsLimit != 64 || res.CpuShares != 512 {
+		t.Fatalf("unexpected resources %+v", res)
+	}
+}
+
+func TestCntrInstanceStats(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sleep", "10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cntr.StopAll(true)
+
+	st, err := cntr.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Id != cid || st.Time.IsZero() {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	if st.Pids < 1 {
+		t.Fatalf("expect at least one process, got %+v", st)
+	}
+
+	t.Logf("stats %+v\n", st)
+
+	// case 2: streaming until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+
+	cnt := 0
+	err = cntr.WatchStats(ctx, 100*time.Millisecond, func(st *ctyp.Stats) error {
+		if st.Id != cid {
+			return errors.Errorf("unexpected stats %+v", st)
+		}
+		cnt++
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expect the deadline to be exceeded, got %v", err)
+	}
+
+	if cnt < 2 {
+		t.Fatalf("expect several samples, got %d", cnt)
+	}
+}
+
+func freePort(network string, t *testing.T) uint16 {
+	var addr net.Addr
+	if network == "udp" {
+		pc, err := net.ListenPacket(network, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = pc.LocalAddr()
+		pc.Close()
+	} else {
+		l, err := net.Listen(network, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = l.Addr()
+		l.Close()
+	}
+
+	_, port, _ := net.SplitHostPort(addr.String())
+	n, _ := strconv.Atoi(port)
+	return uint16(n)
+}
+
+func TestCntrInstancePorts(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	// the container shares the network of the host, services of the host
+	// are seen as its services
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	tcpPort := uint16(l.Addr().(*net.TCPAddr).Port)
+	udpPort := uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+	hostTCP := freePort("tcp", t)
+	hostUDP := freePort("udp", t)
+
+	// case 1: ports are published on create
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+		Network:        mtyp.Network{Mode: mtyp.NetworkHost},
+		Ports: []mtyp.PortMapping{
+			{HostPort: hostTCP, ContainerPort: tcpPort},
+			{HostPort: hostUDP, ContainerPort: udpPort, Proto: mtyp.ProtoUDP},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := cntr.Meta()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(info.Meta.Ports) != 2 {
+		t.Fatalf("unexpected ports %v", info.Meta.Ports)
+	}
+
+	echo := func(conn io.ReadWriteCloser) {
+		defer conn.Close()
+
+		_, err := conn.Write([]byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = conn.(interface{ CloseWrite() error }).CloseWrite()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := ioutil.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != "hello" {
+			t.Fatalf("unexpected echo %q", b)
+		}
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(hostTCP))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(conn)
+
+	uconn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(hostUDP))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uconn.Close()
+
+	_, err = uconn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uconn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	n, err := uconn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("unexpected reply %q", buf[:n])
+	}
+
+	// case 2: ports can be forwarded without publishing
+	fconn, err := cntr.PortForward(tcpPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(fconn)
+
+	// case 3: ports are released with the container, and connections are
+	// closed
+	idle, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(hostTCP))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	err = cmgr.Delete(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = ioutil.ReadAll(idle)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("expect connections to be closed")
+	}
+
+	_, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(hostTCP))))
+	if err == nil {
+		t.Fatal("expect error for an unpublished port")
+	}
+}
+
+func TestCntrInstanceImageConfig(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// case 1: args of the image
+	tid, err := cntr.Start(&ctyp.Taskinfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := cntr.TaskInfo(tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status.Args) != 1 || status.Args[0] != "/bin/sh" {
+		t.Fatalf("unexpected task args: %v", status.Args)
+	}
+
+	err = cntr.Stop(tid, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cntr.WaitTask(context.Background(), tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// case 2: env is merged, fields of the task are preferred
+	tid, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "echo $IMAGE $PATH $FOO; pwd"},
+		Env:  []string{"IMAGE=task", "FOO=bar"},
+		Cwd:  "/bin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cntr.WaitTask(context.Background(), tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out string
+	err = cntr.Logs(context.Background(), tid, &ctyp.LogOptions{Tail: -1}, func(e *ctyp.LogEntry) error {
+		out += e.Log
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out != "task /bin bar\n/bin\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestCntrInstanceUser(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+		UidMapSize:     65536,
+		GidMapSize:     65536,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(rt *ctyp.Taskinfo) string {
+		tid, err := cntr.Start(rt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = cntr.WaitTask(context.Background(), tid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out string
+		err = cntr.Logs(context.Background(), tid, &ctyp.LogOptions{Tail: -1}, func(e *ctyp.LogEntry) error {
+			out += e.Log
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	// case 1: names are resolved
+	out := run(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "id -u; id -g"},
+		User: "alice",
+	})
+	if out != "1000\n1000\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	// case 2: with the group
+	out = run(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "id -u; id -g"},
+		User: "alice:wheel",
+	})
+	if out != "1000\n10\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	// case 3: unknown names
+	_, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/id"},
+		User: "bob",
+	})
+	if err == nil {
+		t.Fatal("expect an error for an unknown user")
+	}
+
+	_, err = cntr.Start(&ctyp.Taskinfo{
+		Args:             []string{"/bin/id"},
+		AdditionalGroups: []string{"bob"},
+	})
+	if err == nil {
+		t.Fatal("expect an error for an unknown group")
+	}
+
+	// case 4: ids out of the mappings
+	_, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/id"},
+		User: "70000",
+	})
+	if err == nil {
+		t.Fatal("expect an error for an unmapped uid")
+	}
+
+	_, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/id"},
+		User: "0:70000",
+	})
+	if err == nil {
+		t.Fatal("expect an error for an unmapped gid")
+	}
+}
+
+func TestCntrInstanceCopy(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sleep", "100"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "in/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "in/a", Typeflag: tar.TypeReg, Mode: 0644, Size: 5},
+		{Name: "in/l", Typeflag: tar.TypeSymlink, Linkname: "/"},
+		{Name: "in/l/b", Typeflag: tar.TypeReg, Mode: 0644, Size: 5},
+	} {
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte("hello"))
+		}
+	}
+	tw.Close()
+
+	// case 1: into a running container
+	err = cntr.CopyTo("/data", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sh", "-c", "cat /data/in/a /data/b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cntr.WaitTask(context.Background(), tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// symlinks are resolved inside the destination
+	var out string
+	err = cntr.Logs(context.Background(), tid, &ctyp.LogOptions{Tail: -1}, func(e *ctyp.LogEntry) error {
+		out += e.Log
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out != "hellohello" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	readTar := func(src string) map[string]string {
+		rd, err := cntr.CopyFrom(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rd.Close()
+
+		res := map[string]string{}
+		tr := tar.NewReader(rd)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res[hdr.Name] = string(b) + hdr.Linkname
+		}
+		return res
+	}
+
+	// case 2: from a running container
+	files := readTar("/data/in")
+	if len(files) != 3 || files["in/"] != "" || files["in/a"] != "hello" || files["in/l"] != "/" {
+		t.Fatalf("unexpected files %v", files)
+	}
+
+	err = cntr.StopAll(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cntr.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// case 3: from the rootfs of a stopped container
+	files = readTar("/data/b")
+	if len(files) != 1 || files["b"] != "hello" {
+		t.Fatalf("unexpected files %v", files)
+	}
+
+	// case 4: non-existent path
+	rd, err := cntr.CopyFrom("/nonexist")
+	if err == nil {
+		_, err = ioutil.ReadAll(rd)
+		rd.Close()
+	}
+	if err == nil {
+		t.Fatal("expect an error for a non-existent path")
+	}
+}
+
+func TestCntrInstanceDiskQuota(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	quota := uint64(32 << 20)
+
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+		DiskQuota:      quota,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta:   meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cntr, err := cmgr.Get(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tid, err := cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/dd", "if=/dev/zero", "of=/big", "bs=1M", "count=64"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := cntr.WaitTask(context.Background(), tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code == 0 {
+		t.Fatal("expect writes beyond the quota to fail")
+	}
+
+	_, err = cntr.Start(&ctyp.Taskinfo{
+		Args: []string{"/bin/sleep", "10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cntr.StopAll(true)
+
+	st, err := cntr.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.DiskQuota != quota || st.DiskUsage < quota/2 || st.DiskUsage > quota {
+		t.Fatalf("unexpected disk usage %d / %d", st.DiskUsage, st.DiskQuota)
+	}
+
+	info, err := cntr.Meta()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.DiskQuota != quota || info.DiskUsage < quota/2 {
+		t.Fatalf("unexpected disk usage %d / %d", info.DiskUsage, info.DiskQuota)
+	}
+}
@@ -160,3 +160,56 @@ func TestCntrManagerList(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCntrManagerRootfsRef(mmgr mtyp.Manager, cmgr ctyp.Manager, t *testing.T) {
+	mid, err := mmgr.Create(&mtyp.Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := mmgr.Get(mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mmgr.ImageUnpack(context.Background(), mid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid, err := cmgr.Create(&ctyp.Cntrinfo{
+		Rootfs: rid,
+		Meta: meta,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mmgr.ImageDelete(mid, rid)
+	if err == nil {
+		t.Fatal("expect rootfs in use not to be deleted")
+	}
+
+	ids, err := mmgr.ImagePrune(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 0 {
+		t.Fatalf("expect rootfs in use not to be pruned, got %v", ids)
+	}
+
+	err = cmgr.Delete(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mmgr.ImageDelete(mid, rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,32 +1,113 @@
 package cntr
 
 import (
+	"context"
 	"io"
+	"syscall"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
 	mtyp "github.com/xhebox/chrootd/meta"
 )
 
 type Taskinfo struct {
-	Args         []string             `json:"args"`
-	Env          []string             `json:"env"`
-	Capabilities configs.Capabilities `json:"capabilities"`
-	Rlimits      []specs.POSIXRlimit  `json:"rlimits"`
-	TermHeight   uint16               `json:"term_height"`
-	TermWidth    uint16               `json:"term_width"`
+	Args             []string             `json:"args"`
+	Env              []string             `json:"env"`
+	Cwd              string               `json:"cwd"`
+	User             string               `json:"user"`
+	AdditionalGroups []string             `json:"additional_groups"`
+	Capabilities     configs.Capabilities `json:"capabilities"`
+	Rlimits          []specs.POSIXRlimit  `json:"rlimits"`
+	TermHeight       uint16               `json:"term_height"`
+	TermWidth        uint16               `json:"term_width"`
+	Tty              bool                 `json:"tty"`
 }
 
+// ErrTaskNotFound is returned for tasks that do not exist, or have expired.
+// errors are strings over rpc, compare messages of them.
+var ErrTaskNotFound = errors.New("can not find task")
+
+type TaskState string
+
+const (
+	TaskCreated TaskState = "created"
+	TaskRunning TaskState = "running"
+	TaskExited  TaskState = "exited"
+	TaskKilled  TaskState = "killed"
+)
+
+type TaskStatus struct {
+	Id         string    `json:"id"`
+	State      TaskState `json:"state"`
+	ExitCode   int       `json:"exit_code"`
+	Signal     int       `json:"signal"`
+	Args       []string  `json:"args"`
+	Tty        bool      `json:"tty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+type LogEntry struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Log    string    `json:"log"`
+}
+
+// Tail limits the number of entries before following, negative for all.
+type LogOptions struct {
+	Since  time.Time
+	Follow bool
+	Tail   int
+}
+
+// cpu times are in nanoseconds, and the others are in bytes
+type Stats struct {
+	Id          string    `json:"id"`
+	Time        time.Time `json:"time"`
+	CpuTotal    uint64    `json:"cpu_total"`
+	CpuUser     uint64    `json:"cpu_user"`
+	CpuKernel   uint64    `json:"cpu_kernel"`
+	MemoryUsage uint64    `json:"memory_usage"`
+	MemoryMax   uint64    `json:"memory_max"`
+	MemoryLimit uint64    `json:"memory_limit"`
+	Pids        uint64    `json:"pids"`
+	PidsLimit   uint64    `json:"pids_limit"`
+	BlkioRead   uint64    `json:"blkio_read"`
+	BlkioWrite  uint64    `json:"blkio_write"`
+	DiskUsage   uint64    `json:"disk_usage"`
+	DiskQuota   uint64    `json:"disk_quota"`
+}
+
+type CntrState string
+
+const (
+	CntrRunning CntrState = "running"
+	CntrPaused  CntrState = "paused"
+	CntrStopped CntrState = "stopped"
+)
+
+// disk usage is only reported for rootfs with quotas
 type Cntrinfo struct {
-	Id     string
-	Rootfs string
-	Tags   []string
-	Meta   *mtyp.Metainfo
+	Id        string
+	Rootfs    string
+	Tags      []string
+	Meta      *mtyp.Metainfo
+	State     CntrState
+	IP        string
+	DiskUsage uint64
+	DiskQuota uint64
 }
 
+// Read and Write are bound to stdout and stdin of the task.
 type Attacher interface {
 	io.ReadWriteCloser
+	Stderr() io.Reader
 	CloseWrite() error
+	Signal(syscall.Signal) error
+	Resize(uint16, uint16) error
+	Wait() (int, error)
 }
 
 type Cntr interface {
@@ -34,9 +115,24 @@ type Cntr interface {
 	Start(*Taskinfo) (string, error)
 	Stop(string, bool) error
 	StopAll(bool) error
+	Pause() error
+	Resume() error
+	UpdateResources(*configs.Resources) error
+	Stats() (*Stats, error)
+	WatchStats(context.Context, time.Duration, func(*Stats) error) error
 	Wait() error
-	Attach(string) (Attacher, error)
+	WaitTask(context.Context, string) (int, error)
+	ResizeTask(string, uint16, uint16) error
+	Attach(string, int) (Attacher, error)
 	List(func(string) error) error
+	TaskInfo(string) (*TaskStatus, error)
+	Logs(context.Context, string, *LogOptions, func(*LogEntry) error) error
+	// PortForward connects to a tcp port inside the container.
+	PortForward(uint16) (io.ReadWriteCloser, error)
+	// CopyTo extracts the tar stream into a directory of the container.
+	CopyTo(string, io.Reader) error
+	// CopyFrom archives a path of the container as a tar stream.
+	CopyFrom(string) (io.ReadCloser, error)
 }
 
 type Manager interface {
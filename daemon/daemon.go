@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/opencontainers/runc/libcontainer/seccomp"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/smallnest/rpcx/log"
@@ -27,6 +28,8 @@ import (
 	mpro "github.com/xhebox/chrootd/meta/proxy"
 	"github.com/xhebox/chrootd/store"
 	"github.com/xhebox/chrootd/utils"
+	vloc "github.com/xhebox/chrootd/volume/local"
+	vpro "github.com/xhebox/chrootd/volume/proxy"
 )
 
 var (
@@ -80,7 +83,7 @@ func main() {
 					Name:        "run",
 					Value:       "/var/lib/chrootd",
 					EnvVars:     []string{"CHROOTD_RUNPATH"},
-					Usage:       "daemon `RUNPATH` for states,unpacked images(should be large enough)",
+					Usage:       "daemon `RUNPATH` for states,unpacked images,volumes(should be large enough)",
 					Destination: &u.RunPath,
 				},
 				&cli.StringFlag{
@@ -145,6 +148,11 @@ func main() {
 					Value:       false,
 					Destination: &u.ServiceBindresolv,
 				},
+				&cli.BoolFlag{
+					Name:  "service_noseccomp",
+					Usage: "start without seccomp support, containers run without the default seccomp profile",
+					Value: false,
+				},
 				&cli.StringSliceFlag{
 					Name:        "service_prehook",
 					Usage:       "runhooks before container start",
@@ -153,15 +161,58 @@ func main() {
 					Name:        "service_posthook",
 					Usage:       "runhooks after container stop",
 				},
+				&cli.DurationFlag{
+					Name:  "gc_interval",
+					Usage: "how often rootfs no longer used by containers are collected, 0 to disable",
+					Value: 10 * time.Minute,
+				},
+				&cli.DurationFlag{
+					Name:  "gc_grace",
+					Usage: "how long a rootfs is kept after the last container using it is gone, rootfs never used are kept",
+					Value: time.Hour,
+				},
+				&cli.DurationFlag{
+					Name:  "task_retention",
+					Usage: "how long the status of exited tasks is kept",
+					Value: 5 * time.Minute,
+				},
+				&utils.SizeFlag{
+					Name:  "task_output_buffer",
+					Usage: "bytes of output kept for each stream of a task",
+					Value: 1 << 20,
+				},
+				&utils.SizeFlag{
+					Name:  "log_max_size",
+					Usage: "rotate the log file of a task after this size",
+					Value: 10 << 20,
+				},
+				&cli.IntFlag{
+					Name:  "log_max_files",
+					Usage: "the number of rotated log files kept for each task",
+					Value: 3,
+				},
+				&cli.StringFlag{
+					Name:  "bridge",
+					Usage: "`name` of the bridge for bridge networking",
+					Value: "chrootd0",
+				},
+				&cli.StringFlag{
+					Name:  "bridge_subnet",
+					Usage: "`cidr` of the bridge, addresses of containers are allocated from it",
+					Value: "10.88.0.0/16",
+				},
 				&cli.StringFlag{
 					Name:        "attach_addr",
-					Usage:       "`address` for process attach",
+					Usage:       "`address` for process attach, image import and export",
 					Value:       ":9092",
 					Destination: &u.AttachAddr,
 				},
 			}),
 		Commands: cli.Commands{
 			cloc.InitFlag,
+			cloc.NetnsFlag,
+			cloc.CopyFlag,
+			mloc.RemoveFlag,
 		},
 		Before: utils.NewTomlFlagLoader("config"),
 		Action: func(c *cli.Context) error {
@@ -189,6 +240,14 @@ func main() {
 
 			log.SetLogger(utils.NewRpcxLogger(user.Logger))
 
+			// seccomp needs libseccomp and the seccomp build tag
+			if !seccomp.IsEnabled() {
+				if !c.Bool("service_noseccomp") {
+					return errors.New("seccomp is not supported, build with -tags seccomp or start with --service_noseccomp")
+				}
+				user.Logger.Warn().Msg("seccomp is not supported, containers run without the default seccomp profile")
+			}
+
 			states, err := store.NewBolt(filepath.Join(user.RunPath, "states"), "chrootd")
 			if err != nil {
 				return err
@@ -197,6 +256,8 @@ func main() {
 
 			mmgr, err := mloc.NewMetaManager(user.RunPath, user.ImagePath, states, func(m *mloc.MetaManager) error {
 				m.Rootless = user.ServiceRootless
+				m.GCInterval = c.Duration("gc_interval")
+				m.GCGrace = c.Duration("gc_grace")
 				return nil
 			})
 			if err != nil {
@@ -204,11 +265,23 @@ func main() {
 			}
 			defer mmgr.Close()
 
+			vmgr, err := vloc.NewVolumeManager(user.RunPath, states)
+			if err != nil {
+				return err
+			}
+			defer vmgr.Close()
+
 			cmgr, err := cloc.NewCntrManager(user.RunPath, user.ImagePath, states, func(m *cloc.CntrManager) error {
 				m.Rootless = user.ServiceRootless
 				m.BinResolv = user.ServiceBindresolv
 				m.Prehook = c.StringSlice("service_prehook")
 				m.Posthook = c.StringSlice("service_posthook")
+				m.TaskRetention = c.Duration("task_retention")
+				m.OutputBuffer = int(c.Int64("task_output_buffer"))
+				m.LogMaxSize = c.Int64("log_max_size")
+				m.LogMaxFiles = c.Int("log_max_files")
+				m.Bridge = c.String("bridge")
+				m.BridgeSubnet = c.String("bridge_subnet")
 				return nil
 			})
 			if err != nil {
@@ -262,7 +335,7 @@ func main() {
 				}
 			}
 
-			msvc, err := mpro.NewMetaService(mmgr, con, "meta", rpcAddr)
+			msvc, err := mpro.NewMetaService(mmgr, con, "meta", rpcAddr, attachAddr)
 			if err != nil {
 				return err
 			}
@@ -277,11 +350,23 @@ func main() {
 				return err
 			}
 
+			csvc.Streams = msvc.ServeStream
+
 			err = srv.RegisterName("cntr", csvc, "")
 			if err != nil {
 				return err
 			}
 
+			vsvc, err := vpro.NewVolumeService(vmgr, con, "volume", rpcAddr)
+			if err != nil {
+				return err
+			}
+
+			err = srv.RegisterName("volume", vsvc, "")
+			if err != nil {
+				return err
+			}
+
 			lnRPC, err := net.Listen(rpcAddr.Network(), rpcAddr.String())
 			if err != nil {
 				return err
@@ -6,7 +6,8 @@ replace github.com/xhebox/chrootd => ./
 
 require (
 	github.com/checkpoint-restore/go-criu v0.0.0-20191125063657-fcdcd07065c5 // indirect
-	github.com/containerd/console v1.0.0 // indirect
+	github.com/containerd/console v1.0.0
+	github.com/cyphar/filepath-securejoin v0.2.2
 	github.com/docker/go-units v0.4.0
 	github.com/godbus/dbus v0.0.0-20190422162347-ade71ed3457e // indirect
 	github.com/gogo/protobuf v1.3.1 // indirect
@@ -19,6 +20,7 @@ require (
 	github.com/klauspost/pgzip v1.2.3 // indirect
 	github.com/mrunalp/fileutils v0.0.0-20171103030105-7d4729fb3618 // indirect
 	github.com/openSUSE/umoci v0.4.5
+	github.com/opencontainers/go-digest v1.0.0-rc1
 	github.com/opencontainers/image-spec v1.0.2-0.20190823105129-775207bd45b6
 	github.com/opencontainers/runc v1.0.0-rc9.0.20200514005706-3f1e88699199
 	github.com/opencontainers/runtime-spec v1.0.2
@@ -37,7 +39,9 @@ require (
 	github.com/syndtr/gocapability v0.0.0-20180916011248-d98352740cb2 // indirect
 	github.com/tidwall/gjson v1.6.0
 	github.com/urfave/cli/v2 v2.2.0
-	github.com/vishvananda/netlink v1.1.0 // indirect
+	github.com/vbatts/go-mtree v0.4.4
+	github.com/vishvananda/netlink v1.1.0
+	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df
 	github.com/xeipuuv/gojsonpointer v0.0.0-20190809123943-df4f5c81cb3b // indirect
 	github.com/ybbus/jsonrpc v1.1.2-0.20200212073916-a94e6ce5643c
 	go.etcd.io/bbolt v1.3.4
@@ -0,0 +1,37 @@
+package meta
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ImageConfigPath is where the image config of an unpacked rootfs is kept.
+func ImageConfigPath(rootfs string) string {
+	return rootfs + ".json"
+}
+
+// LoadImageConfig returns nil if the rootfs has no image config.
+func LoadImageConfig(rootfs string) (*ispec.ImageConfig, error) {
+	b, err := ioutil.ReadFile(ImageConfigPath(rootfs))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	res := &ispec.ImageConfig{}
+	return res, json.Unmarshal(b, res)
+}
+
+func SaveImageConfig(rootfs string, config *ispec.ImageConfig) error {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(ImageConfigPath(rootfs), b, 0644)
+}
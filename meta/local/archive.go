@@ -0,0 +1,352 @@
+package local
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/openSUSE/umoci/oci/cas/dir"
+	"github.com/openSUSE/umoci/oci/casext"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/xhebox/chrootd/utils"
+)
+
+// the manifest of docker save
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// dockerRef returns the tag of repository:tag, or latest.
+func dockerRef(tag string) string {
+	i := strings.LastIndex(tag, ":")
+	if i == -1 || strings.Contains(tag[i:], "/") {
+		return "latest"
+	}
+	return tag[i+1:]
+}
+
+// importOCI copies every image of the layout, images without a reference
+// name are tagged as latest.
+func importOCI(ctx context.Context, cext casext.Engine, path string) (map[string]ispec.Descriptor, error) {
+	ce, err := dir.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	src := casext.NewEngine(ce)
+	defer src.Close()
+
+	index, err := src.GetIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]ispec.Descriptor{}
+	for _, desc := range index.Manifests {
+		ref := desc.Annotations[ispec.AnnotationRefName]
+		if ref == "" {
+			ref = "latest"
+		}
+
+		err := copyBlobs(ctx, src, cext, desc)
+		if err != nil {
+			return nil, err
+		}
+
+		refs[ref] = desc
+	}
+
+	return refs, nil
+}
+
+func putFile(ctx context.Context, cext casext.Engine, root, path string) (digest.Digest, int64, bool, error) {
+	path, err := securejoin.SecureJoin(root, path)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+
+	magic, _ := rd.Peek(2)
+	gzip := len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+
+	d, size, err := cext.PutBlob(ctx, rd)
+	return d, size, gzip, err
+}
+
+// importDocker converts images of docker save into manifests, configs of
+// docker are compatible with oci.
+func importDocker(ctx context.Context, cext casext.Engine, path string) (map[string]ispec.Descriptor, error) {
+	b, err := ioutil.ReadFile(filepath.Join(path, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []dockerManifest
+	err = json.Unmarshal(b, &manifests)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid docker manifest")
+	}
+
+	refs := map[string]ispec.Descriptor{}
+	for _, dm := range manifests {
+		manifest := ispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+		}
+
+		d, size, _, err := putFile(ctx, cext, path, dm.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		manifest.Config = ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageConfig,
+			Digest:    d,
+			Size:      size,
+		}
+
+		for _, l := range dm.Layers {
+			d, size, gzip, err := putFile(ctx, cext, path, l)
+			if err != nil {
+				return nil, err
+			}
+
+			desc := ispec.Descriptor{
+				MediaType: ispec.MediaTypeImageLayer,
+				Digest:    d,
+				Size:      size,
+			}
+			if gzip {
+				desc.MediaType = ispec.MediaTypeImageLayerGzip
+			}
+
+			manifest.Layers = append(manifest.Layers, desc)
+		}
+
+		d, size, err = cext.PutBlobJSON(ctx, manifest)
+		if err != nil {
+			return nil, err
+		}
+
+		desc := ispec.Descriptor{
+			MediaType: ispec.MediaTypeImageManifest,
+			Digest:    d,
+			Size:      size,
+		}
+
+		if len(dm.RepoTags) == 0 {
+			refs["latest"] = desc
+		}
+
+		for _, tag := range dm.RepoTags {
+			refs[dockerRef(tag)] = desc
+		}
+	}
+
+	return refs, nil
+}
+
+func (m *MetaManager) ImageImport(ctx context.Context, node, name string, rd io.Reader) (err error) {
+	err = validImageName(name)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir(m.tmpPath, "import")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = utils.Untar(rd, tmp)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(m.imagePath, name)
+
+	cext, created, err := openImage(path)
+	if err != nil {
+		return err
+	}
+	defer cext.Close()
+
+	if created {
+		defer func() {
+			if err != nil {
+				os.RemoveAll(path)
+			}
+		}()
+	}
+
+	var refs map[string]ispec.Descriptor
+	switch {
+	case utils.PathExist(filepath.Join(tmp, ispec.ImageLayoutFile)):
+		refs, err = importOCI(ctx, cext, tmp)
+	case utils.PathExist(filepath.Join(tmp, "manifest.json")):
+		refs, err = importDocker(ctx, cext, tmp)
+	default:
+		err = errors.New("neither an oci archive nor a docker archive")
+	}
+	if err != nil {
+		return err
+	}
+
+	for ref, desc := range refs {
+		err = cext.UpdateReference(ctx, ref, desc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, rd io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, rd)
+	return err
+}
+
+func writeTarJSON(tw *tar.Writer, name string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return writeTarFile(tw, name, int64(len(b)), strings.NewReader(string(b)))
+}
+
+// exportImage writes an oci layout of manifests as a tar stream.
+func exportImage(ctx context.Context, cext casext.Engine, path string, manifests []ispec.Descriptor, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	err := writeTarJSON(tw, ispec.ImageLayoutFile, ispec.ImageLayout{Version: ispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+
+	err = writeTarJSON(tw, "index.json", ispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: manifests,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{"blobs/", "blobs/" + string(digest.SHA256) + "/"} {
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     name,
+			Mode:     0755,
+			ModTime:  time.Unix(0, 0),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	seen := map[digest.Digest]struct{}{}
+	for _, desc := range manifests {
+		digests, err := cext.Reachable(ctx, desc)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range digests {
+			if _, ok := seen[d]; ok {
+				continue
+			}
+			seen[d] = struct{}{}
+
+			name := filepath.Join("blobs", d.Algorithm().String(), d.Hex())
+
+			fi, err := os.Stat(filepath.Join(path, name))
+			if err != nil {
+				return err
+			}
+
+			rd, err := cext.GetBlob(ctx, d)
+			if err != nil {
+				return err
+			}
+
+			err = writeTarFile(tw, name, fi.Size(), rd)
+			rd.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+func (m *MetaManager) ImageExport(node, name, ref string) (io.ReadCloser, error) {
+	err := validImageName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(m.imagePath, name)
+
+	ce, err := dir.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	cext := casext.NewEngine(ce)
+
+	index, err := cext.GetIndex(context.Background())
+	if err != nil {
+		cext.Close()
+		return nil, err
+	}
+
+	manifests := []ispec.Descriptor{}
+	for _, desc := range index.Manifests {
+		if desc.Annotations[ispec.AnnotationRefName] == ref {
+			manifests = append(manifests, desc)
+		}
+	}
+
+	if len(manifests) == 0 {
+		cext.Close()
+		return nil, errors.Errorf("non-exist reference %s", ref)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer cext.Close()
+		pw.CloseWithError(exportImage(context.Background(), cext, path, manifests, pw))
+	}()
+
+	return pr, nil
+}
@@ -0,0 +1,135 @@
+package local
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	. "github.com/xhebox/chrootd/meta"
+)
+
+func TestDockerRef(t *testing.T) {
+	for tag, ref := range map[string]string{
+		"busybox":                "latest",
+		"busybox:1.31":           "1.31",
+		"localhost:5000/busybox": "latest",
+		"localhost:5000/busy:v1": "v1",
+	} {
+		if r := dockerRef(tag); r != ref {
+			t.Fatalf("expect %s for %s, got %s", ref, tag, r)
+		}
+	}
+}
+
+func tarFiles(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, b := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(b)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = tw.Write(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestImageImportDocker(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	m := mgr.Manager.(*MetaManager)
+
+	layer := tarFiles(t, map[string][]byte{"hello": []byte("world")})
+
+	config, err := json.Marshal(ispec.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		Config: ispec.ImageConfig{
+			Cmd: []string{"/hello"},
+		},
+		RootFS: ispec.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{digest.FromBytes(layer)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := json.Marshal([]dockerManifest{
+		{
+			Config:   "config.json",
+			RepoTags: []string{"test:v1"},
+			Layers:   []string{"abc/layer.tar"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := tarFiles(t, map[string][]byte{
+		"manifest.json": manifest,
+		"config.json":   config,
+		"abc/layer.tar": layer,
+	})
+
+	err = m.ImageImport(context.Background(), "", "docker", bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := m.Create(&Metainfo{
+		Name:           "test",
+		Image:          "docker",
+		ImageReference: "v1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := m.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(m.rootfsPath, rid)
+
+	b, err := ioutil.ReadFile(filepath.Join(root, "hello"))
+	if err != nil || string(b) != "world" {
+		t.Fatalf("unexpected content %q: %v", b, err)
+	}
+
+	cfg, err := LoadImageConfig(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.Cmd) != 1 || cfg.Cmd[0] != "/hello" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
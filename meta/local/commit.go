@@ -0,0 +1,368 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/openSUSE/umoci/mutate"
+	"github.com/openSUSE/umoci/oci/cas"
+	"github.com/openSUSE/umoci/oci/cas/dir"
+	"github.com/openSUSE/umoci/oci/casext"
+	"github.com/openSUSE/umoci/oci/layer"
+	"github.com/openSUSE/umoci/pkg/fseval"
+	"github.com/openSUSE/umoci/pkg/mtreefilter"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/vbatts/go-mtree"
+	. "github.com/xhebox/chrootd/meta"
+	"github.com/xhebox/chrootd/utils"
+)
+
+// same as umoci
+var mtreeKeywords = []mtree.Keyword{
+	"size",
+	"type",
+	"uid",
+	"gid",
+	"mode",
+	"link",
+	"nlink",
+	"tar_time",
+	"sha256digest",
+	"xattr",
+}
+
+func mtreePath(rootfs string) string {
+	return rootfs + ".mtree"
+}
+
+func originPath(rootfs string) string {
+	return rootfs + ".origin"
+}
+
+// removeRootfsFiles removes files kept alongside the rootfs, trees are
+// removed with the mappings of the rootfs.
+func (m *MetaManager) removeRootfsFiles(rootfs string, opt *layer.MapOptions) error {
+	q, err := LoadQuota(rootfs)
+	if err != nil {
+		return err
+	}
+
+	if q != nil {
+		err := q.Clear()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range []string{ImageConfigPath(rootfs), mtreePath(rootfs), originPath(rootfs), lowerPath(rootfs), QuotaPath(rootfs), diskImagePath(rootfs)} {
+		err := os.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for _, p := range []string{upperPath(rootfs), workPath(rootfs), diskPath(rootfs)} {
+		err := m.removeTree(p, opt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MetaManager) mapOptions(meta *Metainfo) *layer.MapOptions {
+	return &layer.MapOptions{
+		Rootless: m.Rootless,
+		UIDMappings: []rspec.LinuxIDMapping{
+			rspec.LinuxIDMapping{
+				ContainerID: 0,
+				HostID:      uint32(os.Geteuid()),
+				Size:        meta.UidMapSize,
+			},
+		},
+		GIDMappings: []rspec.LinuxIDMapping{
+			rspec.LinuxIDMapping{
+				ContainerID: 0,
+				HostID:      uint32(os.Getegid()),
+				Size:        meta.GidMapSize,
+			},
+		},
+	}
+}
+
+func (m *MetaManager) fsEval() fseval.FsEval {
+	if m.Rootless {
+		return fseval.RootlessFsEval
+	}
+	return fseval.DefaultFsEval
+}
+
+// saveOrigin records the manifest and the state of an unpacked rootfs, which
+// is diffed against by commits. the state is the same for rootfs of the same
+// manifest and mappings, and only walked once.
+func (m *MetaManager) saveOrigin(rootfs string, from casext.DescriptorPath, opt *layer.MapOptions) error {
+	cache := filepath.Join(m.layerDir(opt), from.Descriptor().Digest.Hex()+".mtree")
+
+	if !utils.PathExist(cache) {
+		err := m.walkRootfs(rootfs, cache)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := os.Link(cache, mtreePath(rootfs))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(originPath(rootfs), b, 0644)
+}
+
+func (m *MetaManager) walkRootfs(rootfs, path string) error {
+	dh, err := mtree.Walk(rootfs, nil, mtreeKeywords, m.fsEval())
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(m.tmpPath, "mtree")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = dh.WriteTo(f)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func (m *MetaManager) loadOrigin(rootfs string) (*mtree.DirectoryHierarchy, casext.DescriptorPath, error) {
+	var from casext.DescriptorPath
+
+	b, err := ioutil.ReadFile(originPath(rootfs))
+	if err != nil {
+		return nil, from, errors.Wrap(err, "the origin of rootfs is unknown")
+	}
+
+	err = json.Unmarshal(b, &from)
+	if err != nil {
+		return nil, from, err
+	}
+
+	f, err := os.Open(mtreePath(rootfs))
+	if err != nil {
+		return nil, from, err
+	}
+	defer f.Close()
+
+	dh, err := mtree.ParseSpec(f)
+	return dh, from, err
+}
+
+// copyImage copies blobs reachable from root into another image, which is
+// created if not exist.
+func copyImage(ctx context.Context, src casext.Engine, dst string, root ispec.Descriptor) error {
+	if !utils.PathExist(dst) {
+		err := dir.Create(dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	ce, err := dir.Open(dst)
+	if err != nil {
+		return err
+	}
+	defer ce.Close()
+
+	return copyBlobs(ctx, src, ce, root)
+}
+
+// openImage opens an image, which is created if not exist. created images
+// should be removed on failures.
+func openImage(path string) (cext casext.Engine, created bool, err error) {
+	if !utils.PathExist(path) {
+		err = dir.Create(path)
+		if err != nil {
+			return cext, false, err
+		}
+		created = true
+	}
+
+	ce, err := dir.Open(path)
+	if err != nil {
+		if created {
+			os.RemoveAll(path)
+		}
+		return cext, false, err
+	}
+
+	return casext.NewEngine(ce), created, nil
+}
+
+// copyBlobs copies blobs reachable from root, and verifies their digests.
+func copyBlobs(ctx context.Context, src casext.Engine, dst cas.Engine, root ispec.Descriptor) error {
+	digests, err := src.Reachable(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range digests {
+		rd, err := src.GetBlob(ctx, d)
+		if err != nil {
+			return err
+		}
+
+		nd, _, err := dst.PutBlob(ctx, rd)
+		rd.Close()
+		if err != nil {
+			return err
+		}
+
+		if nd != d {
+			return errors.Errorf("digest mismatch of blob %s", d)
+		}
+	}
+
+	return nil
+}
+
+func validImageName(image string) error {
+	if image == "" || image != filepath.Base(image) || image == "." || image == ".." {
+		return errors.Errorf("invalid image name %s", image)
+	}
+	return nil
+}
+
+func (m *MetaManager) ImageCommit(ctx context.Context, metaid, rootid, image, ref string) error {
+	_, meta, err := m.getMeta(metaid)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, id := range meta.RootfsIds {
+		if id == rootid {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("wrong rootfs id")
+	}
+
+	if image == "" {
+		image = meta.Image
+	}
+
+	err = validImageName(image)
+	if err != nil {
+		return err
+	}
+
+	if !casext.IsValidReferenceName(ref) {
+		return errors.Errorf("invalid reference %s", ref)
+	}
+
+	path := filepath.Join(m.rootfsPath, rootid)
+
+	spec, from, err := m.loadOrigin(path)
+	if err != nil {
+		return err
+	}
+
+	diffs, err := mtree.Check(path, spec, mtreeKeywords, m.fsEval())
+	if err != nil {
+		return err
+	}
+	diffs = mtreefilter.FilterDeltas(diffs, mtreefilter.SimplifyFilter(diffs))
+
+	if image != meta.Image {
+		src, err := dir.Open(filepath.Join(m.imagePath, meta.Image))
+		if err != nil {
+			return err
+		}
+
+		err = copyImage(ctx, casext.NewEngine(src), filepath.Join(m.imagePath, image), from.Root())
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	ce, err := dir.Open(filepath.Join(m.imagePath, image))
+	if err != nil {
+		return err
+	}
+	cext := casext.NewEngine(ce)
+	defer cext.Close()
+
+	mutator, err := mutate.New(cext, from)
+	if err != nil {
+		return err
+	}
+
+	history := &ispec.History{
+		Created:   timePtr(time.Now()),
+		CreatedBy: "chrootd commit",
+	}
+
+	if len(diffs) == 0 {
+		config, err := mutator.Config(ctx)
+		if err != nil {
+			return err
+		}
+
+		imeta, err := mutator.Meta(ctx)
+		if err != nil {
+			return err
+		}
+
+		annotations, err := mutator.Annotations(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = mutator.Set(ctx, config, imeta, annotations, history)
+		if err != nil {
+			return err
+		}
+	} else {
+		rd, err := layer.GenerateLayer(path, diffs, m.mapOptions(meta))
+		if err != nil {
+			return err
+		}
+		defer rd.Close()
+
+		err = mutator.Add(ctx, rd, history)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := mutator.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return cext.UpdateReference(ctx, ref, res.Root())
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,67 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/xhebox/chrootd/meta"
+	"github.com/xhebox/chrootd/utils"
+)
+
+// rootfsUsage counts the changes of the rootfs, rather than the mounted
+// tree of shared layers.
+func rootfsUsage(rootfs string) int64 {
+	paths := []string{ImageConfigPath(rootfs), mtreePath(rootfs), originPath(rootfs), lowerPath(rootfs), QuotaPath(rootfs)}
+	switch {
+	case utils.PathExist(diskImagePath(rootfs)):
+		paths = append(paths, diskImagePath(rootfs))
+	case utils.PathExist(lowerPath(rootfs)):
+		paths = append(paths, upperPath(rootfs), workPath(rootfs))
+	default:
+		paths = append(paths, rootfs)
+	}
+	return utils.DiskUsage(paths...)
+}
+
+func (m *MetaManager) DiskUsage(ctx context.Context, f func(*DiskUsage) error) error {
+	err := m.Query("", func(meta *Metainfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var total int64
+		for _, id := range meta.RootfsIds {
+			size := rootfsUsage(filepath.Join(m.rootfsPath, id))
+			total += size
+
+			err := f(&DiskUsage{Node: m.id, Kind: UsageRootfs, Name: id, Size: size})
+			if err != nil {
+				return err
+			}
+		}
+
+		return f(&DiskUsage{Node: m.id, Kind: UsageMeta, Name: meta.Id, Size: total})
+	})
+	if err != nil {
+		return err
+	}
+
+	fis, err := ioutil.ReadDir(m.imagePath)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range fis {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := f(&DiskUsage{Node: m.id, Kind: UsageImage, Name: fi.Name(), Size: utils.DiskUsage(filepath.Join(m.imagePath, fi.Name()))})
+		if err != nil {
+			return err
+		}
+	}
+
+	return f(&DiskUsage{Node: m.id, Kind: UsageLayers, Name: filepath.Base(m.layerPath), Size: utils.DiskUsage(m.layerPath)})
+}
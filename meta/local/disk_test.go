@@ -0,0 +1,74 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/xhebox/chrootd/meta"
+)
+
+func TestImageDeleteReclaim(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	m := mgr.Manager.(*MetaManager)
+
+	id, err := m.Create(&Metainfo{
+		Name:           "test",
+		Image:          "alpine",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := m.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootfs := filepath.Join(m.rootfsPath, rid)
+
+	before := rootfsUsage(rootfs)
+
+	// like files left by rootless containers
+	dir := filepath.Join(rootfs, "ro")
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "data"), make([]byte, 1<<20), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chmod(dir, 0555)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after := rootfsUsage(rootfs); after < before+1<<20 {
+		t.Fatalf("expect changes to be counted, got %d before and %d after", before, after)
+	}
+
+	err = m.ImageDelete(id, rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fis, err := ioutil.ReadDir(m.rootfsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fi := range fis {
+		t.Errorf("expect %s to be removed", fi.Name())
+	}
+}
@@ -0,0 +1,247 @@
+package local
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/openSUSE/umoci/oci/layer"
+	. "github.com/xhebox/chrootd/meta"
+)
+
+func (m *MetaManager) gc() {
+	defer m.wg.Done()
+
+	tick := time.NewTicker(m.GCInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-tick.C:
+			m.ImagePrune(m.GCGrace)
+		}
+	}
+}
+
+// rootfsOwners maps rootfs to their metadata.
+func (m *MetaManager) rootfsOwners() (map[string]string, error) {
+	res := map[string]string{}
+	return res, m.Query("", func(meta *Metainfo) error {
+		for _, id := range meta.RootfsIds {
+			res[id] = meta.Id
+		}
+		return nil
+	})
+}
+
+// orphanMapOptions maps ids of files of the orphan, the metadata is gone.
+func (m *MetaManager) orphanMapOptions(names []string) *layer.MapOptions {
+	meta := &Metainfo{UidMapSize: 1, GidMapSize: 1}
+	uid, gid := uint32(os.Geteuid()), uint32(os.Getegid())
+	for _, name := range names {
+		filepath.Walk(filepath.Join(m.rootfsPath, name), func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				if st.Uid >= uid && st.Uid-uid >= meta.UidMapSize {
+					meta.UidMapSize = st.Uid - uid + 1
+				}
+				if st.Gid >= gid && st.Gid-gid >= meta.GidMapSize {
+					meta.GidMapSize = st.Gid - gid + 1
+				}
+			}
+			return nil
+		})
+	}
+	return m.mapOptions(meta)
+}
+
+// pruneLayers removes layers and states of manifests in the cache that no
+// rootfs is built from. rootfs being unpacked use layers before lowers are
+// recorded, it is skipped meanwhile.
+func (m *MetaManager) pruneLayers() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.unpacking) > 0 {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(m.rootfsPath, "*.lower"))
+	if err != nil {
+		return err
+	}
+
+	used := map[string]bool{}
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		var lowers []string
+		err = json.Unmarshal(b, &lowers)
+		if err != nil {
+			return err
+		}
+
+		for _, lower := range lowers {
+			used[lower] = true
+		}
+	}
+
+	caches, err := ioutil.ReadDir(m.layerPath)
+	if err != nil {
+		return err
+	}
+
+	for _, cache := range caches {
+		opt, err := layerMapOptions(cache.Name())
+		if err != nil {
+			continue
+		}
+
+		dir := filepath.Join(m.layerPath, cache.Name())
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, fi := range fis {
+			p := filepath.Join(dir, fi.Name())
+			switch {
+			case strings.HasSuffix(fi.Name(), ".mtree"):
+				// states are linked by rootfs
+				if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 {
+					continue
+				}
+				err = os.Remove(p)
+			case used[p]:
+				continue
+			default:
+				err = m.removeTree(p, opt)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// caches of other mappings are gone with their rootfs
+		if fis, err := ioutil.ReadDir(dir); err == nil && len(fis) == 0 {
+			err = os.Remove(dir)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ImagePrune removes rootfs that containers have used but no longer use for
+// grace, directories of rootfs that no metadata knows, and then layers that
+// no rootfs is built from.
+func (m *MetaManager) ImagePrune(grace time.Duration) ([]string, error) {
+	fis, err := ioutil.ReadDir(m.rootfsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// rootfs being unpacked are known once unpacked
+	m.mu.Lock()
+	entries := map[string][]string{}
+	for _, fi := range fis {
+		id := strings.SplitN(fi.Name(), ".", 2)[0]
+		if _, ok := m.unpacking[id]; !ok {
+			entries[id] = append(entries[id], fi.Name())
+		}
+	}
+	m.mu.Unlock()
+
+	owners, err := m.rootfsOwners()
+	if err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+	for id, names := range entries {
+		if _, ok := owners[id]; ok {
+			continue
+		}
+
+		rootfs := filepath.Join(m.rootfsPath, id)
+
+		err := m.unmountRootfs(rootfs)
+		if err != nil {
+			return res, err
+		}
+
+		// quotas and disks are released with files of the rootfs
+		opt := m.orphanMapOptions(names)
+
+		err = m.removeRootfsFiles(rootfs, opt)
+		if err != nil {
+			return res, err
+		}
+
+		for _, name := range names {
+			err := m.removeTree(filepath.Join(m.rootfsPath, name), opt)
+			if err != nil {
+				return res, err
+			}
+		}
+
+		err = m.refs.Release(id)
+		if err != nil {
+			return res, err
+		}
+
+		res = append(res, id)
+	}
+
+	for id, mid := range owners {
+		cntrs, t, err := m.refs.Users(id)
+		if err != nil {
+			return res, err
+		}
+
+		if len(cntrs) > 0 {
+			continue
+		}
+
+		// rootfs unpacked by older daemons
+		if t.IsZero() {
+			err := m.refs.Touch(id)
+			if err != nil {
+				return res, err
+			}
+			continue
+		}
+
+		// rootfs are kept until containers have used them
+		if time.Since(t) < grace || !m.refs.Used(id) {
+			continue
+		}
+
+		err = m.ImageDelete(mid, id)
+		if err != nil {
+			// containers may be created after the check
+			if cntrs, _, _ := m.refs.Users(id); len(cntrs) > 0 {
+				continue
+			}
+			return res, err
+		}
+
+		res = append(res, id)
+	}
+
+	return res, m.pruneLayers()
+}
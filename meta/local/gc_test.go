@@ -0,0 +1,187 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+	. "github.com/xhebox/chrootd/meta"
+)
+
+func TestImagePruneOrphans(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	m := mgr.Manager.(*MetaManager)
+
+	id, err := m.Create(&Metainfo{
+		Name:           "test",
+		Image:          "alpine",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := m.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// leftovers of an unpack without metadata
+	orphan := filepath.Join(m.rootfsPath, ksuid.New().String())
+	for _, dir := range []string{orphan, upperPath(orphan)} {
+		err := os.MkdirAll(filepath.Join(dir, "etc"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := m.ImagePrune(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 1 || ids[0] != filepath.Base(orphan) {
+		t.Fatalf("expect the orphan to be pruned, got %v", ids)
+	}
+
+	for _, dir := range []string{orphan, upperPath(orphan)} {
+		if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+			t.Fatalf("expect %s to be removed: %v", dir, err)
+		}
+	}
+
+	if _, err := os.Lstat(filepath.Join(m.rootfsPath, rid, "etc")); err != nil {
+		t.Fatalf("expect rootfs in the grace period to be kept: %v", err)
+	}
+}
+
+func TestImagePruneLayers(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	m := mgr.Manager.(*MetaManager)
+
+	id, err := m.Create(&Metainfo{
+		Name:           "test",
+		Image:          "alpine",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := m.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lowers, err := filepath.Glob(filepath.Join(m.layerPath, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// layers of images that are gone
+	unused := filepath.Join(filepath.Dir(lowers[0]), "unused")
+	err = os.MkdirAll(filepath.Join(unused, "etc"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.ImagePrune(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Lstat(unused); !os.IsNotExist(err) {
+		t.Fatalf("expect unused layers to be removed: %v", err)
+	}
+
+	for _, p := range lowers {
+		if _, err := os.Lstat(p); err != nil {
+			t.Fatalf("expect layers in use to be kept: %v", err)
+		}
+	}
+
+	err = m.ImageDelete(id, rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.ImagePrune(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fis, err := ioutil.ReadDir(m.layerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fi := range fis {
+		t.Errorf("expect layers of %s to be removed", fi.Name())
+	}
+}
+
+func TestImagePruneUsed(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	m := mgr.Manager.(*MetaManager)
+
+	id, err := m.Create(&Metainfo{
+		Name:           "test",
+		Image:          "alpine",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := m.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.refs.Add(rid, "cntr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := m.ImagePrune(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 0 {
+		t.Fatalf("expect rootfs in use to be kept, got %v", ids)
+	}
+
+	err = m.refs.Remove(rid, "cntr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err = m.ImagePrune(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 1 || ids[0] != rid {
+		t.Fatalf("expect %s to be pruned, got %v", rid, ids)
+	}
+}
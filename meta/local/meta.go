@@ -8,12 +8,12 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/openSUSE/umoci/oci/cas/dir"
 	"github.com/openSUSE/umoci/oci/casext"
-	"github.com/openSUSE/umoci/oci/layer"
 	ispec "github.com/opencontainers/image-spec/specs-go/v1"
-	rspec "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/pkg/errors"
 	"github.com/segmentio/ksuid"
 	"github.com/tidwall/gjson"
@@ -24,22 +24,39 @@ import (
 
 type MetaManager struct {
 	id         string
-	runPath    string
 	imagePath  string
 	rootfsPath string
+	tmpPath    string
+	layerPath  string
+	node       store.Store
 	metas      store.Store
+	refs       *RootfsRefs
+	unpacking  map[string]struct{}
+	mu         sync.Mutex
+	done       chan struct{}
+	wg         sync.WaitGroup
 
 	Rootless     bool
 	DefaultImage string
+	// rootfs that containers no longer use for GCGrace are removed every
+	// GCInterval, zero interval disables the collection. rootfs never used
+	// by containers are kept.
+	GCInterval time.Duration
+	GCGrace    time.Duration
 }
 
 func NewMetaManager(path, image string, s store.Store, opts ...func(*MetaManager) error) (Manager, error) {
 	mgr := &MetaManager{
 		imagePath:    image,
-		runPath:      filepath.Join(path, "meta"),
+		node:         s,
 		rootfsPath:   filepath.Join(path, "rootfs"),
+		tmpPath:      filepath.Join(path, "tmp"),
+		unpacking:    map[string]struct{}{},
+		done:         make(chan struct{}),
 		Rootless:     true,
 		DefaultImage: "alpine",
+		GCInterval:   10 * time.Minute,
+		GCGrace:      time.Hour,
 	}
 
 	for k := range opts {
@@ -53,12 +70,19 @@ func NewMetaManager(path, image string, s store.Store, opts ...func(*MetaManager
 		return nil, errors.Errorf("image path does not exist or no permission: %s", mgr.imagePath)
 	}
 
-	err := os.MkdirAll(mgr.runPath, 0755)
+	err := os.MkdirAll(mgr.rootfsPath, 0755)
 	if err != nil {
 		return nil, err
 	}
 
-	err = os.MkdirAll(mgr.rootfsPath, 0755)
+	err = os.MkdirAll(mgr.tmpPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr.layerPath = filepath.Join(path, "layers", mgr.overlayDriver())
+
+	err = os.MkdirAll(mgr.layerPath, 0755)
 	if err != nil {
 		return nil, err
 	}
@@ -75,6 +99,39 @@ func NewMetaManager(path, image string, s store.Store, opts ...func(*MetaManager
 	}
 	mgr.metas = mgrmetas
 
+	mgr.refs, err = NewRootfsRefs(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// mounts are gone after reboots, and rootfs unpacked by older daemons
+	// are not tracked
+	err = mgr.Query("", func(meta *Metainfo) error {
+		for _, id := range meta.RootfsIds {
+			err := mgr.remountRootfs(filepath.Join(mgr.rootfsPath, id))
+			if err != nil {
+				return errors.Wrapf(err, "can not mount rootfs %s", id)
+			}
+
+			_, t, err := mgr.refs.Users(id)
+			if err == nil && t.IsZero() {
+				err = mgr.refs.Touch(id)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if mgr.GCInterval > 0 {
+		mgr.wg.Add(1)
+		go mgr.gc()
+	}
+
 	return mgr, nil
 }
 
@@ -235,41 +292,75 @@ func (m *MetaManager) ImageUnpack(ctx context.Context, metaid string) (string, e
 		return "", errors.Errorf("should be here, internal corruption")
 	}
 
+	configBlob, err := cext.FromDescriptor(ctx, manifest.Config)
+	if err != nil {
+		return "", err
+	}
+	defer configBlob.Close()
+
+	config, ok := configBlob.Data.(ispec.Image)
+	if !ok {
+		return "", errors.Errorf("except an image config: %s", configBlob.Descriptor.MediaType)
+	}
+
 	id := ksuid.New().String()
 
+	m.mu.Lock()
+	m.unpacking[id] = struct{}{}
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.unpacking, id)
+		m.mu.Unlock()
+	}()
+
 	path := filepath.Join(m.rootfsPath, id)
 
 	defer func() {
 		if err != nil {
-			os.RemoveAll(path)
+			m.unmountRootfs(path)
+			m.removeTree(path, m.mapOptions(meta))
+			m.removeRootfsFiles(path, m.mapOptions(meta))
 		}
 	}()
 
-	opt := &layer.MapOptions{
-		Rootless: m.Rootless,
-		UIDMappings: []rspec.LinuxIDMapping{
-			rspec.LinuxIDMapping{
-				ContainerID: 0,
-				HostID:      uint32(os.Geteuid()),
-				Size:        meta.UidMapSize,
-			},
-		},
-		GIDMappings: []rspec.LinuxIDMapping{
-			rspec.LinuxIDMapping{
-				ContainerID: 0,
-				HostID:      uint32(os.Getegid()),
-				Size:        meta.GidMapSize,
-			},
-		},
-	}
-	err = layer.UnpackRootfs(ctx, cext.Engine, path, manifest, opt)
+	// layers are shared by rootfs, and changes go to the upper directory
+	lowers, err := m.unpackLayers(ctx, cext, manifest, m.mapOptions(meta))
+	if err != nil {
+		return "", err
+	}
+
+	err = m.mountRootfs(path, lowers, meta.DiskQuota)
+	if err != nil {
+		return "", err
+	}
+
+	err = SaveImageConfig(path, &config.Config)
+	if err != nil {
+		return "", err
+	}
+
+	// the origin is recorded for commits
+	err = m.saveOrigin(path, desc[0], m.mapOptions(meta))
+	if err != nil {
+		return "", err
+	}
+
+	err = m.refs.Touch(id)
 	if err != nil {
 		return "", err
 	}
 
 	meta.RootfsIds = append(meta.RootfsIds, id)
 
-	return id, m.putMeta(idx, metaid, meta)
+	err = m.putMeta(idx, metaid, meta)
+	if err != nil {
+		m.refs.Release(id)
+		return "", err
+	}
+
+	return id, nil
 }
 
 func (m *MetaManager) ImageDelete(metaid, rootid string) error {
@@ -286,12 +377,29 @@ func (m *MetaManager) ImageDelete(metaid, rootid string) error {
 		}
 	}
 	if i >= 0 {
+		err := m.refs.Release(rootid)
+		if err != nil {
+			return err
+		}
+
 		if i < len(meta.RootfsIds) {
 			copy(meta.RootfsIds[i:], meta.RootfsIds[i+1:])
 		}
 		meta.RootfsIds = meta.RootfsIds[:len(meta.RootfsIds)-1]
 
-		err := os.RemoveAll(filepath.Join(m.runPath, rootid))
+		rootfs := filepath.Join(m.rootfsPath, rootid)
+
+		err = m.unmountRootfs(rootfs)
+		if err != nil {
+			return err
+		}
+
+		err = m.removeTree(rootfs, m.mapOptions(meta))
+		if err != nil {
+			return err
+		}
+
+		err = m.removeRootfsFiles(rootfs, m.mapOptions(meta))
 		if err != nil {
 			return err
 		}
@@ -352,6 +460,9 @@ func (m *MetaManager) ImageAvailable(ctx context.Context, f func(string, string,
 	return nil
 }
 
+// rootfs are left mounted for containers
 func (m *MetaManager) Close() error {
+	close(m.done)
+	m.wg.Wait()
 	return nil
 }
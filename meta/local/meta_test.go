@@ -1,6 +1,7 @@
 package local
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	. "github.com/xhebox/chrootd/meta"
 	mtest "github.com/xhebox/chrootd/meta/test"
 	"github.com/xhebox/chrootd/store"
+	"github.com/xhebox/chrootd/utils"
 )
 
 type TestMetaManager struct {
@@ -37,6 +39,11 @@ func NewTestMetaManager() (*TestMetaManager, error) {
 		return nil, err
 	}
 
+	image, err = mtest.TempImages(dir, image)
+	if err != nil {
+		return nil, err
+	}
+
 	mgr, err := NewMetaManager(dir, image, s)
 	if err != nil {
 		return nil, err
@@ -47,6 +54,7 @@ func NewTestMetaManager() (*TestMetaManager, error) {
 
 func (t *TestMetaManager) Close() error {
 	t.Manager.Close()
+	mtest.Unmount(t.dir)
 	return os.RemoveAll(t.dir)
 }
 
@@ -130,6 +138,26 @@ func TestMetaManagerImageDelete(t *testing.T) {
 	mtest.TestMetaManagerImageDelete(mgr, t)
 }
 
+func TestMetaManagerImagePrune(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerImagePrune(mgr, t)
+}
+
+func TestMetaManagerDiskUsage(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerDiskUsage(mgr, t)
+}
+
 func TestMetaManagerImageList(t *testing.T) {
 	mgr, err := NewTestMetaManager()
 	if err != nil {
@@ -149,3 +177,114 @@ func TestMetaManagerImageAvailable(t *testing.T) {
 
 	mtest.TestMetaManagerImageAvailable(mgr, t)
 }
+
+func TestMetaManagerImageCommit(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerImageCommit(mgr, t)
+}
+
+func TestMetaManagerImageImportExport(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerImageImportExport(mgr, t)
+}
+
+func TestMetaManagerImagePull(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	image, err := filepath.Abs("../../images")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtest.TestMetaManagerImagePull(mgr, image, t)
+}
+
+func TestImageCommitChanges(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	m := mgr.Manager.(*MetaManager)
+
+	id, err := m.Create(&Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := m.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(m.rootfsPath, rid)
+
+	err = ioutil.WriteFile(filepath.Join(root, "hello"), []byte("world"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Remove(filepath.Join(root, "etc/group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.ImageCommit(context.Background(), id, rid, "commit", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nid, err := m.Create(&Metainfo{
+		Name:           "test",
+		Image:          "commit",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nrid, err := m.ImageUnpack(context.Background(), nid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nroot := filepath.Join(m.rootfsPath, nrid)
+
+	b, err := ioutil.ReadFile(filepath.Join(nroot, "hello"))
+	if err != nil || string(b) != "world" {
+		t.Fatalf("unexpected content %q: %v", b, err)
+	}
+
+	if utils.PathExist(filepath.Join(nroot, "etc/group")) {
+		t.Fatal("expect removed files to be removed")
+	}
+
+	// the config is kept
+	config, err := LoadImageConfig(nroot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Cmd) != 1 || config.Cmd[0] != "/bin/sh" {
+		t.Fatalf("unexpected config %+v", config)
+	}
+}
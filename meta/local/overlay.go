@@ -0,0 +1,428 @@
+package local
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/openSUSE/umoci/oci/casext"
+	"github.com/openSUSE/umoci/oci/layer"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/xhebox/chrootd/utils"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// writable layers limited by loopback filesystems are in the filesystem
+func upperPath(rootfs string) string {
+	if utils.PathExist(diskPath(rootfs)) {
+		return filepath.Join(diskPath(rootfs), "upper")
+	}
+	return rootfs + ".upper"
+}
+
+func workPath(rootfs string) string {
+	if utils.PathExist(diskPath(rootfs)) {
+		return filepath.Join(diskPath(rootfs), "work")
+	}
+	return rootfs + ".work"
+}
+
+func lowerPath(rootfs string) string {
+	return rootfs + ".lower"
+}
+
+// overlayDriver is also the name of the layer cache, whiteouts of layers
+// are stored in the format of the driver.
+func (m *MetaManager) overlayDriver() string {
+	if m.Rootless && os.Geteuid() != 0 {
+		return "fuse-overlayfs"
+	}
+	return "overlay"
+}
+
+// layerDir is the cache of layers extracted with the mappings, owners of
+// files differ between mappings.
+func (m *MetaManager) layerDir(opt *layer.MapOptions) string {
+	var b strings.Builder
+	if opt.Rootless {
+		b.WriteString("rootless")
+	} else {
+		b.WriteString("root")
+	}
+	for _, maps := range [][]rspec.LinuxIDMapping{opt.UIDMappings, opt.GIDMappings} {
+		b.WriteString("_")
+		for i, id := range maps {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "%d-%d-%d", id.ContainerID, id.HostID, id.Size)
+		}
+	}
+	return filepath.Join(m.layerPath, b.String())
+}
+
+// layerMapOptions parses the mappings from the name of a layer cache.
+func layerMapOptions(name string) (*layer.MapOptions, error) {
+	parts := strings.Split(name, "_")
+	if len(parts) != 3 {
+		return nil, errors.Errorf("invalid layer cache %s", name)
+	}
+
+	opt := &layer.MapOptions{Rootless: parts[0] == "rootless"}
+	for i, maps := range []*[]rspec.LinuxIDMapping{&opt.UIDMappings, &opt.GIDMappings} {
+		if parts[i+1] == "" {
+			continue
+		}
+
+		for _, v := range strings.Split(parts[i+1], ",") {
+			id := rspec.LinuxIDMapping{}
+			_, err := fmt.Sscanf(v, "%d-%d-%d", &id.ContainerID, &id.HostID, &id.Size)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid layer cache %s", name)
+			}
+			*maps = append(*maps, id)
+		}
+	}
+	return opt, nil
+}
+
+// unpackLayers unpacks layers of the manifest into the cache if not exist,
+// and returns their directories from the top.
+func (m *MetaManager) unpackLayers(ctx context.Context, cext casext.Engine, manifest ispec.Manifest, opt *layer.MapOptions) ([]string, error) {
+	if len(manifest.Layers) == 0 {
+		return nil, errors.New("image has no layers")
+	}
+
+	cache := m.layerDir(opt)
+	err := os.MkdirAll(cache, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	lowers := []string{}
+	for _, desc := range manifest.Layers {
+		err := desc.Digest.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		dir := filepath.Join(cache, desc.Digest.Hex())
+		if !utils.PathExist(dir) {
+			err = m.unpackLayer(ctx, cext, desc, dir, lowers, opt)
+			if err != nil {
+				return nil, errors.Wrapf(err, "can not unpack layer %s", desc.Digest)
+			}
+		}
+
+		lowers = append([]string{dir}, lowers...)
+	}
+
+	return lowers, nil
+}
+
+func (m *MetaManager) unpackLayer(ctx context.Context, cext casext.Engine, desc ispec.Descriptor, dir string, lowers []string, opt *layer.MapOptions) error {
+	blob, err := cext.GetVerifiedBlob(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	var rd io.Reader = blob
+	switch desc.MediaType {
+	case ispec.MediaTypeImageLayer, ispec.MediaTypeImageLayerNonDistributable:
+	case ispec.MediaTypeImageLayerGzip, ispec.MediaTypeImageLayerNonDistributableGzip:
+		gz, err := gzip.NewReader(blob)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		rd = gz
+	default:
+		return errors.Errorf("unknown layer %s", desc.MediaType)
+	}
+
+	tmp, err := ioutil.TempDir(m.tmpPath, "layer")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = os.Chmod(tmp, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = m.extractLayer(tmp, rd, lowers, opt)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, dir)
+	if err != nil && utils.PathExist(dir) {
+		// unpacked by others meanwhile
+		return nil
+	}
+	return err
+}
+
+// findLower finds the path in lower layers.
+func findLower(lowers []string, path string) (string, os.FileInfo) {
+	for _, lower := range lowers {
+		p, err := securejoin.SecureJoin(lower, path)
+		if err != nil {
+			continue
+		}
+
+		if fi, err := os.Lstat(p); err == nil {
+			return p, fi
+		}
+	}
+	return "", nil
+}
+
+// extractLayer extracts a layer alone, whiteouts are kept in the format of
+// the driver instead of being applied.
+func (m *MetaManager) extractLayer(root string, rd io.Reader, lowers []string, opt *layer.MapOptions) error {
+	te := layer.NewTarExtractor(*opt)
+	tr := tar.NewReader(rd)
+
+	entries := map[string]struct{}{}
+	whiteouts := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := layer.CleanPath(hdr.Name)
+		if strings.HasPrefix(filepath.Base(name), whiteoutPrefix) {
+			whiteouts = append(whiteouts, name)
+			continue
+		}
+
+		// hard links may refer to files of lower layers
+		if hdr.Typeflag == tar.TypeLink {
+			target := layer.CleanPath(hdr.Linkname)
+			if _, ok := entries[target]; !ok {
+				p, fi := findLower(lowers, target)
+				if fi == nil || !fi.Mode().IsRegular() {
+					return errors.Errorf("can not find the target of hard link %s", name)
+				}
+
+				f, err := os.Open(p)
+				if err != nil {
+					return err
+				}
+
+				hdr.Typeflag = tar.TypeReg
+				hdr.Linkname = ""
+				hdr.Size = fi.Size()
+				err = te.UnpackEntry(root, hdr, f)
+				f.Close()
+				if err != nil {
+					return err
+				}
+
+				entries[name] = struct{}{}
+				continue
+			}
+		}
+
+		err = te.UnpackEntry(root, hdr, tr)
+		if err != nil {
+			return err
+		}
+
+		entries[name] = struct{}{}
+	}
+
+	for _, name := range whiteouts {
+		dir, base := filepath.Split(name)
+
+		parent, err := securejoin.SecureJoin(root, dir)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(parent, 0755)
+		if err != nil {
+			return err
+		}
+
+		if base != whiteoutOpaque {
+			// whiteouts only apply to lower layers
+			if _, ok := entries[filepath.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))]; ok {
+				continue
+			}
+		}
+
+		switch {
+		case m.overlayDriver() != "overlay":
+			err = ioutil.WriteFile(filepath.Join(parent, base), nil, 0644)
+		case base == whiteoutOpaque:
+			err = unix.Setxattr(parent, "trusted.overlay.opaque", []byte("y"), 0)
+		default:
+			err = unix.Mknod(filepath.Join(parent, strings.TrimPrefix(base, whiteoutPrefix)), unix.S_IFCHR, 0)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "can not create whiteout %s", name)
+		}
+	}
+
+	// directories not in the layer are created implicitly, and should be
+	// the same as lower layers, or they will cover the lower ones
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() || path == root {
+			return err
+		}
+
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := entries[name]; ok {
+			return nil
+		}
+
+		return copyDirMeta(lowers, name, path, opt.Rootless)
+	})
+}
+
+// copyDirMeta copies the mode, the owner and times of the directory in lower
+// layers.
+func copyDirMeta(lowers []string, name, path string, rootless bool) error {
+	_, fi := findLower(lowers, name)
+	if fi == nil || !fi.IsDir() {
+		return nil
+	}
+
+	err := os.Chmod(path, fi.Mode())
+	if err != nil {
+		return err
+	}
+
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if !rootless {
+			err = os.Lchown(path, int(st.Uid), int(st.Gid))
+			if err != nil {
+				return err
+			}
+		}
+
+		return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{unix.Timespec(st.Atim), unix.Timespec(st.Mtim)}, unix.AT_SYMLINK_NOFOLLOW)
+	}
+
+	return nil
+}
+
+// mountRootfs mounts lower layers with a private upper directory, the
+// layers are recorded for remounting. the upper directory is limited to
+// quota bytes if not zero.
+func (m *MetaManager) mountRootfs(rootfs string, lowers []string, quota uint64) error {
+	if quota > 0 {
+		err := m.setupQuota(rootfs, quota)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range []string{rootfs, upperPath(rootfs), workPath(rootfs)} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(lowers)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(lowerPath(rootfs), b, 0644)
+	if err != nil {
+		return err
+	}
+
+	return m.remountRootfs(rootfs)
+}
+
+// remountRootfs mounts the rootfs if it is not mounted. rootfs not built
+// from layers are left alone.
+func (m *MetaManager) remountRootfs(rootfs string) error {
+	b, err := ioutil.ReadFile(lowerPath(rootfs))
+	if os.IsNotExist(err) || utils.Mounted(rootfs) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var lowers []string
+	err = json.Unmarshal(b, &lowers)
+	if err != nil {
+		return err
+	}
+
+	err = mountDisk(rootfs)
+	if err != nil {
+		return err
+	}
+
+	// the root of the rootfs is the upper directory
+	err = copyDirMeta(lowers, ".", upperPath(rootfs), m.Rootless)
+	if err != nil {
+		return err
+	}
+
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(lowers, ":"), upperPath(rootfs), workPath(rootfs))
+
+	if m.overlayDriver() == "overlay" {
+		return errors.Wrap(unix.Mount("overlay", rootfs, "overlay", 0, opts), "can not mount overlay")
+	}
+
+	out, err := exec.Command("fuse-overlayfs", "-o", opts, rootfs).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "can not mount fuse-overlayfs: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (m *MetaManager) unmountRootfs(rootfs string) error {
+	if utils.Mounted(rootfs) {
+		if m.overlayDriver() == "overlay" {
+			err := unix.Unmount(rootfs, unix.MNT_DETACH)
+			if err != nil {
+				return err
+			}
+		} else {
+			out, err := exec.Command("fusermount", "-u", "-z", rootfs).CombinedOutput()
+			if err != nil {
+				return errors.Wrapf(err, "can not unmount fuse-overlayfs: %s", strings.TrimSpace(string(out)))
+			}
+		}
+	}
+
+	return unmountDisk(rootfs)
+}
@@ -0,0 +1,145 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	. "github.com/xhebox/chrootd/meta"
+)
+
+func TestImageUnpackLayers(t *testing.T) {
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	m := mgr.Manager.(*MetaManager)
+
+	layer1 := tarFiles(t, map[string][]byte{"dir/a": []byte("a"), "dir/b": []byte("b")})
+	layer2 := tarFiles(t, map[string][]byte{"dir/.wh.a": nil, "c": []byte("c")})
+
+	config, err := json.Marshal(ispec.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS: ispec.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{digest.FromBytes(layer1), digest.FromBytes(layer2)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := json.Marshal([]dockerManifest{
+		{
+			Config:   "config.json",
+			RepoTags: []string{"test:v1"},
+			Layers:   []string{"1/layer.tar", "2/layer.tar"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := tarFiles(t, map[string][]byte{
+		"manifest.json": manifest,
+		"config.json":   config,
+		"1/layer.tar":   layer1,
+		"2/layer.tar":   layer2,
+	})
+
+	err = m.ImageImport(context.Background(), "", "layers", bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := []string{}
+	for i := 0; i < 2; i++ {
+		id, err := m.Create(&Metainfo{
+			Name:           "test",
+			Image:          "layers",
+			ImageReference: "v1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rid, err := m.ImageUnpack(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		roots = append(roots, filepath.Join(m.rootfsPath, rid))
+	}
+
+	lowers := [][]byte{}
+	for _, root := range roots {
+		b, err := ioutil.ReadFile(lowerPath(root))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lowers = append(lowers, b)
+
+		if _, err := os.Lstat(filepath.Join(root, "dir/a")); !os.IsNotExist(err) {
+			t.Fatalf("expect dir/a to be whited out: %v", err)
+		}
+
+		for _, name := range []string{"dir/b", "c"} {
+			if _, err := os.Lstat(filepath.Join(root, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(lowers[0], lowers[1]) {
+		t.Fatalf("expect layers to be shared, got %s and %s", lowers[0], lowers[1])
+	}
+
+	// owners of files differ by mappings
+	id, err := m.Create(&Metainfo{
+		Name:           "test",
+		Image:          "layers",
+		ImageReference: "v1",
+		UidMapSize:     65536,
+		GidMapSize:     65536,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := m.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(lowerPath(filepath.Join(m.rootfsPath, rid)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reflect.DeepEqual(lowers[0], b) {
+		t.Fatalf("expect layers of other mappings not to be shared, got %s", b)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(roots[0], "d"), []byte("d"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(upperPath(roots[0]), "d")); err != nil {
+		t.Fatalf("expect writes to the upper directory: %v", err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(roots[1], "d")); !os.IsNotExist(err) {
+		t.Fatalf("expect writes to be private: %v", err)
+	}
+}
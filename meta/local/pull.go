@@ -0,0 +1,510 @@
+package local
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/openSUSE/umoci/oci/casext"
+	"github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	. "github.com/xhebox/chrootd/meta"
+)
+
+const (
+	defaultRegistry = "registry-1.docker.io"
+	pullRetries     = 3
+	maxManifestSize = 4 << 20
+
+	dockerManifestType     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestListType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerLayerGzipType    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	dockerForeignLayerType = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+)
+
+var (
+	manifestTypes = []string{
+		ispec.MediaTypeImageManifest,
+		ispec.MediaTypeImageIndex,
+		dockerManifestType,
+		dockerManifestListType,
+	}
+
+	challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+type pullRef struct {
+	registry string
+	repo     string
+	tag      string
+	digest   digest.Digest
+}
+
+// parsePullRef parses registry/repository:tag@digest, images without a
+// registry are pulled from docker hub.
+func parsePullRef(ref string) (*pullRef, error) {
+	res := &pullRef{}
+
+	if i := strings.Index(ref, "@"); i != -1 {
+		d, err := digest.Parse(ref[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		res.digest = d
+		ref = ref[:i]
+	}
+
+	if i := strings.LastIndex(ref, ":"); i != -1 && !strings.Contains(ref[i:], "/") {
+		res.tag = ref[i+1:]
+		ref = ref[:i]
+	}
+
+	i := strings.Index(ref, "/")
+	if i != -1 && (strings.ContainsAny(ref[:i], ".:") || ref[:i] == "localhost") {
+		res.registry = ref[:i]
+		res.repo = ref[i+1:]
+	} else {
+		res.registry = defaultRegistry
+		res.repo = ref
+	}
+
+	if res.registry == "docker.io" {
+		res.registry = defaultRegistry
+	}
+
+	if res.registry == defaultRegistry && !strings.Contains(res.repo, "/") {
+		res.repo = "library/" + res.repo
+	}
+
+	if res.repo == "" || strings.HasPrefix(res.repo, "/") || strings.HasSuffix(res.repo, "/") {
+		return nil, errors.Errorf("invalid repository of %s", ref)
+	}
+
+	if res.tag == "" {
+		res.tag = "latest"
+	}
+
+	return res, nil
+}
+
+type registry struct {
+	client   *http.Client
+	base     string
+	repo     string
+	username string
+	password string
+	auth     string
+}
+
+func registryError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if msg := strings.TrimSpace(string(b)); msg != "" {
+		return errors.Errorf("registry responded %s: %s", resp.Status, msg)
+	}
+	return errors.Errorf("registry responded %s", resp.Status)
+}
+
+// do sends the request, and authorizes once if it is challenged.
+func (r *registry) do(ctx context.Context, path string, header http.Header) (*http.Response, error) {
+	for i := 0; ; i++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", r.base+path, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range header {
+			req.Header[k] = v
+		}
+
+		if r.auth != "" {
+			req.Header.Set("Authorization", r.auth)
+		}
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized || i > 0 {
+			return resp, nil
+		}
+
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		err = r.authorize(ctx, challenge)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *registry) authorize(ctx context.Context, challenge string) error {
+	scheme := strings.SplitN(challenge, " ", 2)[0]
+
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if r.username == "" {
+			return errors.New("registry requires credentials")
+		}
+		r.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(r.username+":"+r.password))
+		return nil
+	case "bearer":
+		token, err := r.token(ctx, params)
+		if err != nil {
+			return err
+		}
+		r.auth = "Bearer " + token
+		return nil
+	default:
+		return errors.Errorf("unsupported authentication %s", scheme)
+	}
+}
+
+// token requests a token for pulling from the realm.
+func (r *registry) token(ctx context.Context, params map[string]string) (string, error) {
+	u, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", errors.New("invalid realm of the token challenge")
+	}
+
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", r.repo)
+	}
+
+	q := u.Query()
+	q.Set("scope", scope)
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Wrap(registryError(resp), "can not get a token")
+	}
+
+	var res struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return "", err
+	}
+
+	if res.Token == "" {
+		res.Token = res.AccessToken
+	}
+	if res.Token == "" {
+		return "", errors.New("empty token")
+	}
+
+	return res.Token, nil
+}
+
+// manifest fetches a manifest or an index by a tag or a digest, which is
+// verified.
+func (r *registry) manifest(ctx context.Context, ref string) ([]byte, string, error) {
+	resp, err := r.do(ctx, "/v2/"+r.repo+"/manifests/"+ref, http.Header{"Accept": manifestTypes})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", registryError(resp)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if d, err := digest.Parse(ref); err == nil && d.Algorithm().FromBytes(b) != d {
+		return nil, "", errors.Errorf("digest mismatch of manifest %s", ref)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" || mediaType == "application/json" {
+		var res struct {
+			MediaType string `json:"mediaType"`
+		}
+		json.Unmarshal(b, &res)
+		mediaType = res.MediaType
+	}
+
+	return b, mediaType, nil
+}
+
+// fetchBlob downloads a blob into the file, which is resumed if exists.
+func (r *registry) fetchBlob(ctx context.Context, desc ispec.Descriptor, file string) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if offset >= desc.Size {
+		return nil
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := r.do(ctx, "/v2/"+r.repo+"/blobs/"+desc.Digest.String(), header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// ranges are not supported, start over
+		err = f.Truncate(0)
+		if err != nil {
+			return err
+		}
+
+		offset, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	default:
+		return registryError(resp)
+	}
+
+	_, err = io.Copy(f, io.LimitReader(resp.Body, desc.Size-offset))
+	return err
+}
+
+// pullBlob downloads a blob into the image if not exist, partial downloads
+// are kept in the temporary directory, and resumed by later pulls.
+func (r *registry) pullBlob(ctx context.Context, cext casext.Engine, tmp string, desc ispec.Descriptor) error {
+	err := desc.Digest.Validate()
+	if err != nil {
+		return err
+	}
+
+	rd, err := cext.GetBlob(ctx, desc.Digest)
+	if err == nil {
+		rd.Close()
+		return nil
+	}
+
+	file := filepath.Join(tmp, "blob-"+desc.Digest.Hex())
+	for i := 0; i < pullRetries; i++ {
+		err = r.fetchBlob(ctx, desc, file)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can not download blob %s", desc.Digest)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file)
+	defer f.Close()
+
+	d, size, err := cext.PutBlob(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	if d != desc.Digest || size != desc.Size {
+		cext.DeleteBlob(ctx, d)
+		return errors.Errorf("digest mismatch of blob %s", desc.Digest)
+	}
+
+	return nil
+}
+
+// selectPlatform selects the manifest of the platform from an index.
+func selectPlatform(manifests []ispec.Descriptor, platform string) (ispec.Descriptor, error) {
+	parts := strings.SplitN(platform, "/", 3)
+	if len(parts) < 2 {
+		return ispec.Descriptor{}, errors.Errorf("invalid platform %s", platform)
+	}
+
+	for _, desc := range manifests {
+		p := desc.Platform
+		if p == nil || p.OS != parts[0] || p.Architecture != parts[1] {
+			continue
+		}
+
+		if len(parts) == 3 && p.Variant != parts[2] {
+			continue
+		}
+
+		return desc, nil
+	}
+
+	return ispec.Descriptor{}, errors.Errorf("no image for platform %s", platform)
+}
+
+func (m *MetaManager) ImagePull(ctx context.Context, opts *PullOptions) (err error) {
+	ref, err := parsePullRef(opts.Reference)
+	if err != nil {
+		return err
+	}
+
+	name := opts.Image
+	if name == "" {
+		name = filepath.Base(ref.repo)
+	}
+
+	err = validImageName(name)
+	if err != nil {
+		return err
+	}
+
+	if !casext.IsValidReferenceName(ref.tag) {
+		return errors.Errorf("invalid reference %s", ref.tag)
+	}
+
+	platform := opts.Platform
+	if platform == "" {
+		platform = runtime.GOOS + "/" + runtime.GOARCH
+	}
+
+	scheme := "https"
+	if opts.PlainHTTP {
+		scheme = "http"
+	}
+
+	r := &registry{
+		client:   &http.Client{},
+		base:     scheme + "://" + ref.registry,
+		repo:     ref.repo,
+		username: opts.Username,
+		password: opts.Password,
+	}
+
+	target := ref.tag
+	if ref.digest != "" {
+		target = ref.digest.String()
+	}
+
+	b, mediaType, err := r.manifest(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	if mediaType == ispec.MediaTypeImageIndex || mediaType == dockerManifestListType {
+		var index ispec.Index
+		err = json.Unmarshal(b, &index)
+		if err != nil {
+			return err
+		}
+
+		desc, err := selectPlatform(index.Manifests, platform)
+		if err != nil {
+			return err
+		}
+
+		b, mediaType, err = r.manifest(ctx, desc.Digest.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	if mediaType != ispec.MediaTypeImageManifest && mediaType != dockerManifestType {
+		return errors.Errorf("unsupported manifest %s", mediaType)
+	}
+
+	var manifest ispec.Manifest
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(m.imagePath, name)
+
+	cext, created, err := openImage(path)
+	if err != nil {
+		return err
+	}
+	defer cext.Close()
+
+	if created {
+		defer func() {
+			if err != nil {
+				os.RemoveAll(path)
+			}
+		}()
+	}
+
+	for _, desc := range append([]ispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		err = r.pullBlob(ctx, cext, m.tmpPath, desc)
+		if err != nil {
+			return err
+		}
+	}
+
+	desc := ispec.Descriptor{MediaType: ispec.MediaTypeImageManifest}
+	if mediaType == dockerManifestType {
+		// media types of docker are converted, it is a new manifest then
+		manifest.Config.MediaType = ispec.MediaTypeImageConfig
+		for i := range manifest.Layers {
+			switch manifest.Layers[i].MediaType {
+			case dockerLayerGzipType:
+				manifest.Layers[i].MediaType = ispec.MediaTypeImageLayerGzip
+			case dockerForeignLayerType:
+				manifest.Layers[i].MediaType = ispec.MediaTypeImageLayerNonDistributableGzip
+			}
+		}
+
+		desc.Digest, desc.Size, err = cext.PutBlobJSON(ctx, manifest)
+	} else {
+		desc.Digest, desc.Size, err = cext.PutBlob(ctx, strings.NewReader(string(b)))
+	}
+	if err != nil {
+		return err
+	}
+
+	return cext.UpdateReference(ctx, ref.tag, desc)
+}
@@ -0,0 +1,33 @@
+package local
+
+import (
+	"testing"
+)
+
+const zeroDigest = "0000000000000000000000000000000000000000000000000000000000000000"
+
+func TestParsePullRef(t *testing.T) {
+	for ref, res := range map[string]pullRef{
+		"busybox":                          {registry: defaultRegistry, repo: "library/busybox", tag: "latest"},
+		"docker.io/xhebox/chrootd:v1":      {registry: defaultRegistry, repo: "xhebox/chrootd", tag: "v1"},
+		"localhost/busybox":                {registry: "localhost", repo: "busybox", tag: "latest"},
+		"localhost:5000/a/b:v2":            {registry: "localhost:5000", repo: "a/b", tag: "v2"},
+		"quay.io/a/b@sha256:" + zeroDigest: {registry: "quay.io", repo: "a/b", tag: "latest", digest: "sha256:" + zeroDigest},
+	} {
+		r, err := parsePullRef(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if *r != res {
+			t.Fatalf("expect %+v for %s, got %+v", res, ref, *r)
+		}
+	}
+
+	for _, ref := range []string{"", "localhost:5000/", "busybox@sha256:xx"} {
+		_, err := parsePullRef(ref)
+		if err == nil {
+			t.Fatalf("expect an error for %s", ref)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package local
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	. "github.com/xhebox/chrootd/meta"
+)
+
+func diskPath(rootfs string) string {
+	return rootfs + ".disk"
+}
+
+func diskImagePath(rootfs string) string {
+	return rootfs + ".img"
+}
+
+// setupQuota limits the writable layer of the rootfs by a project quota if
+// the filesystem supports, or puts it in a loopback filesystem.
+func (m *MetaManager) setupQuota(rootfs string, size uint64) error {
+	if os.Geteuid() != 0 {
+		return errors.New("disk quotas are not supported by rootless daemons")
+	}
+
+	q, err := NewProjectQuota(m.node, m.rootfsPath, size)
+	if err != nil {
+		return err
+	}
+
+	if q != nil {
+		for _, dir := range []string{upperPath(rootfs), workPath(rootfs)} {
+			err := os.MkdirAll(dir, 0755)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = q.Apply(upperPath(rootfs), workPath(rootfs))
+		if err != nil {
+			return err
+		}
+
+		return SaveQuota(rootfs, q)
+	}
+
+	q, err = NewDiskQuota(size, diskImagePath(rootfs), diskPath(rootfs))
+	if err != nil {
+		return err
+	}
+
+	return SaveQuota(rootfs, q)
+}
+
+// mountDisk mounts the loopback filesystem of the rootfs if any.
+func mountDisk(rootfs string) error {
+	q, err := LoadQuota(rootfs)
+	if err != nil || q == nil {
+		return err
+	}
+
+	return q.Mount()
+}
+
+func unmountDisk(rootfs string) error {
+	q, err := LoadQuota(rootfs)
+	if err != nil || q == nil {
+		return err
+	}
+
+	return q.Unmount()
+}
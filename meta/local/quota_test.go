@@ -0,0 +1,71 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/xhebox/chrootd/meta"
+)
+
+func TestImageUnpackQuota(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("disk quotas need root")
+	}
+
+	mgr, err := NewTestMetaManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	m := mgr.Manager.(*MetaManager)
+
+	id, err := m.Create(&Metainfo{
+		Name:           "test",
+		Image:          "alpine",
+		ImageReference: "latest",
+		DiskQuota:      16 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := m.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootfs := filepath.Join(m.rootfsPath, rid)
+
+	q, err := LoadQuota(rootfs)
+	if err != nil || q == nil || q.Size != 16<<20 {
+		t.Fatalf("unexpected quota %+v: %v", q, err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(rootfs, "small"), make([]byte, 4<<20), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(rootfs, "big"), make([]byte, 32<<20), 0644)
+	if err == nil {
+		t.Fatal("expect writes beyond the quota to fail")
+	}
+
+	err = m.ImageDelete(id, rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fis, err := ioutil.ReadDir(m.rootfsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fi := range fis {
+		t.Errorf("expect %s to be removed", fi.Name())
+	}
+}
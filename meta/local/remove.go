@@ -0,0 +1,160 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/openSUSE/umoci/oci/layer"
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"github.com/xhebox/chrootd/utils"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	RemoveFlag = &cli.Command{
+		Name:   "___rm",
+		Hidden: true,
+		Action: func(c *cli.Context) error {
+			InitRemove()
+			return nil
+		},
+	}
+)
+
+// InitRemove removes a tree in the user namespace of rootless containers.
+// capabilities are dropped by executing before mappings are written, so it
+// waits for the mappings, and executes itself again as the root of the
+// namespace.
+func InitRemove() {
+	if len(os.Args) != 4 {
+		fmt.Fprintln(os.Stderr, "usage: ___rm wait|rm path")
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[2] {
+	case "wait":
+		_, err = ioutil.ReadAll(os.Stdin)
+		if err == nil {
+			err = unix.Exec("/proc/self/exe", []string{os.Args[0], os.Args[1], "rm", os.Args[3]}, os.Environ())
+		}
+	case "rm":
+		err = os.RemoveAll(os.Args[3])
+	default:
+		err = errors.Errorf("unknown stage %s", os.Args[2])
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+// removeTree removes the tree. files of rootless containers may be owned
+// by mapped ids other than the daemon, which are removed in the user
+// namespace of the container.
+func (m *MetaManager) removeTree(path string, opt *layer.MapOptions) error {
+	err := utils.RemoveTree(path)
+	if err == nil || !os.IsPermission(err) || !m.Rootless {
+		return err
+	}
+
+	return removeTreeAs(path, opt)
+}
+
+func removeTreeAs(path string, opt *layer.MapOptions) error {
+	cmd := exec.Command(os.Args[0], "___rm", "wait", path)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: unix.CLONE_NEWUSER,
+		Pdeathsig:  unix.SIGKILL,
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	pid := cmd.Process.Pid
+
+	// like runc, mapping tools are used if the mappings can not be written
+	if os.Geteuid() != 0 && !requiresMappingTool(opt) {
+		err = ioutil.WriteFile(fmt.Sprintf("/proc/%d/setgroups", pid), []byte("deny"), 0)
+	}
+	if err == nil {
+		err = writeIDMap(pid, "uid_map", opt.UIDMappings, "newuidmap")
+	}
+	if err == nil {
+		err = writeIDMap(pid, "gid_map", opt.GIDMappings, "newgidmap")
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		w.Close()
+		cmd.Wait()
+		return err
+	}
+
+	w.Close()
+
+	err = cmd.Wait()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.Errorf("can not remove %s: %s", path, msg)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func requiresMappingTool(opt *layer.MapOptions) bool {
+	for _, m := range opt.UIDMappings {
+		if m.Size != 1 || m.HostID != uint32(os.Geteuid()) {
+			return true
+		}
+	}
+	for _, m := range opt.GIDMappings {
+		if m.Size != 1 || m.HostID != uint32(os.Getegid()) {
+			return true
+		}
+	}
+	return len(opt.UIDMappings) > 1 || len(opt.GIDMappings) > 1
+}
+
+func writeIDMap(pid int, file string, maps []rspec.LinuxIDMapping, tool string) error {
+	var b strings.Builder
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range maps {
+		fmt.Fprintf(&b, "%d %d %d\n", m.ContainerID, m.HostID, m.Size)
+		args = append(args, strconv.Itoa(int(m.ContainerID)), strconv.Itoa(int(m.HostID)), strconv.Itoa(int(m.Size)))
+	}
+
+	err := ioutil.WriteFile(fmt.Sprintf("/proc/%d/%s", pid, file), []byte(b.String()), 0)
+	if err == nil || !os.IsPermission(err) {
+		return err
+	}
+
+	out, err := exec.Command(tool, args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%s: %s", tool, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
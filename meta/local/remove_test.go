@@ -0,0 +1,82 @@
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openSUSE/umoci/oci/layer"
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/xhebox/chrootd/utils"
+)
+
+func init() {
+	if len(os.Args) > 1 && os.Args[1] == "___rm" {
+		InitRemove()
+	}
+}
+
+func idMapOptions(size uint32) *layer.MapOptions {
+	return &layer.MapOptions{
+		Rootless:    true,
+		UIDMappings: []rspec.LinuxIDMapping{{ContainerID: 0, HostID: uint32(os.Geteuid()), Size: size}},
+		GIDMappings: []rspec.LinuxIDMapping{{ContainerID: 0, HostID: uint32(os.Getegid()), Size: size}},
+	}
+}
+
+func TestRemoveTreeAs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown needs root")
+	}
+
+	dir, err := ioutil.TempDir(os.TempDir(), "temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a directory of the container, which is not writable even by the
+	// owner, with a file chowned to another user
+	tree := filepath.Join(dir, "tree")
+	sub := filepath.Join(tree, "sub")
+	err = os.MkdirAll(sub, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(sub, "file"), []byte("file"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []struct {
+		path string
+		id   int
+	}{{filepath.Join(sub, "file"), 1001}, {sub, 1000}} {
+		err = os.Lchown(v.path, v.id, v.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = os.Chmod(sub, 0500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ids out of the mappings are not owned by the namespace
+	err = removeTreeAs(tree, idMapOptions(1000))
+	if err == nil || !utils.PathExist(filepath.Join(sub, "file")) {
+		t.Fatalf("expect the removal to be confined to the mappings, got %v", err)
+	}
+
+	err = removeTreeAs(tree, idMapOptions(65536))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if utils.PathExist(tree) {
+		t.Fatal("expect the tree to be removed")
+	}
+}
@@ -1,10 +1,17 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
 
 	"github.com/xhebox/chrootd/client"
+	ctyp "github.com/xhebox/chrootd/cntr"
 	mtyp "github.com/xhebox/chrootd/meta"
+	"github.com/xhebox/chrootd/utils"
 )
 
 type MetaProxy struct {
@@ -14,7 +21,7 @@ type MetaProxy struct {
 	Context context.Context
 }
 
-func NewMetaProxy(svcname string, cli interface{}, opts ...func(*MetaProxy) error) (mtyp.Manager, error) {
+func NewMetaProxy(svcname string, cli interface{}, attachAddr *utils.Addr, opts ...func(*MetaProxy) error) (mtyp.Manager, error) {
 	mgr := &MetaProxy{svc: svcname, Context: context.Background(), Network: "tcp"}
 
 	for i := range opts {
@@ -23,7 +30,12 @@ func NewMetaProxy(svcname string, cli interface{}, opts ...func(*MetaProxy) erro
 		}
 	}
 
-	pro, err := client.NewProxy(svcname, mgr.Network, cli, nil)
+	meta := map[string]string{}
+	if attachAddr != nil {
+		meta["attachNetwork"] = attachAddr.Network()
+		meta["attach"] = attachAddr.String()
+	}
+	pro, err := client.NewProxy(svcname, mgr.Network, cli, meta)
 	if err != nil {
 		return nil, err
 	}
@@ -97,6 +109,122 @@ func (m *MetaProxy) ImageDelete(mid, rid string) error {
 	})
 }
 
+func (m *MetaProxy) ImageCommit(ctx context.Context, mid, rid, image, ref string) error {
+	return m.Call(mid, func(cli client.Client, svc map[string]string) error {
+		return cli.Call(ctx, m.svc, "ImageCommit", &CommitReq{
+			MetaId:  mid,
+			ImageId: rid,
+			Image:   image,
+			Ref:     ref,
+		}, nil)
+	})
+}
+
+// dial requests a token by method from the node, and hands it to the attach
+// server of the node
+func (m *MetaProxy) dial(ctx context.Context, node, method string, req interface{}) (net.Conn, error) {
+	var attachAddr *utils.Addr
+	tok := []byte{}
+	err := m.Call(node, func(cli client.Client, svc map[string]string) error {
+		attachAddr = utils.NewAddrString(svc["attachNetwork"], svc["attach"])
+		return cli.Call(ctx, m.svc, method, req, &tok)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := net.ResolveTCPAddr(attachAddr.Network(), attachAddr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTCP(attachAddr.Network(), nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(conn, bytes.NewReader(tok))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (m *MetaProxy) ImageImport(ctx context.Context, node, image string, rd io.Reader) error {
+	conn, err := m.dial(ctx, node, "ImageImport", &ImportReq{
+		Image: image,
+	})
+	if err != nil {
+		return err
+	}
+
+	a := ctyp.NewAttachClient(conn)
+	defer a.Close()
+
+	// the error of the server is preferred, writes fail once it has gone
+	_, err = io.Copy(a, rd)
+	if err == nil {
+		err = a.CloseWrite()
+	}
+
+	_, werr := a.Wait()
+	if werr != nil {
+		return werr
+	}
+
+	return err
+}
+
+type exportClient struct {
+	ctyp.Attacher
+}
+
+func (c *exportClient) Read(b []byte) (int, error) {
+	n, err := c.Attacher.Read(b)
+	if err == io.EOF {
+		if _, werr := c.Wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (m *MetaProxy) ImageExport(node, image, ref string) (io.ReadCloser, error) {
+	conn, err := m.dial(m.Context, node, "ImageExport", &ExportReq{
+		Image: image,
+		Ref:   ref,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &exportClient{ctyp.NewAttachClient(conn)}, nil
+}
+
+func (m *MetaProxy) ImagePull(ctx context.Context, opts *mtyp.PullOptions) error {
+	return m.Oneshot(opts.Node, func(cli client.Client) error {
+		return cli.Call(ctx, m.svc, "ImagePull", opts, nil)
+	})
+}
+
+func (m *MetaProxy) ImagePrune(grace time.Duration) ([]string, error) {
+	var mu sync.Mutex
+	res := []string{}
+	err := m.Broadcast(func(cli client.Client) error {
+		ids := []string{}
+		err := cli.Call(m.Context, m.svc, "ImagePrune", &PruneReq{Grace: grace}, &ids)
+
+		mu.Lock()
+		res = append(res, ids...)
+		mu.Unlock()
+
+		return err
+	})
+	return res, err
+}
+
 func (m *MetaProxy) ImageList(mid string, f func(string) error) error {
 	return m.Call(mid, func(cli client.Client, svc map[string]string) error {
 		res := []string{}
@@ -133,3 +261,22 @@ func (m *MetaProxy) ImageAvailable(ctx context.Context, f func(string, string, [
 		return nil
 	})
 }
+
+func (m *MetaProxy) DiskUsage(ctx context.Context, f func(*mtyp.DiskUsage) error) error {
+	return m.Broadcast(func(cli client.Client) error {
+		res := []mtyp.DiskUsage{}
+
+		err := cli.Call(ctx, m.svc, "DiskUsage", nil, &res)
+		if err != nil {
+			return err
+		}
+
+		for k := range res {
+			if err := f(&res[k]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -51,6 +51,11 @@ func newTestMetaProxy(consul bool, t *testing.T) (*TestMetaProxy, error) {
 		return nil, err
 	}
 
+	image, err = mtest.TempImages(dir, image)
+	if err != nil {
+		return nil, err
+	}
+
 	loc1, err := mloc.NewMetaManager(dir, image, s1)
 	if err != nil {
 		return nil, err
@@ -68,6 +73,13 @@ func newTestMetaProxy(consul bool, t *testing.T) (*TestMetaProxy, error) {
 		return nil, err
 	}
 
+	attachAddr := utils.NewAddrFree()
+
+	lnAttach, err := net.Listen(attachAddr.Network(), attachAddr.String())
+	if err != nil {
+		return nil, err
+	}
+
 	addr2 := utils.NewAddrFree()
 
 	lnRPC2, err := net.Listen(addr2.Network(), addr2.String())
@@ -75,6 +87,13 @@ func newTestMetaProxy(consul bool, t *testing.T) (*TestMetaProxy, error) {
 		return nil, err
 	}
 
+	attachAddr2 := utils.NewAddrFree()
+
+	lnAttach2, err := net.Listen(attachAddr2.Network(), attachAddr2.String())
+	if err != nil {
+		return nil, err
+	}
+
 	var con *api.Client
 	var tsrv *testutil.TestServer
 	if consul {
@@ -100,7 +119,7 @@ func newTestMetaProxy(consul bool, t *testing.T) (*TestMetaProxy, error) {
 		},
 	)
 
-	svc1, err := NewMetaService(loc1, con, "s", addr)
+	svc1, err := NewMetaService(loc1, con, "s", addr, attachAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -111,6 +130,7 @@ func newTestMetaProxy(consul bool, t *testing.T) (*TestMetaProxy, error) {
 	}
 
 	go srv1.ServeListener(addr.Network(), lnRPC)
+	go svc1.ServeListener(lnAttach)
 
 	srv2 := server.NewServer(
 		func(srv *server.Server) {
@@ -119,7 +139,7 @@ func newTestMetaProxy(consul bool, t *testing.T) (*TestMetaProxy, error) {
 		},
 	)
 
-	svc2, err := NewMetaService(loc2, con, "s", addr2)
+	svc2, err := NewMetaService(loc2, con, "s", addr2, attachAddr2)
 	if err != nil {
 		return nil, err
 	}
@@ -130,10 +150,11 @@ func newTestMetaProxy(consul bool, t *testing.T) (*TestMetaProxy, error) {
 	}
 
 	go srv2.ServeListener(addr2.Network(), lnRPC2)
+	go svc2.ServeListener(lnAttach2)
 
 	var mgr mtyp.Manager
 	if consul {
-		mgr, err = NewMetaProxy("s", con)
+		mgr, err = NewMetaProxy("s", con, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -143,7 +164,7 @@ func newTestMetaProxy(consul bool, t *testing.T) (*TestMetaProxy, error) {
 			return nil, err
 		}
 
-		mgr, err = NewMetaProxy("s", cli)
+		mgr, err = NewMetaProxy("s", cli, attachAddr)
 		if err != nil {
 			return nil, err
 		}
@@ -151,6 +172,8 @@ func newTestMetaProxy(consul bool, t *testing.T) (*TestMetaProxy, error) {
 
 	return &TestMetaProxy{dir: dir,
 		tsrv:    tsrv,
+		ln1:     lnAttach,
+		ln2:     lnAttach2,
 		srv1:    srv1,
 		srv2:    srv2,
 		svc1:    svc1,
@@ -171,6 +194,8 @@ func NewTestMetaProxyConsul(t *testing.T) (*TestMetaProxy, error) {
 type TestMetaProxy struct {
 	dir  string
 	tsrv *testutil.TestServer
+	ln1  net.Listener
+	ln2  net.Listener
 	loc1 mtyp.Manager
 	svc1 *MetaService
 	srv1 *server.Server
@@ -182,13 +207,18 @@ type TestMetaProxy struct {
 
 func (t *TestMetaProxy) Close() error {
 	t.Manager.Close()
+	t.ln2.Close()
+	t.svc2.Shutdown()
 	t.srv2.Shutdown(context.Background())
 	t.loc2.Close()
+	t.ln1.Close()
+	t.svc1.Shutdown()
 	t.srv1.Shutdown(context.Background())
 	t.loc1.Close()
 	if t.tsrv != nil {
 		t.tsrv.Stop()
 	}
+	mtest.Unmount(t.dir)
 	return os.RemoveAll(t.dir)
 }
 
@@ -272,6 +302,26 @@ func TestMetaManagerImageDelete(t *testing.T) {
 	mtest.TestMetaManagerImageDelete(mgr, t)
 }
 
+func TestMetaManagerImagePrune(t *testing.T) {
+	mgr, err := NewTestMetaProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerImagePrune(mgr, t)
+}
+
+func TestMetaManagerDiskUsage(t *testing.T) {
+	mgr, err := NewTestMetaProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerDiskUsage(mgr, t)
+}
+
 func TestMetaManagerImageList(t *testing.T) {
 	mgr, err := NewTestMetaProxy(t)
 	if err != nil {
@@ -374,6 +424,26 @@ func TestMetaManagerConsulImageDelete(t *testing.T) {
 	mtest.TestMetaManagerImageDelete(mgr, t)
 }
 
+func TestMetaManagerConsulImagePrune(t *testing.T) {
+	mgr, err := NewTestMetaProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerImagePrune(mgr, t)
+}
+
+func TestMetaManagerConsulDiskUsage(t *testing.T) {
+	mgr, err := NewTestMetaProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerDiskUsage(mgr, t)
+}
+
 func TestMetaManagerConsulImageList(t *testing.T) {
 	mgr, err := NewTestMetaProxyConsul(t)
 	if err != nil {
@@ -393,3 +463,73 @@ func TestMetaManagerConsulImageAvailable(t *testing.T) {
 
 	mtest.TestMetaManagerImageAvailable(mgr, t)
 }
+
+func TestMetaManagerImageCommit(t *testing.T) {
+	mgr, err := NewTestMetaProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerImageCommit(mgr, t)
+}
+
+func TestMetaManagerConsulImageCommit(t *testing.T) {
+	mgr, err := NewTestMetaProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerImageCommit(mgr, t)
+}
+
+func TestMetaManagerImageImportExport(t *testing.T) {
+	mgr, err := NewTestMetaProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerImageImportExport(mgr, t)
+}
+
+func TestMetaManagerConsulImageImportExport(t *testing.T) {
+	mgr, err := NewTestMetaProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	mtest.TestMetaManagerImageImportExport(mgr, t)
+}
+
+func TestMetaManagerImagePull(t *testing.T) {
+	mgr, err := NewTestMetaProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	image, err := filepath.Abs("../../images")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtest.TestMetaManagerImagePull(mgr, image, t)
+}
+
+func TestMetaManagerConsulImagePull(t *testing.T) {
+	mgr, err := NewTestMetaProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	image, err := filepath.Abs("../../images")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtest.TestMetaManagerImagePull(mgr, image, t)
+}
@@ -3,9 +3,16 @@ package proxy
 import (
 	"context"
 	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+	ctyp "github.com/xhebox/chrootd/cntr"
 	mtyp "github.com/xhebox/chrootd/meta"
 	"github.com/xhebox/chrootd/utils"
 )
@@ -16,14 +23,19 @@ type MetaService struct {
 	reg         *api.AgentServiceRegistration
 	cli         *api.Client
 	mgr         mtyp.Manager
+	tok         *cache.Cache
+	activeConn  map[net.Conn]struct{}
+	mu          sync.Mutex
 	QueryLimits int
 }
 
-func NewMetaService(mgr mtyp.Manager, cli *api.Client, svcname string, rpcAddr *utils.Addr) (*MetaService, error) {
+func NewMetaService(mgr mtyp.Manager, cli *api.Client, svcname string, rpcAddr, attachAddr *utils.Addr) (*MetaService, error) {
 	svc := &MetaService{
 		cli:         cli,
 		mgr:         mgr,
 		addr:        rpcAddr,
+		tok:         cache.New(time.Minute, 10*time.Minute),
+		activeConn:  make(map[net.Conn]struct{}),
 		QueryLimits: 64,
 	}
 
@@ -39,7 +51,11 @@ func NewMetaService(mgr mtyp.Manager, cli *api.Client, svcname string, rpcAddr *
 			Name:    svcname,
 			Address: svc.addr.Addr(),
 			Port:    svc.addr.Port(),
-			Tags:    []string{svc.id},
+			Meta: map[string]string{
+				"attachNetwork": attachAddr.Network(),
+				"attach":        attachAddr.String(),
+			},
+			Tags: []string{svc.id},
 		}
 
 		err = cli.Agent().ServiceRegisterOpts(svc.reg, api.ServiceRegisterOpts{ReplaceExistingChecks: true})
@@ -107,6 +123,50 @@ func (s *MetaService) ImageDelete(ctx context.Context, req *DeleteReq, res *stru
 	return s.mgr.ImageDelete(req.MetaId, req.ImageId)
 }
 
+type CommitReq struct {
+	MetaId  string
+	ImageId string
+	Image   string
+	Ref     string
+}
+
+func (s *MetaService) ImageCommit(ctx context.Context, req *CommitReq, res *struct{}) error {
+	return s.mgr.ImageCommit(ctx, req.MetaId, req.ImageId, req.Image, req.Ref)
+}
+
+type ImportReq struct {
+	Image string
+}
+
+func (s *MetaService) ImageImport(ctx context.Context, req *ImportReq, res *[]byte) error {
+	*res = ksuid.New().Bytes()
+	return s.tok.Add(string(*res), req, cache.DefaultExpiration)
+}
+
+type ExportReq struct {
+	Image string
+	Ref   string
+}
+
+func (s *MetaService) ImageExport(ctx context.Context, req *ExportReq, res *[]byte) error {
+	*res = ksuid.New().Bytes()
+	return s.tok.Add(string(*res), req, cache.DefaultExpiration)
+}
+
+func (s *MetaService) ImagePull(ctx context.Context, req *mtyp.PullOptions, res *struct{}) error {
+	return s.mgr.ImagePull(ctx, req)
+}
+
+type PruneReq struct {
+	Grace time.Duration
+}
+
+func (s *MetaService) ImagePrune(ctx context.Context, req *PruneReq, res *[]string) error {
+	var err error
+	*res, err = s.mgr.ImagePrune(req.Grace)
+	return err
+}
+
 func (s *MetaService) ImageList(ctx context.Context, cid string, res *[]string) error {
 	cnt := 0
 	return s.mgr.ImageList(cid, func(id string) error {
@@ -136,3 +196,146 @@ func (s *MetaService) ImageAvailable(ctx context.Context, req struct{}, res *[]I
 		return nil
 	})
 }
+
+func (s *MetaService) DiskUsage(ctx context.Context, req struct{}, res *[]mtyp.DiskUsage) error {
+	cnt := 0
+	return s.mgr.DiskUsage(ctx, func(u *mtyp.DiskUsage) error {
+		*res = append(*res, *u)
+		cnt++
+		if cnt > s.QueryLimits {
+			return errors.New("exceed the query limits")
+		}
+		return nil
+	})
+}
+
+// serveImport reads the archive from stdin frames, until stdin is closed
+func (s *MetaService) serveImport(conn net.Conn, req *ImportReq) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	go func() {
+		for {
+			fr, err := ctyp.ReadFrame(conn)
+			if err != nil {
+				cancel()
+				pw.CloseWithError(err)
+				return
+			}
+
+			switch fr.Type {
+			case ctyp.FrameStdin:
+				_, err = pw.Write(fr.Payload)
+				if err != nil {
+					return
+				}
+			case ctyp.FrameCloseStdin:
+				pw.Close()
+				return
+			}
+		}
+	}()
+
+	err := s.mgr.ImageImport(ctx, "", req.Image, pr)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+
+	return ctyp.WriteFrame(conn, &ctyp.Frame{Type: ctyp.FrameExit, Payload: make([]byte, 4)})
+}
+
+// serveExport sends the archive as stdout frames
+func (s *MetaService) serveExport(conn net.Conn, req *ExportReq) error {
+	rd, err := s.mgr.ImageExport("", req.Image, req.Ref)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := rd.Read(buf)
+		if n > 0 {
+			werr := ctyp.WriteFrame(conn, &ctyp.Frame{Type: ctyp.FrameStdout, Payload: buf[:n]})
+			if werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return ctyp.WriteFrame(conn, &ctyp.Frame{Type: ctyp.FrameExit, Payload: make([]byte, 4)})
+}
+
+// ServeStream serves the stream of the token, it is used as Streams of
+// services sharing the attach listener.
+func (s *MetaService) ServeStream(conn net.Conn, tok []byte) error {
+	tmp, _ := s.tok.Get(string(tok))
+	switch req := tmp.(type) {
+	case *ImportReq:
+		return s.serveImport(conn, req)
+	case *ExportReq:
+		return s.serveExport(conn, req)
+	default:
+		return errors.New("invalid token")
+	}
+}
+
+func (s *MetaService) ServeListener(ln net.Listener) error {
+	defer ln.Close()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Microsecond)
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.activeConn[c] = struct{}{}
+		s.mu.Unlock()
+
+		go func(conn net.Conn) (err error) {
+			defer func() {
+				if err != nil {
+					ctyp.WriteFrame(conn, &ctyp.Frame{Type: ctyp.FrameError, Payload: []byte(err.Error())})
+				}
+				conn.Close()
+
+				s.mu.Lock()
+				delete(s.activeConn, conn)
+				s.mu.Unlock()
+			}()
+
+			var tok ksuid.KSUID
+
+			_, err = io.ReadFull(conn, tok[:])
+			if err != nil {
+				return err
+			}
+
+			return s.ServeStream(conn, tok.Bytes())
+		}(c)
+	}
+}
+
+func (s *MetaService) Shutdown() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for c := range s.activeConn {
+		err = c.Close()
+	}
+	return err
+}
@@ -0,0 +1,283 @@
+package meta
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/chrootd/store"
+	"github.com/xhebox/chrootd/utils"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	qSetQuota  = 0x800008
+	qGetQuota  = 0x800007
+	prjQuota   = 2
+	qifBLimits = 1
+
+	fsIocFsGetXattr    = 0x801c581f
+	fsIocFsSetXattr    = 0x401c5820
+	fsXflagProjInherit = 0x200
+
+	// projects are allocated after this, to stay away from the ones of
+	// administrators
+	projectBase = 1 << 20
+)
+
+type dqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+	_          uint32
+}
+
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	_          [8]byte
+}
+
+// Quota limits directories by the project quota of the device, or by a
+// loopback filesystem of the size in Image, which is mounted on Disk.
+type Quota struct {
+	Size    uint64 `json:"size"`
+	Project uint32 `json:"project"`
+	Device  string `json:"device"`
+	Image   string `json:"image"`
+	Disk    string `json:"disk"`
+}
+
+// QuotaPath is where the quota of an unpacked rootfs is kept.
+func QuotaPath(rootfs string) string {
+	return rootfs + ".quota"
+}
+
+// LoadQuota returns nil if the rootfs is not limited.
+func LoadQuota(rootfs string) (*Quota, error) {
+	b, err := ioutil.ReadFile(QuotaPath(rootfs))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	res := &Quota{}
+	return res, json.Unmarshal(b, res)
+}
+
+func SaveQuota(rootfs string, q *Quota) error {
+	b, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(QuotaPath(rootfs), b, 0644)
+}
+
+// findMount returns the source and super options of the filesystem where
+// path is.
+func findMount(path string) (string, string, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	mnt, source, opts := "", "", ""
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep == -1 || sep+3 >= len(fields) {
+			continue
+		}
+
+		p := strings.Replace(fields[4], `\040`, " ", -1)
+		if p != "/" && path != p && !strings.HasPrefix(path, p+"/") {
+			continue
+		}
+
+		// the later one is mounted over the former
+		if len(p) >= len(mnt) {
+			mnt, source, opts = p, fields[sep+2], fields[sep+3]
+		}
+	}
+
+	return source, opts, sc.Err()
+}
+
+// NewProjectQuota allocates a project by the sequence of the store shared
+// by managers of a node. it returns nil if the filesystem where path is
+// does not support project quotas.
+func NewProjectQuota(s store.Store, path string, size uint64) (*Quota, error) {
+	source, opts, err := findMount(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, opt := range strings.Split(opts, ",") {
+		if opt != "prjquota" {
+			continue
+		}
+
+		projects, err := store.NewWrapStore("quota", s)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := projects.NextSequence()
+		if err != nil {
+			return nil, err
+		}
+
+		return &Quota{Size: size, Project: uint32(projectBase + id), Device: source}, nil
+	}
+
+	return nil, nil
+}
+
+// NewDiskQuota makes a loopback filesystem of the size, and mounts it.
+func NewDiskQuota(size uint64, image, disk string) (*Quota, error) {
+	f, err := os.OpenFile(image, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Truncate(int64(size))
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", image).CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not make the filesystem of the quota: %s", strings.TrimSpace(string(out)))
+	}
+
+	err = os.MkdirAll(disk, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Quota{Size: size, Image: image, Disk: disk}
+	return q, q.Mount()
+}
+
+func quotactl(cmd int, dev string, id uint32, dq *dqblk) error {
+	p, err := unix.BytePtrFromString(dev)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, uintptr(cmd<<8|prjQuota), uintptr(unsafe.Pointer(p)), uintptr(id), uintptr(unsafe.Pointer(dq)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Apply puts directories into the project, files created in them inherit
+// the project. the limit of the project is set to the size.
+func (q *Quota) Apply(dirs ...string) error {
+	for _, dir := range dirs {
+		f, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+
+		var attr fsxattr
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&attr)))
+		if errno == 0 {
+			attr.projid = q.Project
+			attr.xflags |= fsXflagProjInherit
+			_, _, errno = unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFsSetXattr, uintptr(unsafe.Pointer(&attr)))
+		}
+		f.Close()
+		if errno != 0 {
+			return errors.Wrapf(errno, "can not set the project of %s", dir)
+		}
+	}
+
+	return errors.Wrap(quotactl(qSetQuota, q.Device, q.Project, &dqblk{
+		bhardlimit: (q.Size + 1023) / 1024,
+		bsoftlimit: (q.Size + 1023) / 1024,
+		valid:      qifBLimits,
+	}), "can not set the project quota")
+}
+
+// Mount mounts the loopback filesystem if not mounted, mounts are gone
+// after reboots.
+func (q *Quota) Mount() error {
+	if q.Disk == "" || utils.Mounted(q.Disk) {
+		return nil
+	}
+
+	out, err := exec.Command("mount", "-o", "loop", q.Image, q.Disk).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "can not mount the filesystem of the quota: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (q *Quota) Unmount() error {
+	if q.Disk == "" || !utils.Mounted(q.Disk) {
+		return nil
+	}
+
+	return unix.Unmount(q.Disk, unix.MNT_DETACH)
+}
+
+// Clear removes the limit of the project.
+func (q *Quota) Clear() error {
+	if q.Project == 0 {
+		return nil
+	}
+
+	return quotactl(qSetQuota, q.Device, q.Project, &dqblk{valid: qifBLimits})
+}
+
+// Usage returns bytes used against the quota.
+func (q *Quota) Usage() (uint64, error) {
+	if q.Project != 0 {
+		var dq dqblk
+		err := quotactl(qGetQuota, q.Device, q.Project, &dq)
+		return dq.curspace, err
+	}
+
+	var st unix.Statfs_t
+	err := unix.Statfs(q.Disk, &st)
+	if err != nil {
+		return 0, err
+	}
+
+	return (st.Blocks - st.Bfree) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,16 @@
+package meta
+
+import (
+	"testing"
+)
+
+func TestFindMount(t *testing.T) {
+	source, _, err := findMount("/proc/self")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if source != "proc" {
+		t.Fatalf("expect proc, got %s", source)
+	}
+}
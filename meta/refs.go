@@ -0,0 +1,180 @@
+package meta
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/chrootd/store"
+)
+
+// the store is opened by one process, references are changed under the
+// lock, so that rootfs are not released while being added to containers.
+var refsMu sync.Mutex
+
+// RootfsRefs tracks containers using rootfs in the store shared by managers
+// of a node. references are keyed by rootid/cntrid, and the key of rootid
+// records the time of the last change.
+type RootfsRefs struct {
+	s store.Store
+}
+
+type rootfsRecord struct {
+	Time time.Time `json:"time"`
+	// whether containers have used the rootfs
+	Used bool `json:"used"`
+}
+
+func loadRootfsRecord(b []byte) *rootfsRecord {
+	res := &rootfsRecord{}
+	if json.Unmarshal(b, res) != nil {
+		// records of older daemons are times
+		res.Time.UnmarshalText(b)
+	}
+	return res
+}
+
+func NewRootfsRefs(s store.Store) (*RootfsRefs, error) {
+	refs, err := store.NewWrapStore("rootfsref", s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RootfsRefs{s: refs}, nil
+}
+
+// Add fails if the rootfs is not tracked, or has been released.
+func (r *RootfsRefs) Add(rootid, cntrid string) error {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	if ok, _ := r.s.Has(rootid); !ok {
+		return errors.Errorf("rootfs %s does not exist", rootid)
+	}
+
+	k := path.Join(rootid, cntrid)
+	if ok, _ := r.s.Has(k); ok {
+		return nil
+	}
+
+	err := r.s.Put(k, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	return r.touch(rootid, true)
+}
+
+func (r *RootfsRefs) Remove(rootid, cntrid string) error {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	k := path.Join(rootid, cntrid)
+	idx, _, err := r.s.Get(k)
+	if err != nil {
+		return nil
+	}
+
+	err = r.s.Delete(k, idx)
+	if err != nil {
+		return err
+	}
+
+	// released rootfs are not tracked again
+	if ok, _ := r.s.Has(rootid); !ok {
+		return nil
+	}
+
+	return r.touch(rootid, true)
+}
+
+// Touch tracks the rootfs, or updates the time of the last change.
+func (r *RootfsRefs) Touch(rootid string) error {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	return r.touch(rootid, false)
+}
+
+func (r *RootfsRefs) touch(rootid string, used bool) error {
+	rec := &rootfsRecord{}
+	idx, b, err := r.s.Get(rootid)
+	if err != nil {
+		idx = 0
+	} else {
+		rec = loadRootfsRecord(b)
+	}
+
+	rec.Time = time.Now()
+	rec.Used = rec.Used || used
+
+	b, err = json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return r.s.Put(rootid, idx, b)
+}
+
+// Used returns whether containers have used the rootfs.
+func (r *RootfsRefs) Used(rootid string) bool {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	_, b, err := r.s.Get(rootid)
+	return err == nil && loadRootfsRecord(b).Used
+}
+
+// Users returns containers using the rootfs, and the time of the last
+// change, which is zero if the rootfs is not tracked.
+func (r *RootfsRefs) Users(rootid string) ([]string, time.Time, error) {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	return r.users(rootid)
+}
+
+func (r *RootfsRefs) users(rootid string) ([]string, time.Time, error) {
+	res := []string{}
+	err := r.s.List(rootid+"/", func(k string, idx uint64, v []byte) error {
+		if strings.HasPrefix(k, rootid+"/") {
+			res = append(res, strings.TrimPrefix(k, rootid+"/"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var t time.Time
+	if _, b, err := r.s.Get(rootid); err == nil {
+		t = loadRootfsRecord(b).Time
+	}
+
+	return res, t, nil
+}
+
+// Release stops tracking the rootfs, it fails if the rootfs is in use.
+func (r *RootfsRefs) Release(rootid string) error {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	cntrs, _, err := r.users(rootid)
+	if err != nil {
+		return err
+	}
+
+	if len(cntrs) > 0 {
+		return errors.Errorf("rootfs %s is used by containers %s", rootid, strings.Join(cntrs, ", "))
+	}
+
+	idx, _, err := r.s.Get(rootid)
+	if err != nil {
+		return nil
+	}
+
+	return r.s.Delete(rootid, idx)
+}
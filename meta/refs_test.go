@@ -0,0 +1,82 @@
+package meta
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xhebox/chrootd/store"
+)
+
+func TestRootfsRefsRelease(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := store.NewBolt(filepath.Join(dir, "s"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	refs, err := NewRootfsRefs(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = refs.Add("rootfs", "cntr")
+	if err == nil {
+		t.Fatal("expect untracked rootfs to be refused")
+	}
+
+	err = refs.Touch("rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if refs.Used("rootfs") {
+		t.Fatal("expect rootfs not used by containers")
+	}
+
+	err = refs.Add("rootfs", "cntr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !refs.Used("rootfs") {
+		t.Fatal("expect rootfs used by containers")
+	}
+
+	err = refs.Release("rootfs")
+	if err == nil {
+		t.Fatal("expect rootfs in use to be kept")
+	}
+
+	err = refs.Remove("rootfs", "cntr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = refs.Release("rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// containers can not be created on released rootfs
+	err = refs.Add("rootfs", "cntr2")
+	if err == nil {
+		t.Fatal("expect released rootfs to be refused")
+	}
+
+	cntrs, tm, err := refs.Users("rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cntrs) != 0 || !tm.IsZero() {
+		t.Fatalf("expect released rootfs to be untracked, got %v %v", cntrs, tm)
+	}
+}
@@ -0,0 +1,31 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// TempImages links images into a directory under dir, so images can be
+// created by tests without touching the originals.
+func TempImages(dir, images string) (string, error) {
+	res := filepath.Join(dir, "images")
+	err := os.MkdirAll(res, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	fis, err := ioutil.ReadDir(images)
+	if err != nil {
+		return "", err
+	}
+
+	for _, fi := range fis {
+		err := os.Symlink(filepath.Join(images, fi.Name()), filepath.Join(res, fi.Name()))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return res, nil
+}
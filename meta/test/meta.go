@@ -1,8 +1,12 @@
 package test
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	. "github.com/xhebox/chrootd/meta"
@@ -145,6 +149,82 @@ func TestMetaManagerImageDelete(mgr Manager, t *testing.T) {
 	}
 }
 
+func TestMetaManagerImagePrune(mgr Manager, t *testing.T) {
+	id, err := mgr.Create(&Metainfo{
+		Name:           "test",
+		Image:          "alpine",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mgr.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := mgr.ImagePrune(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 0 {
+		t.Fatalf("expect rootfs in the grace period to be kept, got %v", ids)
+	}
+
+	ids, err = mgr.ImagePrune(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 0 {
+		t.Fatalf("expect rootfs never used by containers to be kept, got %v", ids)
+	}
+
+	meta, err := mgr.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(meta.RootfsIds) != 1 || meta.RootfsIds[0] != rid {
+		t.Fatalf("expect rootfs %s, got %v", rid, meta.RootfsIds)
+	}
+}
+
+func TestMetaManagerDiskUsage(mgr Manager, t *testing.T) {
+	id, err := mgr.Create(&Metainfo{
+		Name:           "test",
+		Image:          "alpine",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mgr.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := map[string]int64{}
+	err = mgr.DiskUsage(context.Background(), func(u *DiskUsage) error {
+		sizes[u.Kind+"/"+u.Name] = u.Size
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size, ok := sizes[UsageRootfs+"/"+rid]; !ok || sizes[UsageMeta+"/"+id] != size {
+		t.Fatalf("expect the usage of metadata to be the one of rootfs, got %v", sizes)
+	}
+
+	if sizes[UsageImage+"/alpine"] <= 0 || sizes[UsageLayers+"/overlay"]+sizes[UsageLayers+"/fuse-overlayfs"] <= 0 {
+		t.Fatalf("expect usages of images and layers, got %v", sizes)
+	}
+}
+
 func TestMetaManagerImageList(mgr Manager, t *testing.T) {
 	id, err := mgr.Create(&Metainfo{
 		Name:           "test",
@@ -180,3 +260,208 @@ func TestMetaManagerImageAvailable(mgr Manager, t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestMetaManagerImageCommit(mgr Manager, t *testing.T) {
+	id, err := mgr.Create(&Metainfo{
+		Name:           "test",
+		Image:          "busybox",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rid, err := mgr.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mgr.ImageCommit(context.Background(), id, rid, "commit", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new image is unpacked as others
+	nid, err := mgr.Create(&Metainfo{
+		Name:           "test",
+		Image:          "commit",
+		ImageReference: "v1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mgr.ImageUnpack(context.Background(), nid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mgr.ImageCommit(context.Background(), id, "34", "commit", "v2")
+	if err == nil {
+		t.Fatal("expect an error for a wrong rootfs id")
+	}
+
+	err = mgr.ImageCommit(context.Background(), id, rid, "../commit", "v2")
+	if err == nil {
+		t.Fatal("expect an error for an invalid image name")
+	}
+
+	err = mgr.ImageCommit(context.Background(), id, rid, "commit", "")
+	if err == nil {
+		t.Fatal("expect an error for an invalid reference")
+	}
+}
+
+func TestMetaManagerImageImportExport(mgr Manager, t *testing.T) {
+	rd, err := mgr.ImageExport("", "busybox", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, rd)
+	rd.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mgr.ImageImport(context.Background(), "", "import", bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	err = mgr.ImageAvailable(context.Background(), func(id string, name string, refs []string) error {
+		if name == "import" {
+			found = len(refs) == 1 && refs[0] == "latest"
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found {
+		t.Fatal("expect the imported image to be available")
+	}
+
+	// the imported image is unpacked as others
+	id, err := mgr.Create(&Metainfo{
+		Name:           "test",
+		Image:          "import",
+		ImageReference: "latest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mgr.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mgr.ImageImport(context.Background(), "", "import2", bytes.NewReader([]byte("not an archive")))
+	if err == nil {
+		t.Fatal("expect an error for an invalid archive")
+	}
+
+	err = mgr.ImageImport(context.Background(), "", "../import", bytes.NewReader(buf.Bytes()))
+	if err == nil {
+		t.Fatal("expect an error for an invalid image name")
+	}
+
+	rd, err = mgr.ImageExport("", "busybox", "nonexist")
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, rd)
+		rd.Close()
+	}
+	if err == nil {
+		t.Fatal("expect an error for a non-exist reference")
+	}
+}
+
+func TestMetaManagerImagePull(mgr Manager, images string, t *testing.T) {
+	reg := NewRegistry(images)
+	defer reg.Close()
+
+	// the index is resolved, and blobs are resumed after cut
+	err := mgr.ImagePull(context.Background(), &PullOptions{
+		Image:     "pull",
+		Reference: reg.Host() + "/library/busybox:multi",
+		PlainHTTP: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	err = mgr.ImageAvailable(context.Background(), func(id string, name string, refs []string) error {
+		if name == "pull" {
+			found = len(refs) == 1 && refs[0] == "multi"
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found {
+		t.Fatal("expect the pulled image to be available")
+	}
+
+	id, err := mgr.Create(&Metainfo{
+		Name:           "test",
+		Image:          "pull",
+		ImageReference: "multi",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mgr.ImageUnpack(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := reg.Digest("busybox", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mgr.ImagePull(context.Background(), &PullOptions{
+		Image:     "pull",
+		Reference: reg.Host() + "/library/busybox@" + d.String(),
+		PlainHTTP: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mgr.ImagePull(context.Background(), &PullOptions{
+		Image:     "pull",
+		Reference: reg.Host() + "/library/busybox:multi",
+		Platform:  "plan9/mips",
+		PlainHTTP: true,
+	})
+	if err == nil {
+		t.Fatal("expect an error for a missing manifest")
+	}
+
+	err = mgr.ImagePull(context.Background(), &PullOptions{
+		Image:     "pull",
+		Reference: reg.Host() + "/library/busybox:multi",
+		Platform:  "windows/amd64",
+		PlainHTTP: true,
+	})
+	if err == nil {
+		t.Fatal("expect an error for a missing platform")
+	}
+
+	err = mgr.ImagePull(context.Background(), &PullOptions{
+		Reference: reg.Host() + "/library/busybox:nonexist",
+		PlainHTTP: true,
+	})
+	if err == nil {
+		t.Fatal("expect an error for a non-exist tag")
+	}
+}
@@ -0,0 +1,53 @@
+package test
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Unmount detaches mounts under dir, rootfs are left mounted by managers.
+func Unmount(dir string) error {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	mnts := []string{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 {
+			continue
+		}
+
+		mnt := strings.Replace(fields[4], `\040`, " ", -1)
+		if strings.HasPrefix(mnt, dir+"/") {
+			mnts = append(mnts, mnt)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	// children first
+	sort.Sort(sort.Reverse(sort.StringSlice(mnts)))
+	for _, mnt := range mnts {
+		err := unix.Unmount(mnt, unix.MNT_DETACH)
+		if err != nil && err != unix.EINVAL {
+			return err
+		}
+	}
+
+	return nil
+}
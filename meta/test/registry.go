@@ -0,0 +1,178 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const registryToken = "token"
+
+// Registry is a stand-in of a distribution registry. repositories are oci
+// layouts of the directory named by their last components. tokens are
+// required, the reference multi is an index of latest for the platform of
+// tests, and the first download of every blob is cut in half.
+type Registry struct {
+	*httptest.Server
+	images string
+	mu     sync.Mutex
+	cut    map[string]bool
+}
+
+func NewRegistry(images string) *Registry {
+	r := &Registry{images: images, cut: map[string]bool{}}
+	r.Server = httptest.NewServer(r)
+	return r
+}
+
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// Digest returns the digest of the manifest of a reference.
+func (r *Registry) Digest(repo, ref string) (digest.Digest, error) {
+	desc, err := r.resolve(repo, ref)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
+}
+
+func (r *Registry) index(repo string) (*ispec.Index, error) {
+	b, err := ioutil.ReadFile(filepath.Join(r.images, path.Base(repo), "index.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	index := &ispec.Index{}
+	return index, json.Unmarshal(b, index)
+}
+
+func (r *Registry) resolve(repo, ref string) (*ispec.Descriptor, error) {
+	index, err := r.index(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Annotations[ispec.AnnotationRefName] == ref || desc.Digest.String() == ref {
+			return &desc, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+func (r *Registry) blob(repo string, d digest.Digest) string {
+	return filepath.Join(r.images, path.Base(repo), "blobs", d.Algorithm().String(), d.Hex())
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	if ref == "multi" {
+		desc, err := r.resolve(repo, "latest")
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+
+		b, _ := json.Marshal(ispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Manifests: []ispec.Descriptor{
+				{
+					MediaType: ispec.MediaTypeImageManifest,
+					Digest:    digest.FromString("other"),
+					Size:      1,
+					Platform:  &ispec.Platform{OS: "plan9", Architecture: "mips"},
+				},
+				{
+					MediaType: desc.MediaType,
+					Digest:    desc.Digest,
+					Size:      desc.Size,
+					Platform:  &ispec.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH},
+				},
+			},
+		})
+
+		w.Header().Set("Content-Type", ispec.MediaTypeImageIndex)
+		w.Write(b)
+		return
+	}
+
+	desc, err := r.resolve(repo, ref)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	b, err := ioutil.ReadFile(r.blob(repo, desc.Digest))
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Write(b)
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	d, err := digest.Parse(ref)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	b, err := ioutil.ReadFile(r.blob(repo, d))
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	r.mu.Lock()
+	cut := !r.cut[ref]
+	r.cut[ref] = true
+	r.mu.Unlock()
+
+	if cut {
+		w.Header().Set("Content-Length", fmt.Sprint(len(b)))
+		w.Write(b[:len(b)/2])
+		panic(http.ErrAbortHandler)
+	}
+
+	http.ServeContent(w, req, "", time.Time{}, strings.NewReader(string(b)))
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		json.NewEncoder(w).Encode(map[string]string{"token": registryToken})
+		return
+	}
+
+	if req.Header.Get("Authorization") != "Bearer "+registryToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch i := strings.LastIndex(p, "/"); {
+	case strings.HasSuffix(p[:i+1], "/manifests/"):
+		r.serveManifest(w, req, strings.TrimSuffix(p[:i+1], "/manifests/"), p[i+1:])
+	case strings.HasSuffix(p[:i+1], "/blobs/"):
+		r.serveBlob(w, req, strings.TrimSuffix(p[:i+1], "/blobs/"), p[i+1:])
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -2,6 +2,8 @@ package meta
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer/configs"
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -17,6 +19,45 @@ type PathMask struct {
 	Path string `json:"path"`
 }
 
+const (
+	NetworkNone   = "none"
+	NetworkHost   = "host"
+	NetworkBridge = "bridge"
+	NetworkSlirp  = "slirp"
+)
+
+type Route struct {
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway"`
+}
+
+// an empty mode means host networking for rootless daemons, none otherwise.
+// slirp mode works without root, the traffic is forwarded by the daemon.
+// addresses and routes are only applied in bridge mode, addresses are CIDRs
+// and allocated from the bridge subnet if empty. the gateway of slirp mode
+// reaches the loopback of the host only with host loopback.
+type Network struct {
+	Mode         string   `json:"mode"`
+	Addresses    []string `json:"addresses"`
+	Routes       []Route  `json:"routes"`
+	HostLoopback bool     `json:"hostLoopback"`
+}
+
+const (
+	ProtoTCP = "tcp"
+	ProtoUDP = "udp"
+)
+
+// an empty protocol means tcp.
+type PortMapping struct {
+	HostPort      uint16 `json:"hostPort"`
+	ContainerPort uint16 `json:"containerPort"`
+	Proto         string `json:"proto"`
+}
+
+// a nil seccomp profile means the default profile of the daemon, and an
+// empty profile means unconfined. a zero disk quota means the writable layer
+// of rootfs is unlimited.
 type Metainfo struct {
 	Id             string               `json:"id"`
 	Name           string               `json:"name"`
@@ -31,6 +72,41 @@ type Metainfo struct {
 	Capabilities   configs.Capabilities `json:"capabilities"`
 	Rlimits        []specs.POSIXRlimit  `json:"rlimits"`
 	RootfsIds      []string             `json:"rootfsIds"`
+	Network        Network              `json:"network"`
+	Ports          []PortMapping        `json:"ports"`
+	Seccomp        *specs.LinuxSeccomp  `json:"seccomp"`
+	DiskQuota      uint64               `json:"diskQuota"`
+}
+
+// reference is of form registry/repository:tag or @digest, the image name
+// defaults to the last component of the repository. platform is of form
+// os/arch[/variant], and defaults to the one of the daemon. node suggests
+// which node the image will be pulled on.
+type PullOptions struct {
+	Node      string `json:"node"`
+	Image     string `json:"image"`
+	Reference string `json:"reference"`
+	Platform  string `json:"platform"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	PlainHTTP bool   `json:"plainHTTP"`
+}
+
+const (
+	UsageRootfs = "rootfs"
+	UsageMeta   = "meta"
+	UsageImage  = "image"
+	UsageLayers = "layers"
+)
+
+// DiskUsage is the bytes taken on a node. rootfs are counted by their own
+// changes, metadata by their rootfs, and layers unpacked from images are
+// counted as a whole.
+type DiskUsage struct {
+	Node string `json:"node"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
 }
 
 type Manager interface {
@@ -44,7 +120,26 @@ type Manager interface {
 
 	ImageUnpack(context.Context, string) (string, error)
 	ImageDelete(string, string) error
+	// ImageCommit diffs the rootfs against the image it was unpacked from,
+	// and tags the image with the new layer by a reference. the image of
+	// metadata is used if the image name is empty.
+	ImageCommit(ctx context.Context, metaid, rootid, image, ref string) error
+	// ImageImport imports images of a docker-archive or an oci-archive
+	// into the image, references of the same name are replaced. node
+	// suggests which node the image will be imported on.
+	ImageImport(ctx context.Context, node, image string, rd io.Reader) error
+	// ImageExport exports a reference of the image on the node as an
+	// oci-archive.
+	ImageExport(node, image, ref string) (io.ReadCloser, error)
+	// ImagePull pulls an image from a registry, tagged by the tag of the
+	// reference, or latest.
+	ImagePull(context.Context, *PullOptions) error
+	// ImagePrune removes rootfs not used by any container for the grace
+	// period, and rootfs directories without metadata. removed rootfs are
+	// returned.
+	ImagePrune(grace time.Duration) ([]string, error)
 	ImageList(string, func(string) error) error
+	DiskUsage(context.Context, func(*DiskUsage) error) error
 	ImageAvailable(context.Context, func(string, string, []string) error) error
 
 	Close() error
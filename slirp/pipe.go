@@ -0,0 +1,61 @@
+package slirp
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pipeConn is an end of a synchronous pipe like net.Pipe, but can be
+// half closed.
+type pipeConn struct {
+	r      *io.PipeReader
+	w      *io.PipeWriter
+	local  net.Addr
+	remote net.Addr
+}
+
+func newPipe(a, b net.Addr) (*pipeConn, *pipeConn) {
+	ar, bw := io.Pipe()
+	br, aw := io.Pipe()
+	return &pipeConn{r: ar, w: aw, local: a, remote: b}, &pipeConn{r: br, w: bw, local: b, remote: a}
+}
+
+func (p *pipeConn) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *pipeConn) Write(b []byte) (int, error) {
+	return p.w.Write(b)
+}
+
+func (p *pipeConn) CloseWrite() error {
+	return p.w.Close()
+}
+
+func (p *pipeConn) Close() error {
+	p.w.Close()
+	return p.r.Close()
+}
+
+func (p *pipeConn) LocalAddr() net.Addr {
+	return p.local
+}
+
+func (p *pipeConn) RemoteAddr() net.Addr {
+	return p.remote
+}
+
+func (p *pipeConn) SetDeadline(t time.Time) error {
+	return errors.New("deadline is not supported")
+}
+
+func (p *pipeConn) SetReadDeadline(t time.Time) error {
+	return errors.New("deadline is not supported")
+}
+
+func (p *pipeConn) SetWriteDeadline(t time.Time) error {
+	return errors.New("deadline is not supported")
+}
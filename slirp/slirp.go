@@ -0,0 +1,369 @@
+package slirp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+
+	protoICMP = 1
+	protoTCP  = 6
+	protoUDP  = 17
+)
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// the layout follows slirp, queries to the dns address are forwarded to the
+// resolver of the host. the gateway is the loopback of the host only if
+// HostLoopback is set, or services listening on the loopback of the host,
+// which are not meant to be public, would be exposed to guests.
+type Config struct {
+	Subnet       *net.IPNet
+	Gateway      net.IP
+	DNS          net.IP
+	Guest        net.IP
+	MTU          int
+	Resolver     string
+	HostLoopback bool
+}
+
+func DefaultConfig() *Config {
+	_, subnet, _ := net.ParseCIDR("10.0.2.0/24")
+	return &Config{
+		Subnet:   subnet,
+		Gateway:  net.IPv4(10, 0, 2, 2).To4(),
+		DNS:      net.IPv4(10, 0, 2, 3).To4(),
+		Guest:    net.IPv4(10, 0, 2, 100).To4(),
+		MTU:      1500,
+		Resolver: hostResolver(),
+	}
+}
+
+func hostResolver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			if ip := net.ParseIP(fields[1]); ip != nil && ip.To4() != nil {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+
+	return "127.0.0.1:53"
+}
+
+// Stack is a userspace network stack serving the ethernet frames of a tap
+// device, connections of the guest are made by sockets of the host.
+type Stack struct {
+	cfg Config
+	dev io.ReadWriteCloser
+	mac net.HardwareAddr
+
+	wmu sync.Mutex
+	buf []byte
+
+	mu       sync.Mutex
+	guestMAC net.HardwareAddr
+	ipid     uint16
+	port     uint16
+	udp      map[flowKey]*udpFlow
+	tcp      map[flowKey]*tcpConn
+	closed   bool
+	done     chan struct{}
+}
+
+// flowKey is seen from the guest, remote is the address the guest talks to.
+type flowKey struct {
+	guestPort  uint16
+	remoteIP   [4]byte
+	remotePort uint16
+}
+
+func New(dev io.ReadWriteCloser, cfg *Config) *Stack {
+	return &Stack{
+		cfg:  *cfg,
+		dev:  dev,
+		mac:  net.HardwareAddr{0x52, 0x55, 0x0a, 0x00, 0x02, 0x02},
+		buf:  make([]byte, 14+cfg.MTU),
+		port: 49152 + uint16(rand.Intn(8192)),
+		udp:  make(map[flowKey]*udpFlow),
+		tcp:  make(map[flowKey]*tcpConn),
+		done: make(chan struct{}),
+	}
+}
+
+// Serve handles frames until the device is closed.
+func (s *Stack) Serve() error {
+	frame := make([]byte, 14+s.cfg.MTU+4)
+	for {
+		n, err := s.dev.Read(frame)
+		if err != nil {
+			s.Close()
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		if n < 14 {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.guestMAC == nil {
+			s.guestMAC = append(net.HardwareAddr{}, frame[6:12]...)
+		}
+		s.mu.Unlock()
+
+		switch binary.BigEndian.Uint16(frame[12:14]) {
+		case etherTypeARP:
+			s.handleARP(frame[14:n])
+		case etherTypeIPv4:
+			s.handleIPv4(frame[14:n])
+		}
+	}
+}
+
+func (s *Stack) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+
+	udps := make([]*udpFlow, 0, len(s.udp))
+	for _, f := range s.udp {
+		udps = append(udps, f)
+	}
+	tcps := make([]*tcpConn, 0, len(s.tcp))
+	for _, c := range s.tcp {
+		tcps = append(tcps, c)
+	}
+	s.mu.Unlock()
+
+	for _, f := range udps {
+		f.close()
+	}
+	for _, c := range tcps {
+		c.abort(false)
+	}
+
+	return s.dev.Close()
+}
+
+func (s *Stack) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// allocPort returns a source port for connections made into the guest.
+func (s *Stack) allocPort() uint16 {
+	s.port++
+	if s.port < 49152 {
+		s.port = 49152
+	}
+	return s.port
+}
+
+// hostAddr translates the destination of the guest into an address of the host.
+func (s *Stack) hostAddr(ip net.IP, port uint16, proto int) (string, error) {
+	switch {
+	case ip.Equal(s.cfg.Gateway):
+		if !s.cfg.HostLoopback {
+			return "", errors.New("the loopback of the host is disabled")
+		}
+		ip = net.IPv4(127, 0, 0, 1)
+	case ip.IsLoopback():
+		return "", errors.New("the loopback of the host is not routable")
+	case ip.Equal(s.cfg.DNS):
+		if proto != protoUDP || port != 53 {
+			return "", errors.New("only dns is served")
+		}
+		return s.cfg.Resolver, nil
+	case s.cfg.Subnet.Contains(ip):
+		return "", errors.New("no such host in the subnet")
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), nil
+}
+
+func (s *Stack) handleARP(pkt []byte) {
+	if len(pkt) < 28 {
+		return
+	}
+
+	// ethernet, ipv4 and request only
+	if binary.BigEndian.Uint16(pkt[0:2]) != 1 || binary.BigEndian.Uint16(pkt[2:4]) != etherTypeIPv4 || binary.BigEndian.Uint16(pkt[6:8]) != 1 {
+		return
+	}
+
+	sha := net.HardwareAddr(pkt[8:14])
+	spa := net.IP(pkt[14:18])
+	tpa := net.IP(pkt[24:28])
+	if !s.cfg.Subnet.Contains(tpa) || tpa.Equal(s.cfg.Guest) {
+		return
+	}
+
+	s.mu.Lock()
+	s.guestMAC = append(net.HardwareAddr{}, sha...)
+	s.mu.Unlock()
+
+	reply := make([]byte, 28)
+	copy(reply, pkt[:6])
+	binary.BigEndian.PutUint16(reply[6:8], 2)
+	copy(reply[8:14], s.mac)
+	copy(reply[14:18], tpa)
+	copy(reply[18:24], sha)
+	copy(reply[24:28], spa)
+	s.writeFrame(etherTypeARP, reply)
+}
+
+func (s *Stack) writeFrame(typ uint16, payload []byte) error {
+	s.mu.Lock()
+	dst := s.guestMAC
+	s.mu.Unlock()
+	if dst == nil {
+		dst = broadcastMAC
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	frame := s.buf[:14+len(payload)]
+	copy(frame[0:6], dst)
+	copy(frame[6:12], s.mac)
+	binary.BigEndian.PutUint16(frame[12:14], typ)
+	copy(frame[14:], payload)
+
+	_, err := s.dev.Write(frame)
+	return err
+}
+
+func (s *Stack) handleIPv4(pkt []byte) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 {
+		return
+	}
+
+	ihl := int(pkt[0]&0xf) * 4
+	total := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if ihl < 20 || total < ihl || total > len(pkt) {
+		return
+	}
+
+	// fragments are not supported, the mtu is respected by the guest
+	if binary.BigEndian.Uint16(pkt[6:8])&0x3fff != 0 {
+		return
+	}
+
+	src := net.IP(pkt[12:16])
+	dst := net.IP(pkt[16:20])
+	if !src.Equal(s.cfg.Guest) {
+		return
+	}
+
+	payload := pkt[ihl:total]
+	switch pkt[9] {
+	case protoICMP:
+		s.handleICMP(dst, payload)
+	case protoUDP:
+		s.handleUDP(dst, payload)
+	case protoTCP:
+		s.handleTCP(dst, payload)
+	}
+}
+
+// writeIPv4 sends a packet to the guest.
+func (s *Stack) writeIPv4(proto uint8, src net.IP, payload []byte) error {
+	s.mu.Lock()
+	s.ipid++
+	id := s.ipid
+	s.mu.Unlock()
+
+	pkt := make([]byte, 20+len(payload))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	binary.BigEndian.PutUint16(pkt[4:6], id)
+	// do not fragment
+	binary.BigEndian.PutUint16(pkt[6:8], 0x4000)
+	pkt[8] = 64
+	pkt[9] = proto
+	copy(pkt[12:16], src.To4())
+	copy(pkt[16:20], s.cfg.Guest.To4())
+	binary.BigEndian.PutUint16(pkt[10:12], checksum(pkt[:20], 0))
+	copy(pkt[20:], payload)
+
+	return s.writeFrame(etherTypeIPv4, pkt)
+}
+
+func (s *Stack) handleICMP(dst net.IP, pkt []byte) {
+	// only echo requests to the virtual hosts are answered
+	if len(pkt) < 8 || pkt[0] != 8 || !(dst.Equal(s.cfg.Gateway) || dst.Equal(s.cfg.DNS)) {
+		return
+	}
+
+	reply := append([]byte{}, pkt...)
+	reply[0] = 0
+	reply[2], reply[3] = 0, 0
+	binary.BigEndian.PutUint16(reply[2:4], checksum(reply, 0))
+	s.writeIPv4(protoICMP, dst, reply)
+}
+
+func checksum(b []byte, sum uint32) uint16 {
+	for len(b) >= 2 {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// pseudoSum is the sum of the pseudo header of tcp and udp.
+func pseudoSum(src, dst net.IP, proto uint8, length int) uint32 {
+	var sum uint32
+	src, dst = src.To4(), dst.To4()
+	sum += uint32(src[0])<<8 | uint32(src[1])
+	sum += uint32(src[2])<<8 | uint32(src[3])
+	sum += uint32(dst[0])<<8 | uint32(dst[1])
+	sum += uint32(dst[2])<<8 | uint32(dst[3])
+	sum += uint32(proto)
+	sum += uint32(length)
+	return sum
+}
+
+func toKey(ip net.IP) [4]byte {
+	var k [4]byte
+	copy(k[:], ip.To4())
+	return k
+}
@@ -0,0 +1,393 @@
+package slirp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// loopbackConfig lets the gateway reach listeners of tests on the loopback.
+func loopbackConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.HostLoopback = true
+	return cfg
+}
+
+// withGuest runs f in a new network namespace, connected to a stack by a
+// tap device. the namespace is bound to the locked thread of f.
+func withGuest(t *testing.T, cfg *Config, f func(*Stack) error) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating network namespaces needs root")
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		// never unlocked, the thread exits with the namespace
+		runtime.LockOSThread()
+
+		errc <- func() error {
+			err := unix.Unshare(unix.CLONE_NEWNET)
+			if err != nil {
+				return err
+			}
+
+			tap, err := OpenTap("tap0")
+			if err != nil {
+				return err
+			}
+
+			lo, err := netlink.LinkByName("lo")
+			if err != nil {
+				return err
+			}
+
+			err = netlink.LinkSetUp(lo)
+			if err != nil {
+				return err
+			}
+
+			link, err := netlink.LinkByName("tap0")
+			if err != nil {
+				return err
+			}
+
+			err = netlink.AddrAdd(link, &netlink.Addr{IPNet: &net.IPNet{IP: cfg.Guest, Mask: cfg.Subnet.Mask}})
+			if err != nil {
+				return err
+			}
+
+			err = netlink.LinkSetUp(link)
+			if err != nil {
+				return err
+			}
+
+			err = netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: cfg.Gateway})
+			if err != nil {
+				return err
+			}
+
+			s := New(tap, cfg)
+			go s.Serve()
+			defer s.Close()
+
+			return f(s)
+		}()
+	}()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func gatewayAddr(addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	return net.JoinHostPort("10.0.2.2", port)
+}
+
+func TestTCP(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	data := make([]byte, 1<<20)
+	rand.Read(data)
+
+	withGuest(t, loopbackConfig(), func(s *Stack) error {
+		// case 1: data is echoed by the host
+		conn, err := net.Dial("tcp4", gatewayAddr(l.Addr()))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		errc := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(data)
+			if err == nil {
+				err = conn.(*net.TCPConn).CloseWrite()
+			}
+			errc <- err
+		}()
+
+		b, err := ioutil.ReadAll(conn)
+		if err != nil {
+			return err
+		}
+
+		if err := <-errc; err != nil {
+			return err
+		}
+
+		if !bytes.Equal(b, data) {
+			t.Errorf("unexpected echo of %d bytes", len(b))
+		}
+
+		// case 2: closed ports are refused
+		closed, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		closed.Close()
+
+		_, err = net.Dial("tcp4", gatewayAddr(closed.Addr()))
+		if err == nil {
+			t.Error("expect error for a closed port")
+		}
+
+		return nil
+	})
+}
+
+func TestUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	withGuest(t, loopbackConfig(), func(s *Stack) error {
+		conn, err := net.Dial("udp4", gatewayAddr(pc.LocalAddr()))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 2048)
+		for i := 0; i < 3; i++ {
+			msg := []byte("ping " + strconv.Itoa(i))
+			_, err := conn.Write(msg)
+			if err != nil {
+				return err
+			}
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return err
+			}
+
+			if !bytes.Equal(buf[:n], msg) {
+				t.Errorf("unexpected reply %q", buf[:n])
+			}
+		}
+
+		return nil
+	})
+}
+
+func TestDialTCP(t *testing.T) {
+	withGuest(t, loopbackConfig(), func(s *Stack) error {
+		l, err := net.Listen("tcp4", "0.0.0.0:8080")
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			// the peer should be the gateway
+			if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "10.0.2.2" {
+				conn.Write([]byte("unexpected peer " + host))
+			} else {
+				io.Copy(conn, conn)
+			}
+			conn.Close()
+		}()
+
+		// case 1: data is echoed by the guest
+		conn, err := s.DialTCP(8080)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		go func() {
+			conn.Write([]byte("hello"))
+			conn.(interface{ CloseWrite() error }).CloseWrite()
+		}()
+
+		// the guest closes after the half close
+		b, err := ioutil.ReadAll(conn)
+		if err != nil {
+			return err
+		}
+
+		if string(b) != "hello" {
+			t.Errorf("unexpected echo %q", b)
+		}
+
+		// case 2: closed ports are refused
+		_, err = s.DialTCP(8081)
+		if err == nil {
+			t.Error("expect error for a closed port")
+		}
+
+		return nil
+	})
+}
+
+func TestDialUDP(t *testing.T) {
+	withGuest(t, loopbackConfig(), func(s *Stack) error {
+		pc, err := net.ListenPacket("udp4", "0.0.0.0:5353")
+		if err != nil {
+			return err
+		}
+		defer pc.Close()
+
+		go func() {
+			buf := make([]byte, 2048)
+			for {
+				n, addr, err := pc.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+
+				// the peer should be the gateway
+				if host, _, _ := net.SplitHostPort(addr.String()); host != "10.0.2.2" {
+					pc.WriteTo([]byte("unexpected peer "+host), addr)
+				} else {
+					pc.WriteTo(buf[:n], addr)
+				}
+			}
+		}()
+
+		// case 1: datagrams are echoed by the guest
+		conn, err := s.DialUDP(5353)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("ping"))
+		if err != nil {
+			return err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 2048)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		if string(buf[:n]) != "ping" {
+			t.Errorf("unexpected reply %q", buf[:n])
+		}
+
+		// case 2: reads time out
+		conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		_, err = conn.Read(buf)
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("expect timeout, got %v", err)
+		}
+
+		return nil
+	})
+}
+
+func TestICMP(t *testing.T) {
+	withGuest(t, loopbackConfig(), func(s *Stack) error {
+		conn, err := net.Dial("ip4:icmp", "10.0.2.2")
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		req := []byte{8, 0, 0, 0, 0, 1, 0, 1, 'p', 'i', 'n', 'g'}
+		sum := checksum(req, 0)
+		req[2], req[3] = byte(sum>>8), byte(sum)
+
+		_, err = conn.Write(req)
+		if err != nil {
+			return err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1500)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		// raw sockets receive the ip header too
+		reply := buf[20:n]
+		if reply[0] != 0 || !bytes.Equal(reply[4:], req[4:]) {
+			t.Errorf("unexpected reply %v", reply)
+		}
+
+		return nil
+	})
+}
+
+func TestHostLoopback(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	withGuest(t, DefaultConfig(), func(s *Stack) error {
+		_, err := net.DialTimeout("tcp4", gatewayAddr(l.Addr()), 5*time.Second)
+		if err == nil {
+			t.Error("expect the loopback of the host to be unreachable by default")
+		}
+
+		return nil
+	})
+}
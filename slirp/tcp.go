@@ -0,0 +1,648 @@
+package slirp
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+const (
+	tcpDialTimeout = 10 * time.Second
+	// data from the host waiting for acks of the guest
+	tcpSendBuffer = 256 << 10
+	// data from the guest waiting to be written to the host, it is also
+	// the window, and window scaling is not supported
+	tcpRecvBuffer = 0xffff
+	tcpMinRTO     = 200 * time.Millisecond
+	tcpMaxRTO     = 3 * time.Second
+	tcpRetries    = 12
+)
+
+type tcpState int
+
+const (
+	tcpSynSent tcpState = iota
+	tcpSynRcvd
+	tcpEstablished
+	tcpClosed
+)
+
+type tcpSegment struct {
+	srcPort uint16
+	dstPort uint16
+	seq     uint32
+	ack     uint32
+	flags   uint8
+	window  uint16
+	mss     int
+	payload []byte
+}
+
+func parseTCP(pkt []byte) (*tcpSegment, bool) {
+	if len(pkt) < 20 {
+		return nil, false
+	}
+
+	off := int(pkt[12]>>4) * 4
+	if off < 20 || off > len(pkt) {
+		return nil, false
+	}
+
+	seg := &tcpSegment{
+		srcPort: binary.BigEndian.Uint16(pkt[0:2]),
+		dstPort: binary.BigEndian.Uint16(pkt[2:4]),
+		seq:     binary.BigEndian.Uint32(pkt[4:8]),
+		ack:     binary.BigEndian.Uint32(pkt[8:12]),
+		flags:   pkt[13],
+		window:  binary.BigEndian.Uint16(pkt[14:16]),
+		payload: pkt[off:],
+	}
+
+	opts := pkt[20:off]
+	for len(opts) > 0 {
+		kind := opts[0]
+		if kind == 0 {
+			break
+		}
+		if kind == 1 {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || int(opts[1]) < 2 || int(opts[1]) > len(opts) {
+			break
+		}
+		if kind == 2 && opts[1] == 4 {
+			seg.mss = int(binary.BigEndian.Uint16(opts[2:4]))
+		}
+		opts = opts[opts[1]:]
+	}
+
+	return seg, true
+}
+
+// seqLess compares sequence numbers in the modular space.
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// tcpConn terminates a connection of the guest, and relays it to host,
+// which is a socket of the host, or a pipe of DialTCP.
+type tcpConn struct {
+	s    *Stack
+	key  flowKey
+	src  net.IP
+	host net.Conn
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	state  tcpState
+	err    error
+	timer  *time.Timer
+	rto    time.Duration
+	tries  int
+	mss    int
+	iss    uint32
+	sndUna uint32
+	sndNxt uint32
+	sndWnd uint32
+	// unacked data starting from sndUna
+	sndBuf    []byte
+	finQueued bool
+	finSent   bool
+	finAcked  bool
+	rcvNxt    uint32
+	rcvFin    bool
+	rcvBuf    [][]byte
+	rcvQueued int
+	// all data of the guest has been written to host
+	wdone bool
+}
+
+func (s *Stack) newTCPConn(key flowKey, src net.IP) *tcpConn {
+	c := &tcpConn{
+		s:     s,
+		key:   key,
+		src:   append(net.IP{}, src.To4()...),
+		rto:   tcpMinRTO,
+		mss:   536,
+		iss:   rand.Uint32(),
+		state: tcpSynRcvd,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	c.sndUna = c.iss
+	c.sndNxt = c.iss
+	return c
+}
+
+func (s *Stack) handleTCP(dst net.IP, pkt []byte) {
+	seg, ok := parseTCP(pkt)
+	if !ok {
+		return
+	}
+
+	key := flowKey{guestPort: seg.srcPort, remoteIP: toKey(dst), remotePort: seg.dstPort}
+
+	s.mu.Lock()
+	c, ok := s.tcp[key]
+	closed := s.closed
+	s.mu.Unlock()
+
+	if ok {
+		c.input(seg)
+		return
+	}
+
+	if closed || seg.flags&tcpRST != 0 {
+		return
+	}
+
+	if seg.flags&(tcpSYN|tcpACK) != tcpSYN {
+		s.reset(dst, seg)
+		return
+	}
+
+	addr, err := s.hostAddr(dst, seg.dstPort, protoTCP)
+	if err != nil {
+		s.reset(dst, seg)
+		return
+	}
+
+	c = s.newTCPConn(key, dst)
+	c.rcvNxt = seg.seq + 1
+	c.sndWnd = uint32(seg.window)
+	c.setMSS(seg.mss)
+
+	s.mu.Lock()
+	s.tcp[key] = c
+	s.mu.Unlock()
+
+	go c.dial(addr)
+}
+
+// reset answers segments of unknown connections.
+func (s *Stack) reset(src net.IP, seg *tcpSegment) {
+	c := &tcpConn{s: s, key: flowKey{guestPort: seg.srcPort, remotePort: seg.dstPort}, src: src}
+	if seg.flags&tcpACK != 0 {
+		c.send(tcpRST, seg.ack, 0, nil, nil)
+		return
+	}
+
+	n := uint32(len(seg.payload))
+	if seg.flags&tcpSYN != 0 {
+		n++
+	}
+	if seg.flags&tcpFIN != 0 {
+		n++
+	}
+	c.send(tcpRST|tcpACK, 0, seg.seq+n, nil, nil)
+}
+
+func (c *tcpConn) setMSS(mss int) {
+	c.mss = 536
+	if mss > 0 {
+		c.mss = mss
+	}
+	if max := c.s.cfg.MTU - 40; c.mss > max {
+		c.mss = max
+	}
+}
+
+func (c *tcpConn) mssOption() []byte {
+	opt := []byte{2, 4, 0, 0}
+	binary.BigEndian.PutUint16(opt[2:], uint16(c.s.cfg.MTU-40))
+	return opt
+}
+
+// window advertises the free space of the receive buffer.
+func (c *tcpConn) window() int {
+	return tcpRecvBuffer - c.rcvQueued
+}
+
+func (c *tcpConn) send(flags uint8, seq, ack uint32, opts, payload []byte) error {
+	hl := 20 + len(opts)
+	pkt := make([]byte, hl+len(payload))
+	binary.BigEndian.PutUint16(pkt[0:2], c.key.remotePort)
+	binary.BigEndian.PutUint16(pkt[2:4], c.key.guestPort)
+	binary.BigEndian.PutUint32(pkt[4:8], seq)
+	binary.BigEndian.PutUint32(pkt[8:12], ack)
+	pkt[12] = uint8(hl/4) << 4
+	pkt[13] = flags
+	binary.BigEndian.PutUint16(pkt[14:16], uint16(c.window()))
+	copy(pkt[20:], opts)
+	copy(pkt[hl:], payload)
+	binary.BigEndian.PutUint16(pkt[16:18], checksum(pkt, pseudoSum(c.src, c.s.cfg.Guest, protoTCP, len(pkt))))
+
+	return c.s.writeIPv4(protoTCP, c.src, pkt)
+}
+
+func (c *tcpConn) sendAck() {
+	c.send(tcpACK, c.sndNxt, c.rcvNxt, nil, nil)
+}
+
+func (c *tcpConn) dial(addr string) {
+	host, err := net.DialTimeout("tcp4", addr, tcpDialTimeout)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == tcpClosed {
+		if host != nil {
+			host.Close()
+		}
+		return
+	}
+
+	if err != nil {
+		c.send(tcpRST|tcpACK, c.iss, c.rcvNxt, nil, nil)
+		c.finish(err)
+		return
+	}
+
+	c.host = host
+	c.send(tcpSYN|tcpACK, c.iss, c.rcvNxt, c.mssOption(), nil)
+	c.sndNxt = c.iss + 1
+	c.arm()
+}
+
+// DialTCP connects to port of the guest, the connection comes from the
+// gateway. the returned conn can be half closed by CloseWrite.
+func (s *Stack) DialTCP(port uint16) (net.Conn, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errors.New("network stack is closed")
+	}
+
+	var key flowKey
+	for {
+		key = flowKey{guestPort: port, remoteIP: toKey(s.cfg.Gateway), remotePort: s.allocPort()}
+		if _, ok := s.tcp[key]; !ok {
+			break
+		}
+	}
+
+	c := s.newTCPConn(key, s.cfg.Gateway)
+	c.state = tcpSynSent
+	local, remote := newPipe(&net.TCPAddr{IP: s.cfg.Gateway, Port: int(key.remotePort)}, &net.TCPAddr{IP: s.cfg.Guest, Port: int(port)})
+	c.host = remote
+	s.tcp[key] = c
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.send(tcpSYN, c.iss, 0, c.mssOption(), nil)
+	c.sndNxt = c.iss + 1
+	c.arm()
+
+	for c.state == tcpSynSent {
+		c.cond.Wait()
+	}
+
+	if c.state == tcpClosed {
+		local.Close()
+		return nil, c.err
+	}
+
+	return local, nil
+}
+
+func (c *tcpConn) input(seg *tcpSegment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == tcpClosed {
+		return
+	}
+
+	if seg.flags&tcpRST != 0 {
+		if c.state == tcpSynSent {
+			c.finish(errors.New("connection refused"))
+		} else {
+			c.finish(errors.New("connection reset by the guest"))
+		}
+		return
+	}
+
+	switch c.state {
+	case tcpSynSent:
+		if seg.flags&(tcpSYN|tcpACK) != tcpSYN|tcpACK || seg.ack != c.iss+1 {
+			return
+		}
+
+		c.rcvNxt = seg.seq + 1
+		c.sndUna = seg.ack
+		c.sndWnd = uint32(seg.window)
+		c.setMSS(seg.mss)
+		c.establish()
+		c.sendAck()
+		return
+	case tcpSynRcvd:
+		if seg.flags&tcpSYN != 0 {
+			// the guest has not got our syn-ack yet
+			if c.host != nil {
+				c.send(tcpSYN|tcpACK, c.iss, c.rcvNxt, c.mssOption(), nil)
+			}
+			return
+		}
+
+		if seg.flags&tcpACK == 0 || c.host == nil || seg.ack != c.iss+1 {
+			return
+		}
+
+		c.sndUna = seg.ack
+		c.establish()
+	}
+
+	if seg.flags&tcpACK != 0 {
+		c.acked(seg)
+	}
+
+	if len(seg.payload) > 0 || seg.flags&tcpFIN != 0 {
+		if seg.seq == c.rcvNxt && !c.rcvFin {
+			// the guest respects the window, but a part is taken anyway
+			n := len(seg.payload)
+			if n > c.window() {
+				n = c.window()
+			}
+
+			if n > 0 {
+				c.rcvBuf = append(c.rcvBuf, append([]byte{}, seg.payload[:n]...))
+				c.rcvQueued += n
+				c.rcvNxt += uint32(n)
+				c.cond.Broadcast()
+			}
+
+			if n == len(seg.payload) && seg.flags&tcpFIN != 0 {
+				c.rcvFin = true
+				c.rcvNxt++
+				c.cond.Broadcast()
+			}
+		}
+
+		c.sendAck()
+	}
+
+	c.flush()
+	c.tryFinish()
+}
+
+func (c *tcpConn) tryFinish() {
+	if c.state != tcpClosed && c.wdone && c.finAcked {
+		c.finish(nil)
+	}
+}
+
+func (c *tcpConn) establish() {
+	c.state = tcpEstablished
+	c.tries = 0
+	c.rto = tcpMinRTO
+	c.cond.Broadcast()
+
+	go c.readHost()
+	go c.writeHost()
+}
+
+func (c *tcpConn) acked(seg *tcpSegment) {
+	c.sndWnd = uint32(seg.window)
+
+	limit := c.sndUna + uint32(len(c.sndBuf))
+	if c.finQueued {
+		limit++
+	}
+	if !seqLess(c.sndUna, seg.ack) || seqLess(limit, seg.ack) {
+		return
+	}
+
+	n := int(seg.ack - c.sndUna)
+	if n > len(c.sndBuf) {
+		// the fin has been acked
+		c.finAcked = true
+		n = len(c.sndBuf)
+	}
+	c.sndBuf = c.sndBuf[n:]
+	c.sndUna = seg.ack
+	// segments sent before going back have arrived
+	if seqLess(c.sndNxt, c.sndUna) {
+		c.sndNxt = c.sndUna
+	}
+	c.tries = 0
+	c.rto = tcpMinRTO
+	c.cond.Broadcast()
+}
+
+// flush sends the buffered data allowed by the window of the guest.
+func (c *tcpConn) flush() {
+	if c.state != tcpEstablished {
+		return
+	}
+
+	for !c.finSent {
+		inflight := int(c.sndNxt - c.sndUna)
+		avail := len(c.sndBuf) - inflight
+		wnd := int(c.sndWnd) - inflight
+
+		if avail > 0 && wnd > 0 {
+			n := avail
+			if n > c.mss {
+				n = c.mss
+			}
+			if n > wnd {
+				n = wnd
+			}
+
+			c.send(tcpACK|tcpPSH, c.sndNxt, c.rcvNxt, nil, c.sndBuf[inflight:inflight+n])
+			c.sndNxt += uint32(n)
+			continue
+		}
+
+		if avail == 0 && c.finQueued {
+			c.send(tcpFIN|tcpACK, c.sndNxt, c.rcvNxt, nil, nil)
+			c.sndNxt++
+			c.finSent = true
+		}
+		break
+	}
+
+	if c.sndNxt != c.sndUna || len(c.sndBuf) > 0 {
+		c.arm()
+	}
+}
+
+// arm starts the retransmission timer if it is not running.
+func (c *tcpConn) arm() {
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.rto, c.retransmit)
+	}
+}
+
+func (c *tcpConn) retransmit() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.timer = nil
+	if c.state == tcpClosed {
+		return
+	}
+
+	c.tries++
+	if c.tries > tcpRetries {
+		c.send(tcpRST, c.sndNxt, 0, nil, nil)
+		c.finish(errors.New("connection timed out"))
+		return
+	}
+
+	c.rto *= 2
+	if c.rto > tcpMaxRTO {
+		c.rto = tcpMaxRTO
+	}
+
+	switch c.state {
+	case tcpSynSent:
+		c.send(tcpSYN, c.iss, 0, c.mssOption(), nil)
+	case tcpSynRcvd:
+		c.send(tcpSYN|tcpACK, c.iss, c.rcvNxt, c.mssOption(), nil)
+	case tcpEstablished:
+		if c.sndNxt == c.sndUna && len(c.sndBuf) == 0 {
+			return
+		}
+
+		// go back to the first unacked byte
+		c.sndNxt = c.sndUna
+		c.finSent = false
+		if c.sndWnd == 0 {
+			// probe the zero window with one byte
+			c.sndWnd = 1
+		}
+		c.flush()
+		return
+	}
+
+	c.arm()
+}
+
+func (c *tcpConn) readHost() {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := c.host.Read(buf)
+
+		c.mu.Lock()
+		for c.state != tcpClosed && len(c.sndBuf) >= tcpSendBuffer {
+			c.cond.Wait()
+		}
+
+		if c.state == tcpClosed {
+			c.mu.Unlock()
+			return
+		}
+
+		c.sndBuf = append(c.sndBuf, buf[:n]...)
+		if err != nil {
+			c.finQueued = true
+		}
+		c.flush()
+		c.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *tcpConn) writeHost() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		for c.state != tcpClosed && len(c.rcvBuf) == 0 && !c.rcvFin {
+			c.cond.Wait()
+		}
+
+		if c.state == tcpClosed {
+			return
+		}
+
+		if len(c.rcvBuf) == 0 {
+			if cw, ok := c.host.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			} else {
+				c.host.Close()
+			}
+
+			c.wdone = true
+			c.tryFinish()
+			return
+		}
+
+		b := c.rcvBuf[0]
+		c.rcvBuf = c.rcvBuf[1:]
+
+		c.mu.Unlock()
+		_, err := c.host.Write(b)
+		c.mu.Lock()
+
+		if err != nil {
+			if c.state != tcpClosed {
+				c.send(tcpRST|tcpACK, c.sndNxt, c.rcvNxt, nil, nil)
+				c.finish(err)
+			}
+			return
+		}
+
+		old := c.window()
+		c.rcvQueued -= len(b)
+		// tell the guest that the window is reopened
+		if c.state == tcpEstablished && old < tcpRecvBuffer/2 && c.window() >= tcpRecvBuffer/2 {
+			c.sendAck()
+		}
+	}
+}
+
+// abort closes the connection, and resets the guest if rst is set.
+func (c *tcpConn) abort(rst bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == tcpClosed {
+		return
+	}
+
+	if rst {
+		c.send(tcpRST|tcpACK, c.sndNxt, c.rcvNxt, nil, nil)
+	}
+	c.finish(errors.New("connection aborted"))
+}
+
+// finish releases the connection, c.mu should be held.
+func (c *tcpConn) finish(err error) {
+	c.state = tcpClosed
+	c.err = err
+	c.cond.Broadcast()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+
+	if c.host != nil {
+		c.host.Close()
+	}
+
+	c.s.mu.Lock()
+	if c.s.tcp[c.key] == c {
+		delete(c.s.tcp, c.key)
+	}
+	c.s.mu.Unlock()
+}
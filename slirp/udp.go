@@ -0,0 +1,239 @@
+package slirp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	udpIdleTimeout = 60 * time.Second
+	// datagrams waiting to be read from flows of DialUDP
+	udpRecvQueue = 64
+)
+
+type udpFlow struct {
+	s    *Stack
+	key  flowKey
+	conn net.Conn
+	// the source of datagrams sent to the guest
+	src net.IP
+	// set for flows of DialUDP, conn is nil then
+	queue chan []byte
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (s *Stack) handleUDP(dst net.IP, pkt []byte) {
+	if len(pkt) < 8 {
+		return
+	}
+
+	srcPort := binary.BigEndian.Uint16(pkt[0:2])
+	dstPort := binary.BigEndian.Uint16(pkt[2:4])
+	length := int(binary.BigEndian.Uint16(pkt[4:6]))
+	if length < 8 || length > len(pkt) {
+		return
+	}
+	payload := pkt[8:length]
+
+	key := flowKey{guestPort: srcPort, remoteIP: toKey(dst), remotePort: dstPort}
+
+	s.mu.Lock()
+	f, ok := s.udp[key]
+	s.mu.Unlock()
+
+	if ok && f.queue != nil {
+		// dropped if the reader is slow, as the network does
+		select {
+		case f.queue <- append([]byte{}, payload...):
+		default:
+		}
+		return
+	}
+
+	if !ok {
+		addr, err := s.hostAddr(dst, dstPort, protoUDP)
+		if err != nil {
+			return
+		}
+
+		conn, err := net.Dial("udp4", addr)
+		if err != nil {
+			return
+		}
+
+		f = &udpFlow{s: s, key: key, conn: conn, src: append(net.IP{}, dst.To4()...), done: make(chan struct{})}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.udp[key] = f
+		s.mu.Unlock()
+
+		go f.recv()
+	}
+
+	f.conn.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+	f.conn.Write(payload)
+}
+
+// recv relays datagrams back to the guest, until the flow is idle.
+func (f *udpFlow) recv() {
+	defer f.close()
+
+	buf := make([]byte, f.s.cfg.MTU-28)
+	for {
+		n, err := f.conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		f.s.writeUDP(f.src, f.key.remotePort, f.key.guestPort, buf[:n])
+	}
+}
+
+func (f *udpFlow) close() {
+	f.once.Do(func() {
+		close(f.done)
+		if f.conn != nil {
+			f.conn.Close()
+		}
+
+		f.s.mu.Lock()
+		if f.s.udp[f.key] == f {
+			delete(f.s.udp, f.key)
+		}
+		f.s.mu.Unlock()
+	})
+}
+
+func (s *Stack) writeUDP(src net.IP, srcPort, dstPort uint16, payload []byte) error {
+	pkt := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(pkt[0:2], srcPort)
+	binary.BigEndian.PutUint16(pkt[2:4], dstPort)
+	binary.BigEndian.PutUint16(pkt[4:6], uint16(len(pkt)))
+	copy(pkt[8:], payload)
+
+	sum := checksum(pkt, pseudoSum(src, s.cfg.Guest, protoUDP, len(pkt)))
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(pkt[6:8], sum)
+
+	return s.writeIPv4(protoUDP, src, pkt)
+}
+
+// DialUDP sends datagrams to port of the guest, they come from the gateway.
+func (s *Stack) DialUDP(port uint16) (net.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errors.New("network stack is closed")
+	}
+
+	var key flowKey
+	for {
+		key = flowKey{guestPort: port, remoteIP: toKey(s.cfg.Gateway), remotePort: s.allocPort()}
+		if _, ok := s.udp[key]; !ok {
+			break
+		}
+	}
+
+	f := &udpFlow{s: s, key: key, src: s.cfg.Gateway, queue: make(chan []byte, udpRecvQueue), done: make(chan struct{})}
+	s.udp[key] = f
+
+	return &udpConn{f: f}, nil
+}
+
+// udpConn is a connected datagram socket in the view of the host, it is
+// closed with the stack.
+type udpConn struct {
+	f *udpFlow
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *udpConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case p := <-c.f.queue:
+		return copy(b, p), nil
+	case <-c.f.done:
+		return 0, errors.New("use of closed connection")
+	case <-timeout:
+		return 0, errTimeout{}
+	}
+}
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.f.done:
+		return 0, errors.New("use of closed connection")
+	default:
+	}
+
+	if len(b) > c.f.s.cfg.MTU-28 {
+		return 0, errors.New("datagram is larger than the mtu")
+	}
+
+	err := c.f.s.writeUDP(c.f.src, c.f.key.remotePort, c.f.key.guestPort, b)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *udpConn) Close() error {
+	c.f.close()
+	return nil
+}
+
+func (c *udpConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: c.f.src, Port: int(c.f.key.remotePort)}
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: c.f.s.cfg.Guest, Port: int(c.f.key.guestPort)}
+}
+
+func (c *udpConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// writes never block.
+func (c *udpConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type errTimeout struct{}
+
+func (errTimeout) Error() string   { return "i/o timeout" }
+func (errTimeout) Timeout() bool   { return true }
+func (errTimeout) Temporary() bool { return true }
@@ -1,8 +1,14 @@
 package utils
 
 import (
+	"bytes"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 func PathExist(path string) bool {
@@ -10,6 +16,78 @@ func PathExist(path string) bool {
 	return !os.IsNotExist(e)
 }
 
+// RemoveTree removes the tree. files of rootless containers may be in
+// directories without the write permission of the owner, so directories
+// are made writable and retried.
+func RemoveTree(path string) error {
+	err := os.RemoveAll(path)
+	if err == nil || !os.IsPermission(err) {
+		return err
+	}
+
+	filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() {
+			os.Chmod(p, 0700)
+		}
+		return nil
+	})
+
+	return os.RemoveAll(path)
+}
+
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// DiskUsage sums blocks taken by files under paths, hard links are counted
+// once. unreadable files are skipped.
+func DiskUsage(paths ...string) int64 {
+	seen := map[inode]struct{}{}
+
+	var res int64
+	for _, path := range paths {
+		// the root may be a link, like images of tests
+		if p, err := filepath.EvalSymlinks(path); err == nil {
+			path = p
+		}
+
+		filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			st, ok := fi.Sys().(*syscall.Stat_t)
+			if !ok {
+				res += fi.Size()
+				return nil
+			}
+
+			if st.Nlink > 1 {
+				k := inode{dev: uint64(st.Dev), ino: st.Ino}
+				if _, ok := seen[k]; ok {
+					return nil
+				}
+				seen[k] = struct{}{}
+			}
+
+			res += st.Blocks * 512
+			return nil
+		})
+	}
+
+	return res
+}
+
+// Mounted reports whether path is a mount point.
+func Mounted(path string) bool {
+	var st, pst unix.Stat_t
+	if unix.Lstat(path, &st) != nil || unix.Lstat(filepath.Dir(path), &pst) != nil {
+		return false
+	}
+	return st.Dev != pst.Dev
+}
+
 type NopReadCloser struct {
 	io.Reader
 	closed bool
@@ -30,3 +108,89 @@ func (n *NopReadCloser) Close() error {
 func NewNopReadCloser(rd io.Reader) io.ReadCloser {
 	return &NopReadCloser{Reader: rd, closed: false}
 }
+
+// PipeBuffer is an unbounded in-memory pipe, reads block until data is
+// written or the buffer is closed.
+type PipeBuffer struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	err  error
+}
+
+func NewPipeBuffer() *PipeBuffer {
+	p := &PipeBuffer{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *PipeBuffer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return 0, io.ErrClosedPipe
+	}
+
+	n, err := p.buf.Write(b)
+	p.cond.Broadcast()
+	return n, err
+}
+
+func (p *PipeBuffer) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.buf.Len() == 0 && p.err == nil {
+		p.cond.Wait()
+	}
+
+	if p.buf.Len() > 0 {
+		return p.buf.Read(b)
+	}
+
+	return 0, p.err
+}
+
+func (p *PipeBuffer) CloseWithError(err error) error {
+	if err == nil {
+		err = io.EOF
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err == nil {
+		p.err = err
+	}
+	p.cond.Broadcast()
+	return nil
+}
+
+func (p *PipeBuffer) Close() error {
+	return p.CloseWithError(nil)
+}
+
+// Splice copies between a and b in both directions, the write side is
+// half closed on EOF if supported. both are closed on return.
+func Splice(a, b io.ReadWriteCloser) {
+	var wg sync.WaitGroup
+	half := func(dst, src io.ReadWriteCloser) {
+		defer wg.Done()
+
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+
+	wg.Add(2)
+	go half(a, b)
+	go half(b, a)
+	wg.Wait()
+
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/pkg/errors"
+)
+
+// Tar archives path as name, directories are archived recursively. sockets
+// are skipped.
+func Tar(w io.Writer, path, name string) error {
+	tw := tar.NewWriter(w)
+
+	path = filepath.Clean(path)
+	err := filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(file)
+			if err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+
+		hdr.Name = filepath.ToSlash(filepath.Join(name, rel))
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// Untar extracts the archive into dir, symlinks are resolved inside dir,
+// so entries can not escape from it. ownership is not restored.
+func Untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+
+		parent, err := securejoin.SecureJoin(dir, filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(parent, 0755)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(parent, filepath.Base(name))
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		// anything but a directory is replaced
+		if fi, err := os.Lstat(target); err == nil && (!fi.IsDir() || hdr.Typeflag != tar.TypeDir) {
+			err = os.Remove(target)
+			if err != nil {
+				return err
+			}
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.Mkdir(target, mode)
+			if os.IsExist(err) {
+				err = os.Chmod(target, mode)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			err = untarFile(tr, target, mode)
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, target)
+		case tar.TypeLink:
+			var old string
+			old, err = securejoin.SecureJoin(dir, hdr.Linkname)
+			if err == nil {
+				err = os.Link(old, target)
+			}
+		default:
+			err = errors.Errorf("unsupported file type %c", hdr.Typeflag)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "can not extract %s", strings.TrimPrefix(name, "/"))
+		}
+
+		if hdr.Typeflag != tar.TypeSymlink {
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		}
+	}
+}
+
+func untarFile(r io.Reader, target string, mode os.FileMode) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,329 @@
+package local
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/ksuid"
+	"github.com/tidwall/gjson"
+	mtyp "github.com/xhebox/chrootd/meta"
+	"github.com/xhebox/chrootd/store"
+	"github.com/xhebox/chrootd/utils"
+	. "github.com/xhebox/chrootd/volume"
+)
+
+type VolumeManager struct {
+	id         string
+	volumePath string
+	node       store.Store
+	volumes    store.Store
+	refs       *VolumeRefs
+}
+
+// NewVolumeManager keeps volumes under path/volumes, volumes are recorded
+// by names in the store shared by managers of the node.
+func NewVolumeManager(path string, s store.Store, opts ...func(*VolumeManager) error) (*VolumeManager, error) {
+	mgr := &VolumeManager{
+		volumePath: filepath.Join(path, "volumes"),
+		node:       s,
+	}
+
+	for k := range opts {
+		err := opts[k](mgr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := os.MkdirAll(mgr.volumePath, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	mgrid, err := store.LoadOrStore(s, "id", ksuid.New().String())
+	if err != nil {
+		return nil, err
+	}
+	mgr.id = string(mgrid)
+
+	mgr.volumes, err = store.NewWrapStore("volume", s)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr.refs, err = NewVolumeRefs(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// mounts are gone after reboots
+	err = mgr.volumes.List("", func(k string, idx uint64, v []byte) error {
+		vol := &Volume{}
+		err := json.Unmarshal(v, vol)
+		if err != nil {
+			return err
+		}
+
+		q, err := mtyp.LoadQuota(quotaBase(filepath.Join(mgr.volumePath, vol.Name)))
+		if err != nil || q == nil {
+			return err
+		}
+
+		return errors.Wrapf(q.Mount(), "can not mount volume %s", vol.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mgr, nil
+}
+
+// name accepts ids or names of volumes on the node.
+func (m *VolumeManager) name(id string) string {
+	if node, name, err := utils.DecomposeID(id); err == nil && node == m.id {
+		return name
+	}
+	return id
+}
+
+func (m *VolumeManager) getVolume(name string) (uint64, *Volume, error) {
+	idx, v, err := m.volumes.Get(name)
+	if err != nil {
+		return 0, nil, errors.Errorf("volume %s does not exist", name)
+	}
+
+	res := &Volume{}
+	return idx, res, json.Unmarshal(v, res)
+}
+
+// fill adds the usage and users of the volume.
+func (m *VolumeManager) fill(vol *Volume) (*Volume, error) {
+	dir := filepath.Join(m.volumePath, vol.Name)
+
+	q, err := mtyp.LoadQuota(quotaBase(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	if q != nil {
+		vol.Usage, err = q.Usage()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		vol.Usage = uint64(utils.DiskUsage(dir))
+	}
+
+	vol.Users, err = m.refs.Users(vol.Name)
+	return vol, err
+}
+
+// quotaBase is where the quota and the image of the volume are kept, names
+// of volumes may end with suffixes of files, which are kept inside the
+// directory of the volume.
+func quotaBase(dir string) string {
+	return filepath.Join(dir, "volume")
+}
+
+// setup makes the directory of the volume, which is limited to size bytes
+// by a project quota if the filesystem supports, or by a loopback filesystem
+// mounted on data. the directory is removed if it fails.
+func (m *VolumeManager) setup(dir string, size uint64) (err error) {
+	if size > 0 && os.Geteuid() != 0 {
+		return errors.New("sizes of volumes are not supported by rootless daemons")
+	}
+
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	var q *mtyp.Quota
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if q != nil {
+			q.Unmount()
+			q.Clear()
+		}
+		utils.RemoveTree(dir)
+	}()
+
+	data := filepath.Join(dir, "data")
+	if size == 0 {
+		return os.Mkdir(data, 0755)
+	}
+
+	q, err = mtyp.NewProjectQuota(m.node, m.volumePath, size)
+	if err != nil {
+		return err
+	}
+
+	if q == nil {
+		q, err = mtyp.NewDiskQuota(size, quotaBase(dir)+".img", data)
+		if err != nil {
+			return err
+		}
+
+		// data is the root of the filesystem
+		err = os.Remove(filepath.Join(data, "lost+found"))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = mtyp.SaveQuota(quotaBase(dir), q)
+	if err != nil {
+		return err
+	}
+
+	// data inherits the project of the directory
+	if q.Project != 0 {
+		err = q.Apply(dir)
+		if err != nil {
+			return err
+		}
+
+		err = os.Mkdir(data, 0755)
+	}
+
+	return err
+}
+
+func (m *VolumeManager) remove(dir string) error {
+	q, err := mtyp.LoadQuota(quotaBase(dir))
+	if err != nil {
+		return err
+	}
+
+	if q != nil {
+		err = q.Unmount()
+		if err != nil {
+			return err
+		}
+
+		err = q.Clear()
+		if err != nil {
+			return err
+		}
+	}
+
+	return utils.RemoveTree(dir)
+}
+
+func (m *VolumeManager) ID() (string, error) {
+	return m.id, nil
+}
+
+func (m *VolumeManager) Create(vol *Volume) (string, error) {
+	if !ValidName(vol.Name) {
+		return "", errors.Errorf("invalid volume name %s", vol.Name)
+	}
+
+	vol.Id = utils.ComposeID(m.id, vol.Name)
+	vol.Node = m.id
+	vol.CreatedAt = time.Now()
+	vol.Usage = 0
+	vol.Users = nil
+
+	b, err := json.Marshal(vol)
+	if err != nil {
+		return "", err
+	}
+
+	// claims the name before files are made
+	if ok, _ := m.volumes.Has(vol.Name); ok {
+		return "", errors.Errorf("volume %s exists", vol.Name)
+	}
+
+	err = m.volumes.Put(vol.Name, 0, b)
+	if err != nil {
+		return "", errors.Wrapf(err, "can not create volume %s", vol.Name)
+	}
+
+	dir := filepath.Join(m.volumePath, vol.Name)
+
+	err = m.setup(dir, vol.Size)
+	if err != nil {
+		if idx, _, err := m.volumes.Get(vol.Name); err == nil {
+			m.volumes.Delete(vol.Name, idx)
+		}
+		return "", err
+	}
+
+	return vol.Id, nil
+}
+
+func (m *VolumeManager) Get(id string) (*Volume, error) {
+	_, vol, err := m.getVolume(m.name(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return m.fill(vol)
+}
+
+func (m *VolumeManager) Delete(id string) error {
+	name := m.name(id)
+
+	_, v, err := m.volumes.Get(name)
+	if err != nil {
+		return errors.Errorf("volume %s does not exist", name)
+	}
+
+	// containers can not use the volume once it is released
+	err = m.refs.Release(name)
+	if err != nil {
+		return err
+	}
+
+	err = m.remove(filepath.Join(m.volumePath, name))
+	if err != nil {
+		m.volumes.Put(name, 0, v)
+		return err
+	}
+
+	return nil
+}
+
+func (m *VolumeManager) Query(query string, f func(*Volume) error) error {
+	vols := []*Volume{}
+	err := m.volumes.List("", func(k string, idx uint64, v []byte) error {
+		if query == "" || gjson.GetBytes(v, query).Type != gjson.Null {
+			vol := &Volume{}
+			err := json.Unmarshal(v, vol)
+			if err != nil {
+				return err
+			}
+			vols = append(vols, vol)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// usages are filled out of the transaction of the list
+	for _, vol := range vols {
+		vol, err := m.fill(vol)
+		if err != nil {
+			return err
+		}
+
+		err = f(vol)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *VolumeManager) Close() error {
+	return nil
+}
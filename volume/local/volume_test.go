@@ -0,0 +1,336 @@
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	mtest "github.com/xhebox/chrootd/meta/test"
+	"github.com/xhebox/chrootd/store"
+	"github.com/xhebox/chrootd/utils"
+	. "github.com/xhebox/chrootd/volume"
+	vtest "github.com/xhebox/chrootd/volume/test"
+	"golang.org/x/sys/unix"
+)
+
+type TestVolumeManager struct {
+	dir string
+	s   store.Store
+	*VolumeManager
+}
+
+func NewTestVolumeManager() (*TestVolumeManager, error) {
+	dir, err := ioutil.TempDir(os.TempDir(), "temp")
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := store.NewBolt(
+		filepath.Join(dir, "s"),
+		"test",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr, err := NewVolumeManager(dir, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TestVolumeManager{dir: dir, s: s, VolumeManager: mgr}, nil
+}
+
+func (t *TestVolumeManager) Close() error {
+	t.VolumeManager.Close()
+	t.s.Close()
+	mtest.Unmount(t.dir)
+	return os.RemoveAll(t.dir)
+}
+
+func TestVolumeManagerID(t *testing.T) {
+	mgr, err := NewTestVolumeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerID(mgr, t)
+}
+
+func TestVolumeManagerCreate(t *testing.T) {
+	mgr, err := NewTestVolumeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerCreate(mgr, t)
+}
+
+func TestVolumeManagerGet(t *testing.T) {
+	mgr, err := NewTestVolumeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerGet(mgr, t)
+}
+
+func TestVolumeManagerDelete(t *testing.T) {
+	mgr, err := NewTestVolumeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerDelete(mgr, t)
+}
+
+func TestVolumeManagerQuery(t *testing.T) {
+	mgr, err := NewTestVolumeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerQuery(mgr, t)
+}
+
+func TestVolumeManagerSize(t *testing.T) {
+	mgr, err := NewTestVolumeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerSize(mgr, t)
+}
+
+func TestVolumeManagerInUse(t *testing.T) {
+	mgr, err := NewTestVolumeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	id, err := mgr.Create(&Volume{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := NewVolumeRefs(mgr.s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = refs.Add("test", "cntr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err := mgr.Get("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Id != id || len(vol.Users) != 1 || vol.Users[0] != "cntr" {
+		t.Fatalf("unexpected volume %+v", vol)
+	}
+
+	err = mgr.Delete(id)
+	if err == nil {
+		t.Fatal("expect volumes in use to be kept")
+	}
+
+	err = refs.Remove("test", "cntr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mgr.Delete(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = refs.Add("test", "cntr")
+	if err == nil {
+		t.Fatal("expect deleted volumes not to be used")
+	}
+}
+
+func TestVolumeManagerSuffix(t *testing.T) {
+	mgr, err := NewTestVolumeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	_, err = mgr.Create(&Volume{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files of volumes do not collide with other volumes
+	for _, name := range []string{"test.img", "test.quota"} {
+		_, err = mgr.Create(&Volume{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = mgr.Delete(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !utils.PathExist(DataPath(mgr.volumePath, "test")) {
+		t.Fatal("expect test to be kept")
+	}
+
+	// failures keep existing files
+	err = os.Mkdir(filepath.Join(mgr.volumePath, "dir"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mgr.Create(&Volume{Name: "dir"})
+	if err == nil {
+		t.Fatal("expect existing directories to fail")
+	}
+
+	if !utils.PathExist(filepath.Join(mgr.volumePath, "dir")) {
+		t.Fatal("expect dir to be kept")
+	}
+}
+
+func TestVolumeManagerLimit(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("sizes of volumes need root")
+	}
+
+	mgr, err := NewTestVolumeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	_, err = mgr.Create(&Volume{Name: "test", Size: 16 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := DataPath(mgr.volumePath, "test")
+
+	err = ioutil.WriteFile(filepath.Join(data, "small"), make([]byte, 4<<20), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// data is kept across managers, and mounted again
+	mtest.Unmount(mgr.volumePath)
+
+	mgr.VolumeManager, err = NewVolumeManager(mgr.dir, mgr.s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(data, "big"), make([]byte, 32<<20), 0644)
+	if err == nil {
+		t.Fatal("expect writes beyond the size to fail")
+	}
+
+	if _, err := os.Stat(filepath.Join(data, "small")); err != nil {
+		t.Fatal(err)
+	}
+
+	err = mgr.Delete("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fis, err := ioutil.ReadDir(mgr.volumePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fi := range fis {
+		t.Errorf("expect %s to be removed", fi.Name())
+	}
+}
+
+func TestVolumeManagerProjectQuota(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("project quotas need root")
+	}
+
+	dir, err := ioutil.TempDir(os.TempDir(), "temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	img := filepath.Join(dir, "img")
+	mnt := filepath.Join(dir, "mnt")
+	err = os.Mkdir(mnt, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(img, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Truncate(img, 64<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("mkfs.ext4", "-q", "-F", "-O", "quota,project", img).CombinedOutput()
+	if err != nil {
+		t.Skipf("can not make the filesystem: %s", out)
+	}
+
+	out, err = exec.Command("mount", "-o", "loop,prjquota", img, mnt).CombinedOutput()
+	if err != nil {
+		t.Skipf("project quotas are not supported: %s", out)
+	}
+	defer unix.Unmount(mnt, unix.MNT_DETACH)
+
+	s, err := store.NewBolt(filepath.Join(dir, "s"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	mgr, err := NewVolumeManager(mnt, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	_, err = mgr.Create(&Volume{Name: "test", Size: 8 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the volume is limited by the project, not a loopback filesystem
+	if utils.PathExist(filepath.Join(mgr.volumePath, "test", "volume.img")) {
+		t.Fatal("expect a project quota")
+	}
+
+	data := DataPath(mgr.volumePath, "test")
+	err = ioutil.WriteFile(filepath.Join(data, "big"), make([]byte, 16<<20), 0644)
+	if err == nil {
+		t.Fatal("expect writes beyond the size to fail")
+	}
+
+	err = mgr.Delete("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
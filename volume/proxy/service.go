@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/xhebox/chrootd/utils"
+	vtyp "github.com/xhebox/chrootd/volume"
+)
+
+type VolumeService struct {
+	id          string
+	addr        *utils.Addr
+	reg         *api.AgentServiceRegistration
+	cli         *api.Client
+	mgr         vtyp.Manager
+	QueryLimits int
+}
+
+func NewVolumeService(mgr vtyp.Manager, cli *api.Client, svcname string, rpcAddr *utils.Addr) (*VolumeService, error) {
+	svc := &VolumeService{
+		cli:         cli,
+		mgr:         mgr,
+		addr:        rpcAddr,
+		QueryLimits: 64,
+	}
+
+	if cli != nil {
+		id, err := mgr.ID()
+		if err != nil {
+			return nil, err
+		}
+		svc.id = id
+
+		svc.reg = &api.AgentServiceRegistration{
+			ID:      fmt.Sprintf("%s.volume", id),
+			Name:    svcname,
+			Address: svc.addr.Addr(),
+			Port:    svc.addr.Port(),
+			Tags:    []string{svc.id},
+		}
+
+		err = cli.Agent().ServiceRegisterOpts(svc.reg, api.ServiceRegisterOpts{ReplaceExistingChecks: true})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return svc, nil
+}
+
+func (s *VolumeService) ID(ctx context.Context, req *struct{}, res *string) error {
+	*res = s.id
+	return nil
+}
+
+func (s *VolumeService) Create(ctx context.Context, vol *vtyp.Volume, res *string) error {
+	id, err := s.mgr.Create(vol)
+	if err == nil {
+		*res = id
+	}
+	return err
+}
+
+func (s *VolumeService) Get(ctx context.Context, id string, res *vtyp.Volume) error {
+	vol, err := s.mgr.Get(id)
+	if err == nil {
+		*res = *vol
+	}
+	return err
+}
+
+func (s *VolumeService) Delete(ctx context.Context, id string, res *struct{}) error {
+	return s.mgr.Delete(id)
+}
+
+func (s *VolumeService) Query(ctx context.Context, query string, res *[]*vtyp.Volume) error {
+	cnt := 0
+	return s.mgr.Query(query, func(vol *vtyp.Volume) error {
+		*res = append(*res, vol)
+		cnt++
+		if cnt > s.QueryLimits {
+			return errors.New("exceed the query limits")
+		}
+		return nil
+	})
+}
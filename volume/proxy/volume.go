@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xhebox/chrootd/client"
+	vtyp "github.com/xhebox/chrootd/volume"
+)
+
+type VolumeProxy struct {
+	*client.Proxy
+	svc     string
+	Network string
+	Context context.Context
+}
+
+func NewVolumeProxy(svcname string, cli interface{}, opts ...func(*VolumeProxy) error) (vtyp.Manager, error) {
+	mgr := &VolumeProxy{svc: svcname, Context: context.Background(), Network: "tcp"}
+
+	for i := range opts {
+		if err := opts[i](mgr); err != nil {
+			return nil, err
+		}
+	}
+
+	pro, err := client.NewProxy(svcname, mgr.Network, cli, nil)
+	if err != nil {
+		return nil, err
+	}
+	mgr.Proxy = pro
+
+	return mgr, nil
+}
+
+func (m *VolumeProxy) ID() (string, error) {
+	return "", nil
+}
+
+func (m *VolumeProxy) Create(vol *vtyp.Volume) (string, error) {
+	res := ""
+	return res, m.Oneshot(vol.Node, func(cli client.Client) error {
+		return cli.Call(m.Context, m.svc, "Create", vol, &res)
+	})
+}
+
+func (m *VolumeProxy) Get(id string) (*vtyp.Volume, error) {
+	res := &vtyp.Volume{}
+	return res, m.Call(id, func(cli client.Client, svc map[string]string) error {
+		return cli.Call(m.Context, m.svc, "Get", id, res)
+	})
+}
+
+func (m *VolumeProxy) Delete(id string) error {
+	return m.Call(id, func(cli client.Client, svc map[string]string) error {
+		return cli.Call(m.Context, m.svc, "Delete", id, nil)
+	})
+}
+
+func (m *VolumeProxy) Query(query string, f func(*vtyp.Volume) error) error {
+	var mu sync.Mutex
+	return m.Broadcast(func(cli client.Client) error {
+		res := []*vtyp.Volume{}
+
+		err := cli.Call(m.Context, m.svc, "Query", query, &res)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, vol := range res {
+			if err := f(vol); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,306 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/smallnest/rpcx/server"
+	"github.com/xhebox/chrootd/client"
+	mtest "github.com/xhebox/chrootd/meta/test"
+	"github.com/xhebox/chrootd/store"
+	"github.com/xhebox/chrootd/utils"
+	vtyp "github.com/xhebox/chrootd/volume"
+	vloc "github.com/xhebox/chrootd/volume/local"
+	vtest "github.com/xhebox/chrootd/volume/test"
+)
+
+func newTestVolumeProxy(consul bool, t *testing.T) (*TestVolumeProxy, error) {
+	dir, err := ioutil.TempDir(os.TempDir(), "temp")
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := store.NewBolt(
+		filepath.Join(dir, "s"),
+		"test",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s1, err := store.NewWrapStore("l1", s)
+	if err != nil {
+		return nil, err
+	}
+
+	s2, err := store.NewWrapStore("l2", s)
+	if err != nil {
+		return nil, err
+	}
+
+	loc1, err := vloc.NewVolumeManager(dir, s1)
+	if err != nil {
+		return nil, err
+	}
+
+	loc2, err := vloc.NewVolumeManager(filepath.Join(dir, "l1"), s2)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := utils.NewAddrFree()
+
+	lnRPC, err := net.Listen(addr.Network(), addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	addr2 := utils.NewAddrFree()
+
+	lnRPC2, err := net.Listen(addr2.Network(), addr2.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var con *api.Client
+	var tsrv *testutil.TestServer
+	if consul {
+		tsrv, err = testutil.NewTestServerConfigT(t, func(cfg *testutil.TestServerConfig) {
+			cfg.LogLevel = "err"
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		con, err = api.NewClient(&api.Config{
+			Address: tsrv.HTTPAddr,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	srv1 := server.NewServer(
+		func(srv *server.Server) {
+			srv.DisableHTTPGateway = true
+			srv.DisableJSONRPC = true
+		},
+	)
+
+	svc1, err := NewVolumeService(loc1, con, "s", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv1.RegisterName("s", svc1, "")
+	if err != nil {
+		return nil, err
+	}
+
+	go srv1.ServeListener(addr.Network(), lnRPC)
+
+	srv2 := server.NewServer(
+		func(srv *server.Server) {
+			srv.DisableHTTPGateway = true
+			srv.DisableJSONRPC = true
+		},
+	)
+
+	svc2, err := NewVolumeService(loc2, con, "s", addr2)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv2.RegisterName("s", svc2, "")
+	if err != nil {
+		return nil, err
+	}
+
+	go srv2.ServeListener(addr2.Network(), lnRPC2)
+
+	var mgr vtyp.Manager
+	if consul {
+		mgr, err = NewVolumeProxy("s", con)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		cli, err := client.NewClient(addr.Network(), addr.String())
+		if err != nil {
+			return nil, err
+		}
+
+		mgr, err = NewVolumeProxy("s", cli)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &TestVolumeProxy{dir: dir,
+		tsrv:    tsrv,
+		s:       s,
+		srv1:    srv1,
+		srv2:    srv2,
+		loc1:    loc1,
+		loc2:    loc2,
+		Manager: mgr}, nil
+}
+
+func NewTestVolumeProxy(t *testing.T) (*TestVolumeProxy, error) {
+	return newTestVolumeProxy(false, t)
+}
+
+func NewTestVolumeProxyConsul(t *testing.T) (*TestVolumeProxy, error) {
+	return newTestVolumeProxy(true, t)
+}
+
+type TestVolumeProxy struct {
+	dir  string
+	tsrv *testutil.TestServer
+	s    store.Store
+	loc1 vtyp.Manager
+	srv1 *server.Server
+	loc2 vtyp.Manager
+	srv2 *server.Server
+	vtyp.Manager
+}
+
+func (t *TestVolumeProxy) Close() error {
+	t.Manager.Close()
+	t.srv2.Shutdown(context.Background())
+	t.loc2.Close()
+	t.srv1.Shutdown(context.Background())
+	t.loc1.Close()
+	if t.tsrv != nil {
+		t.tsrv.Stop()
+	}
+	t.s.Close()
+	mtest.Unmount(t.dir)
+	return os.RemoveAll(t.dir)
+}
+
+func TestVolumeManagerID(t *testing.T) {
+	mgr, err := NewTestVolumeProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerID(mgr, t)
+}
+
+func TestVolumeManagerCreate(t *testing.T) {
+	mgr, err := NewTestVolumeProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerCreate(mgr, t)
+}
+
+func TestVolumeManagerGet(t *testing.T) {
+	mgr, err := NewTestVolumeProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerGet(mgr, t)
+}
+
+func TestVolumeManagerDelete(t *testing.T) {
+	mgr, err := NewTestVolumeProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerDelete(mgr, t)
+}
+
+func TestVolumeManagerQuery(t *testing.T) {
+	mgr, err := NewTestVolumeProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerQuery(mgr, t)
+}
+
+func TestVolumeManagerSize(t *testing.T) {
+	mgr, err := NewTestVolumeProxy(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerSize(mgr, t)
+}
+
+func TestVolumeManagerConsulID(t *testing.T) {
+	mgr, err := NewTestVolumeProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerID(mgr, t)
+}
+
+func TestVolumeManagerConsulCreate(t *testing.T) {
+	mgr, err := NewTestVolumeProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerCreate(mgr, t)
+}
+
+func TestVolumeManagerConsulGet(t *testing.T) {
+	mgr, err := NewTestVolumeProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerGet(mgr, t)
+}
+
+func TestVolumeManagerConsulDelete(t *testing.T) {
+	mgr, err := NewTestVolumeProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerDelete(mgr, t)
+}
+
+func TestVolumeManagerConsulQuery(t *testing.T) {
+	mgr, err := NewTestVolumeProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerQuery(mgr, t)
+}
+
+func TestVolumeManagerConsulSize(t *testing.T) {
+	mgr, err := NewTestVolumeProxyConsul(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+
+	vtest.TestVolumeManagerSize(mgr, t)
+}
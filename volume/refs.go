@@ -0,0 +1,108 @@
+package volume
+
+import (
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/xhebox/chrootd/store"
+)
+
+// the store is opened by one process, references are changed under the
+// lock, so that volumes are not released while being added to containers.
+var refsMu sync.Mutex
+
+// VolumeRefs tracks containers using volumes in the store shared by managers
+// of a node. references are keyed by name/cntrid, volumes are recorded by
+// names.
+type VolumeRefs struct {
+	s       store.Store
+	volumes store.Store
+}
+
+func NewVolumeRefs(s store.Store) (*VolumeRefs, error) {
+	refs, err := store.NewWrapStore("volumeref", s)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes, err := store.NewWrapStore("volume", s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VolumeRefs{s: refs, volumes: volumes}, nil
+}
+
+// Add fails if the volume does not exist, or has been released.
+func (r *VolumeRefs) Add(name, cntrid string) error {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	if ok, _ := r.volumes.Has(name); !ok {
+		return errors.Errorf("volume %s does not exist", name)
+	}
+
+	k := path.Join(name, cntrid)
+	if ok, _ := r.s.Has(k); ok {
+		return nil
+	}
+
+	return r.s.Put(k, 0, nil)
+}
+
+func (r *VolumeRefs) Remove(name, cntrid string) error {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	k := path.Join(name, cntrid)
+	idx, _, err := r.s.Get(k)
+	if err != nil {
+		return nil
+	}
+
+	return r.s.Delete(k, idx)
+}
+
+// Users returns containers using the volume.
+func (r *VolumeRefs) Users(name string) ([]string, error) {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	return r.users(name)
+}
+
+func (r *VolumeRefs) users(name string) ([]string, error) {
+	res := []string{}
+	err := r.s.List(name+"/", func(k string, idx uint64, v []byte) error {
+		if strings.HasPrefix(k, name+"/") {
+			res = append(res, strings.TrimPrefix(k, name+"/"))
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Release removes the record of the volume, it fails if the volume is in
+// use.
+func (r *VolumeRefs) Release(name string) error {
+	refsMu.Lock()
+	defer refsMu.Unlock()
+
+	users, err := r.users(name)
+	if err != nil {
+		return err
+	}
+
+	if len(users) > 0 {
+		return errors.Errorf("volume %s is used by containers %s", name, strings.Join(users, ", "))
+	}
+
+	idx, _, err := r.volumes.Get(name)
+	if err != nil {
+		return errors.Errorf("volume %s does not exist", name)
+	}
+
+	return r.volumes.Delete(name, idx)
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/xhebox/chrootd/volume"
+)
+
+func TestVolumeManagerID(mgr Manager, t *testing.T) {
+	_, err := mgr.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVolumeManagerCreate(mgr Manager, t *testing.T) {
+	_, err := mgr.Create(&Volume{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mgr.Create(&Volume{Name: "test"})
+	if err == nil {
+		t.Fatal("expect names of volumes to be unique")
+	}
+
+	for _, name := range []string{"", "..", "a/b", "a,b"} {
+		_, err = mgr.Create(&Volume{Name: name})
+		if err == nil {
+			t.Fatalf("expect %q to be invalid", name)
+		}
+	}
+}
+
+func TestVolumeManagerGet(mgr Manager, t *testing.T) {
+	id, err := mgr.Create(&Volume{
+		Name:   "test",
+		Labels: map[string]string{"app": "db"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err := mgr.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Id != id || vol.Name != "test" || vol.Labels["app"] != "db" || vol.CreatedAt.IsZero() {
+		t.Fatalf("unexpected volume %+v", vol)
+	}
+}
+
+func TestVolumeManagerDelete(mgr Manager, t *testing.T) {
+	id, err := mgr.Create(&Volume{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mgr.Delete(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mgr.Get(id)
+	if err == nil {
+		t.Fatal("deleted, but still found")
+	}
+
+	// the name is free again
+	_, err = mgr.Create(&Volume{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVolumeManagerQuery(mgr Manager, t *testing.T) {
+	_, err := mgr.Create(&Volume{Name: "test1", Labels: map[string]string{"app": "db"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = mgr.Create(&Volume{Name: "test2", Labels: map[string]string{"app": "web"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := []*Volume{}
+	err = mgr.Query(`[@this].#(labels.app=="db")`, func(v *Volume) error {
+		res = append(res, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 1 || res[0].Name != "test1" {
+		t.Fatalf("unexpected volumes %+v", res)
+	}
+}
+
+func TestVolumeManagerSize(mgr Manager, t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("sizes of volumes need root")
+	}
+
+	size := uint64(16 << 20)
+
+	id, err := mgr.Create(&Volume{Name: "test", Size: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err := mgr.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.Size != size || vol.Usage > size {
+		t.Fatalf("unexpected usage %d / %d", vol.Usage, vol.Size)
+	}
+
+	err = mgr.Delete(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,61 @@
+package volume
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// sources of mounts in metadata with the prefix refer to volumes on the node
+// of the container.
+const MountPrefix = "volume:"
+
+// the id is of form node,name. node suggests which node the volume will be
+// created on. a zero size means the volume is unlimited.
+type Volume struct {
+	Id        string            `json:"id"`
+	Node      string            `json:"node"`
+	Name      string            `json:"name"`
+	Size      uint64            `json:"size"`
+	Labels    map[string]string `json:"labels"`
+	CreatedAt time.Time         `json:"createdAt"`
+	// Usage and Users are filled by Get and Query
+	Usage uint64   `json:"usage"`
+	Users []string `json:"users"`
+}
+
+// MountName returns the volume referred by the source of a mount, or an
+// empty string.
+func MountName(source string) string {
+	if !strings.HasPrefix(source, MountPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(source, MountPrefix)
+}
+
+// ValidName reports whether the name can be used by volumes.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// DataPath is where files of the volume are, under the volume path of a
+// node.
+func DataPath(volumes, name string) string {
+	return filepath.Join(volumes, name, "data")
+}
+
+type Manager interface {
+	ID() (string, error)
+
+	Create(*Volume) (string, error)
+	Get(string) (*Volume, error)
+	// Delete removes the volume with its data, it fails if the volume is
+	// used by containers.
+	Delete(string) error
+	Query(string, func(*Volume) error) error
+
+	Close() error
+}